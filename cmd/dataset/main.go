@@ -0,0 +1,79 @@
+// Команда dataset выгружает размеченную историю диагнозов в схеме признаков
+// ML-сервиса — для переобучения модели из ml/ на записанных прогонах:
+//
+//	go run ./cmd/dataset -since 24h -scenario oltp,olap -format csv -o dataset.csv
+//
+// Подключение к базе — как у сервера (DATABASE_URL или POSTGRES_*).
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/lypolix/pg_load_profile/internal/dataset"
+	"github.com/lypolix/pg_load_profile/internal/storage"
+)
+
+func main() {
+	from := flag.String("from", "", "начало периода, RFC3339 (по умолчанию now - since)")
+	to := flag.String("to", "", "конец периода, RFC3339 (по умолчанию сейчас)")
+	since := flag.Duration("since", 7*24*time.Hour, "длина периода, если -from не задан")
+	scenarios := flag.String("scenario", "", "сценарии через запятую (по умолчанию все)")
+	format := flag.String("format", dataset.FormatCSV, "csv или jsonl")
+	output := flag.String("o", "", "файл (по умолчанию stdout)")
+	flag.Parse()
+
+	_ = godotenv.Load()
+
+	f := dataset.Filter{To: time.Now()}
+	if *to != "" {
+		t, err := time.Parse(time.RFC3339, *to)
+		if err != nil {
+			log.Fatalf("Invalid -to: %v", err)
+		}
+		f.To = t
+	}
+	f.From = f.To.Add(-*since)
+	if *from != "" {
+		t, err := time.Parse(time.RFC3339, *from)
+		if err != nil {
+			log.Fatalf("Invalid -from: %v", err)
+		}
+		f.From = t
+	}
+	for _, s := range strings.Split(*scenarios, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			f.Scenarios = append(f.Scenarios, s)
+		}
+	}
+
+	pool, err := storage.ConnectDB()
+	if err != nil {
+		log.Fatalf("Failed to connect to DB: %v", err)
+	}
+	defer pool.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	n, err := dataset.Export(context.Background(), pool, f, *format, w)
+	if err != nil {
+		log.Fatalf("Export failed after %d rows: %v", n, err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d rows (%s .. %s)\n", n, f.From.Format(time.RFC3339), f.To.Format(time.RFC3339))
+}
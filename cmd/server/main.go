@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,25 +17,25 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/lypolix/pg_load_profile/internal/analyzer"
+	"github.com/lypolix/pg_load_profile/internal/autopilot"
 	"github.com/lypolix/pg_load_profile/internal/collector"
 	"github.com/lypolix/pg_load_profile/internal/configurator"
+	"github.com/lypolix/pg_load_profile/internal/dataset"
+	"github.com/lypolix/pg_load_profile/internal/evaluation"
 	"github.com/lypolix/pg_load_profile/internal/generator"
 	"github.com/lypolix/pg_load_profile/internal/models"
 	"github.com/lypolix/pg_load_profile/internal/storage"
 	"github.com/lypolix/pg_load_profile/internal/client"
 )
 
-type ScenarioInfo struct {
-	LoadScenario string    `json:"load_scenario"` // Какую нагрузку дали (oltp, olap...)
-	ActiveConfig string    `json:"active_config"` // Какой пресет настроек применили
-	StartTime    time.Time `json:"start_time"`
-}
-
 type GlobalState struct {
 	mu              sync.RWMutex
 	LatestDiagnosis analyzer.Diagnosis
+	Stable          analyzer.StableState
+	Windows         map[string]analyzer.WindowSummary
 	LastUpdate      time.Time
-	CurrentScenario *ScenarioInfo
+	CurrentScenario *models.ScenarioInfo
+	FinishedRunID   int64 // последний завершившийся прогон нагрузки
 }
 
 var state GlobalState
@@ -48,6 +51,25 @@ func main() {
 	defer pool.Close()
 	fmt.Println("Connected to PostgreSQL successfully.")
 
+	// Ресурсы хоста БД: от них считаются значения пресетов
+	res := configurator.DetectResources(pool)
+	configurator.SetResources(res)
+	fmt.Printf("DB host resources: %d MB RAM, %d cores, %s, max_connections=%d\n",
+		res.TotalMemory>>20, res.CPUCores, res.Storage, res.MaxConnections)
+
+	// Пользовательские пресеты нужны до загрузки правил: правила ссылаются на пресеты
+	if err := configurator.LoadPresets(pool); err != nil {
+		log.Printf("Failed to load user presets, using built-in only: %v", err)
+	}
+
+	// Библиотека сценариев нагрузки: манифесты *.json из каталога сценариев
+	dir := generator.ScenariosDir()
+	n, err := generator.LoadScenarios(dir)
+	if err != nil {
+		log.Printf("Some load scenarios were skipped: %v", err)
+	}
+	fmt.Printf("Loaded %d load scenarios from %s\n", n, dir)
+
 	// 2. Запуск коллектора
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -59,34 +81,150 @@ func main() {
 	// 3. Запуск анализатора
 	calc := analyzer.NewCalculator(pool)
 
+	// Правила классификатора: встроенные или из внешнего файла (с hot-reload)
+	if rulesFile := os.Getenv("CLASSIFIER_RULES_FILE"); rulesFile != "" {
+		if err := analyzer.DefaultEngine().LoadFile(rulesFile); err != nil {
+			log.Fatalf("Failed to load classifier rules: %v", err)
+		}
+		go analyzer.DefaultEngine().Watch(ctx, 10*time.Second)
+		fmt.Printf("Classifier rules loaded from %s\n", rulesFile)
+	}
+
+	// Гистерезис: профиль становится стабильным, только если побеждает несколько окон подряд
+	stabilizer := analyzer.NewStabilizer(analyzer.StabilizerConfigFromEnv())
+
 	go func() {
-		ticker := time.NewTicker(5 * time.Second)
+		ticker := time.NewTicker(analyzer.SampleInterval)
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				metrics, err := calc.CalculateMetrics(ctx, 30*time.Second)
+				if err := calc.Sample(ctx); err != nil {
+					log.Printf("[ERROR] Sampling stats: %v", err)
+					continue
+				}
+
+				metrics, err := calc.CalculateMetrics(ctx, analyzer.DiagnosisWindow)
 				if err != nil {
 					log.Printf("[ERROR] Calculating metrics: %v", err)
 					continue
 				}
 
+				windows, err := calc.CalculateWindows(ctx, analyzer.StatusWindows)
+				if err != nil {
+					log.Printf("[ERROR] Calculating windows: %v", err)
+				}
+
 				diagnosis := analyzer.ClassifyWorkload(metrics)
+				stable := stabilizer.Update(diagnosis, time.Now())
 
 				state.mu.Lock()
 				state.LatestDiagnosis = diagnosis
+				state.Stable = stable
+				state.Windows = analyzer.SummarizeWindows(windows)
 				state.LastUpdate = time.Now()
+				record := storage.DiagnosisRecord{
+					RecordedAt: state.LastUpdate,
+					Diagnosis:  diagnosis,
+				}
+				if state.CurrentScenario != nil {
+					groundTruth := *state.CurrentScenario
+					record.GroundTruth = &groundTruth
+				}
 				state.mu.Unlock()
 
-				printMetricsToConsole(metrics, diagnosis)
+				if err := storage.SaveDiagnosis(ctx, pool, record); err != nil {
+					log.Printf("[ERROR] Saving diagnosis history: %v", err)
+				}
+
+				printMetricsToConsole(metrics, diagnosis, stable)
 			}
 		}
 	}()
 
-	// 4. Запуск HTTP сервера
+	// 4. Автопилот тюнинга (opt-in: AUTOPILOT_ENABLED=true или POST /autopilot/resume)
+	pilot := autopilot.New(autopilot.ConfigFromEnv(), pool, calc,
+		func() analyzer.StableState {
+			state.mu.RLock()
+			defer state.mu.RUnlock()
+			return state.Stable
+		},
+		// Нагрузка для сравнения до/после: прогон и фаза из ground truth
+		func() string {
+			state.mu.RLock()
+			defer state.mu.RUnlock()
+			if state.CurrentScenario == nil || state.CurrentScenario.RunID == 0 {
+				return ""
+			}
+			return fmt.Sprintf("run %d %s %s", state.CurrentScenario.RunID, state.CurrentScenario.LoadScenario, state.CurrentScenario.Phase)
+		},
+		func(preset string) {
+			state.mu.Lock()
+			defer state.mu.Unlock()
+			if state.CurrentScenario == nil {
+				state.CurrentScenario = &models.ScenarioInfo{}
+			}
+			state.CurrentScenario.ActiveConfig = preset
+		},
+	)
+	if os.Getenv("AUTOPILOT_ENABLED") == "true" {
+		pilot.Resume("enabled by AUTOPILOT_ENABLED")
+	}
+	pilot.Start(ctx)
+
+	// 5. Запуск HTTP сервера
 	mlClient := client.NewMLClient()
-	setupHTTPServer(pool, mlClient)
+	restarter := configurator.NewRestarter(pool, configurator.RestartCommandFromEnv())
+
+	// Фазы составных сценариев меняют ожидаемый профиль посреди прогона,
+	// а после прогона разметка снимается: диагнозы без нагрузки не должны
+	// попасть в историю с меткой сценария
+	loads := generator.NewManager(
+		func(run generator.LoadRun, phase generator.Phase) {
+			state.mu.Lock()
+			defer state.mu.Unlock()
+			if state.CurrentScenario == nil || state.CurrentScenario.RunID != run.ID {
+				return
+			}
+			now := time.Now()
+			state.CurrentScenario.Phase = phase.Label
+			state.CurrentScenario.PhaseStart = &now
+			state.CurrentScenario.ExpectedProfile = phase.ExpectedProfile
+		},
+		func(run generator.LoadRun) {
+			state.mu.Lock()
+			defer state.mu.Unlock()
+			state.FinishedRunID = run.ID
+			if state.CurrentScenario == nil || state.CurrentScenario.RunID != run.ID {
+				return
+			}
+			state.CurrentScenario.LoadScenario = ""
+			state.CurrentScenario.Load = nil
+			state.CurrentScenario.ExpectedProfile = ""
+			state.CurrentScenario.Phase = ""
+			state.CurrentScenario.PhaseStart = nil
+			state.CurrentScenario.RunID = 0
+		},
+	)
+	// Оценка классификаторов сама меняет пресеты и запускает нагрузку — разметка та же, что при ручном запуске
+	evaluator := evaluation.New(pool, calc, mlClient, loads,
+		func(label, preset string) {
+			state.mu.Lock()
+			if state.CurrentScenario == nil {
+				state.CurrentScenario = &models.ScenarioInfo{}
+			}
+			state.CurrentScenario.ActiveConfig = label
+			state.mu.Unlock()
+			pilot.SetActivePreset(preset)
+		},
+		markLoadStarted,
+		// Автопилот переключал бы пресеты посреди прогонов; после оценки включается обратно, если был включен
+		func() func() {
+			return pilot.Suspend("paused for classifier evaluation")
+		},
+	)
+	setupHTTPServer(pool, mlClient, calc, pilot, restarter, loads, evaluator)
 	select {}
 }
 
@@ -95,7 +233,7 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Actor")
 		
 		// Обработка preflight запросов
 		if r.Method == "OPTIONS" {
@@ -107,7 +245,7 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
+func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient, calc *analyzer.Calculator, pilot *autopilot.Autopilot, restarter *configurator.Restarter, loads *generator.Manager, evaluator *evaluation.Evaluator) {
 
 	// -------------------------------------------------------------------------
 	// Эндпоинт для получения предсказания от ML сервиса
@@ -157,6 +295,9 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 	// -------------------------------------------------------------------------
 	// Эндпоинт 1: Применение ПРЕСЕТА конфигурации БД
 	// GET /config/apply?preset=oltp
+	// GET /config/apply?preset=oltp&dry_run=true — только показать, что изменится
+	// GET /config/apply?preset=reporting&role=report — только для роли/базы (?database=, ?role=);
+	//     серверные параметры пресета при этом пропускаются (skipped)
 	// -------------------------------------------------------------------------
 	http.HandleFunc("/config/apply", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		preset := r.URL.Query().Get("preset")
@@ -165,9 +306,20 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 			return
 		}
 
-		if err := configurator.ApplyPreset(pool, preset); err != nil {
+		if isDryRun(r) {
+			plan, err := configurator.PlanPreset(pool, preset, requestScope(r))
+			writePlan(w, plan, err)
+			return
+		}
+
+		change, err := configurator.ApplyPreset(pool, preset, changeMeta(r, configurator.SourcePreset))
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, configurator.ErrInvalidConfig) {
+				code = http.StatusBadRequest
+			}
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(code)
 			json.NewEncoder(w).Encode(map[string]string{
 				"status": "error",
 				"error":  fmt.Sprintf("Failed to apply preset: %v", err),
@@ -175,18 +327,27 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 			return
 		}
 
-		state.mu.Lock()
-		if state.CurrentScenario == nil {
-			state.CurrentScenario = &ScenarioInfo{}
+		// Пресет для отдельной базы/роли не меняет конфигурацию кластера
+		if change.Scope.IsCluster() {
+			state.mu.Lock()
+			if state.CurrentScenario == nil {
+				state.CurrentScenario = &models.ScenarioInfo{}
+			}
+			state.CurrentScenario.ActiveConfig = preset
+			state.mu.Unlock()
+			pilot.SetActivePreset(preset)
 		}
-		state.CurrentScenario.ActiveConfig = preset
-		state.mu.Unlock()
 
-		response := map[string]string{
+		response := map[string]interface{}{
 			"status":      "success",
 			"preset":      preset,
 			"message":     fmt.Sprintf("Successfully applied DB configuration for profile: %s", preset),
-			"description": "PostgreSQL configuration reloaded. Check postgresql.conf changes.",
+			"description": applyDescription(change),
+			"change_id":   change.ID,
+			"scope":       change.Scope,
+			"previous":    change.Previous,
+			"params":      change.Params,
+			"skipped":     change.Skipped,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -226,6 +387,9 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 	// Эндпоинт 2: Ручное изменение параметров (PATCH)
 	// PATCH /config/custom 
 	// Body: {"work_mem": "64MB"}
+	// ?dry_run=true — только показать, что изменится
+	// ?database=app&role=report — ALTER DATABASE / ALTER ROLE ... SET вместо ALTER SYSTEM
+	//     (значение DEFAULT убирает параметр из области)
 	// -------------------------------------------------------------------------
 	http.HandleFunc("/config/custom", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPatch && r.Method != http.MethodPost {
@@ -239,9 +403,20 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 			return
 		}
 
-		if err := configurator.ApplyCustomConfig(pool, configMap); err != nil {
+		if isDryRun(r) {
+			plan, err := configurator.PlanCustomConfig(pool, configMap, requestScope(r))
+			writePlan(w, plan, err)
+			return
+		}
+
+		change, err := configurator.ApplyCustomConfig(pool, configMap, changeMeta(r, configurator.SourceCustom))
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, configurator.ErrInvalidConfig) {
+				code = http.StatusBadRequest
+			}
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(code)
 			json.NewEncoder(w).Encode(map[string]string{
 				"status": "error",
 				"error":  fmt.Sprintf("Failed to apply custom config: %v", err),
@@ -254,6 +429,12 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 			"status": "success",
 			"message": "Custom configuration applied.",
 			"applied_settings": configMap,
+			"description": applyDescription(change),
+			"change_id": change.ID,
+			"scope": change.Scope,
+			"previous": change.Previous,
+			"params": change.Params,
+			"skipped": change.Skipped,
 		})
 	}))
 
@@ -261,6 +442,8 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 	// Эндпоинт 3: Применение РЕКОМЕНДАЦИЙ AI (POST)
 	// POST /config/apply-recommendations
 	// Body (optional): {"ml_profile": "olap"} - профиль от ML сервиса
+	// ?dry_run=true — только показать, что изменится
+	// ?database=&role= — применить только параметры уровня сессии для базы/роли
 	// -------------------------------------------------------------------------
 	http.HandleFunc("/config/apply-recommendations", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -307,22 +490,10 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 			}
 		}
 
-		// Маппинг профилей ML на пресеты конфигурации
-		profileToPreset := map[string]string{
-			"oltp":     "oltp",
-			"olap":     "olap",
-			"iot":      "write_heavy",
-			"locks":    "high_concurrency",
-			"reporting": "reporting",
-			"mixed":    "mixed",
-			"etl":      "etl",
-			"cold":     "cold",
-			"init":     "oltp", // по умолчанию для init
-		}
-
 		// Если есть профиль от ML, применяем соответствующий пресет
 		if mlProfile != "" {
-			preset, ok := profileToPreset[mlProfile]
+			// Маппинг профилей ML на пресеты — из хранилища пресетов (ml_profiles)
+			preset, ok := configurator.PresetForMLProfile(mlProfile)
 			if !ok {
 				log.Printf("[apply-recommendations] Unknown ML profile: %s, using fallback oltp", mlProfile)
 				preset = "oltp" // fallback
@@ -330,8 +501,19 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 				log.Printf("[apply-recommendations] Mapped ML profile %s to preset %s", mlProfile, preset)
 			}
 
+			if isDryRun(r) {
+				plan, err := configurator.PlanPreset(pool, preset, requestScope(r))
+				writePlan(w, plan, err)
+				return
+			}
+
 			// Применяем пресет
-			if err := configurator.ApplyPreset(pool, preset); err != nil {
+			meta := changeMeta(r, configurator.SourceRecommendations)
+			if meta.Reason == "" {
+				meta.Reason = "ML profile " + mlProfile
+			}
+			change, err := configurator.ApplyPreset(pool, preset, meta)
+			if err != nil {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusInternalServerError)
 				json.NewEncoder(w).Encode(map[string]string{
@@ -342,16 +524,20 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 			}
 
 			// Обновляем стейт - ВАЖНО: не меняем LoadScenario, только ActiveConfig
-			state.mu.Lock()
-			if state.CurrentScenario == nil {
-				state.CurrentScenario = &ScenarioInfo{}
+			// (пресет для отдельной базы/роли конфигурацию кластера не меняет)
+			if change.Scope.IsCluster() {
+				state.mu.Lock()
+				if state.CurrentScenario == nil {
+					state.CurrentScenario = &models.ScenarioInfo{}
+				}
+				// Сохраняем текущий LoadScenario, чтобы не потерять информацию о нагрузке
+				oldLoadScenario := state.CurrentScenario.LoadScenario
+				state.CurrentScenario.ActiveConfig = preset
+				state.CurrentScenario.LoadScenario = oldLoadScenario // Восстанавливаем нагрузку
+				log.Printf("[apply-recommendations] Updated ActiveConfig to %s, LoadScenario remains %s", preset, oldLoadScenario)
+				state.mu.Unlock()
+				pilot.SetActivePreset(preset)
 			}
-			// Сохраняем текущий LoadScenario, чтобы не потерять информацию о нагрузке
-			oldLoadScenario := state.CurrentScenario.LoadScenario
-			state.CurrentScenario.ActiveConfig = preset
-			state.CurrentScenario.LoadScenario = oldLoadScenario // Восстанавливаем нагрузку
-			log.Printf("[apply-recommendations] Updated ActiveConfig to %s, LoadScenario remains %s", preset, oldLoadScenario)
-			state.mu.Unlock()
 
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]interface{}{
@@ -359,6 +545,12 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 				"message":       fmt.Sprintf("ML recommendations applied successfully. Profile: %s, Preset: %s", mlProfile, preset),
 				"ml_profile":    mlProfile,
 				"applied_preset": preset,
+				"description":   applyDescription(change),
+				"change_id":     change.ID,
+				"scope":         change.Scope,
+				"previous":      change.Previous,
+				"params":        change.Params,
+				"skipped":       change.Skipped,
 			})
 			return
 		}
@@ -367,6 +559,7 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 		state.mu.RLock()
 		recommendations := state.LatestDiagnosis.Tuning
 		profile := state.LatestDiagnosis.Profile
+		preset := state.LatestDiagnosis.Preset
 		state.mu.RUnlock()
 
 		if profile == "" || profile == "IDLE" {
@@ -379,8 +572,20 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 			return
 		}
 
+		if isDryRun(r) {
+			plan, err := configurator.PlanRecommendations(pool, recommendations, requestScope(r))
+			writePlan(w, plan, err)
+			return
+		}
+
 		// Применяем рекомендации
-		if err := configurator.ApplyRecommendations(pool, recommendations); err != nil {
+		meta := changeMeta(r, configurator.SourceRecommendations)
+		meta.Preset = preset
+		if meta.Reason == "" {
+			meta.Reason = "diagnosis " + profile
+		}
+		change, err := configurator.ApplyRecommendations(pool, recommendations, meta)
+		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{
@@ -391,58 +596,214 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 		}
 
 		// Обновляем стейт
-		state.mu.Lock()
-		if state.CurrentScenario == nil {
-			state.CurrentScenario = &ScenarioInfo{}
+		if change.Scope.IsCluster() {
+			state.mu.Lock()
+			if state.CurrentScenario == nil {
+				state.CurrentScenario = &models.ScenarioInfo{}
+			}
+			state.CurrentScenario.ActiveConfig = "AI_RECOMMENDED (" + profile + ")"
+			state.mu.Unlock()
+			pilot.SetActivePreset(preset)
 		}
-		state.CurrentScenario.ActiveConfig = "AI_RECOMMENDED (" + profile + ")"
-		state.mu.Unlock()
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status": "success",
 			"message": "Recommendations applied successfully.",
 			"applied_config": recommendations,
+			"description": applyDescription(change),
+			"change_id": change.ID,
+			"scope": change.Scope,
+			"previous": change.Previous,
+			"params": change.Params,
+			"skipped": change.Skipped,
 		})
 	}))
 
 	// -------------------------------------------------------------------------
 	// Эндпоинт 4: Запуск нагрузки
-	// GET /load/start?scenario=oltp
+	// GET /load/start?scenario=oltp (список сценариев — GET /scenarios)
+	// GET /load/start?scenario=oltp&duration=10m&clients=20&jobs=4&rate=500&scale=100
+	//     — интенсивность (пропущенные параметры — значения сценария по умолчанию)
+	// GET /load/start?scenario=replay_prod&factor=2 — rate сценария, умноженный на factor
+	// GET /load/start?scenario=replay_prod&target=test — нагрузка на базу из LOAD_TARGET_TEST
+	//     — 409, если другой прогон еще идет
+	// GET /load/result — TPS, ошибки и гистограммы задержек последнего прогона
+	// GET /load/runs — все прогоны (новые первыми)
+	// GET /load/runs/{id} — статус, время и итог прогона
+	// GET /load/runs/{id}/report — кривые прогона рядом с метриками сервера за то же время
+	// POST /load/runs/{id}/stop — остановить прогон
+	// GET /scenarios — библиотека сценариев
 	// -------------------------------------------------------------------------
 	http.HandleFunc("/load/start", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		scenario := r.URL.Query().Get("scenario")
 		if scenario == "" {
-			http.Error(w, "Usage: /load/start?scenario=[oltp|olap|iot...]", http.StatusBadRequest)
+			http.Error(w, "Usage: /load/start?scenario=[oltp|olap|iot...] (see GET /scenarios)", http.StatusBadRequest)
 			return
 		}
-
-		state.mu.Lock()
-		if state.CurrentScenario == nil {
-			state.CurrentScenario = &ScenarioInfo{}
+		manifest, ok := generator.LookupScenario(scenario)
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status": "error",
+				"error":  fmt.Sprintf("unknown scenario %q", scenario),
+			})
+			return
 		}
-		state.CurrentScenario.LoadScenario = scenario
-		state.CurrentScenario.StartTime = time.Now()
-		state.mu.Unlock()
 
-		go func() {
-			fmt.Printf("[GENERATOR] Starting Business Scenario: %s\n", scenario)
-			if err := generator.RunBusinessScenario(scenario); err != nil {
-				fmt.Printf("[GENERATOR] Error: %v\n", err)
-			} else {
-				fmt.Printf("[GENERATOR] Finished: %s\n", scenario)
+		params, err := parseLoadParams(r)
+		if v := r.URL.Query().Get("factor"); v != "" && err == nil {
+			factor, errFactor := strconv.ParseFloat(v, 64)
+			switch {
+			case errFactor != nil || factor <= 0:
+				err = fmt.Errorf("%w: factor must be a positive number", generator.ErrInvalidParams)
+			case params.Rate != 0:
+				err = fmt.Errorf("%w: use either rate or factor", generator.ErrInvalidParams)
+			case manifest.Rate == 0:
+				err = fmt.Errorf("%w: scenario %s has no default rate to scale", generator.ErrInvalidParams, scenario)
+			default:
+				params.Rate = manifest.Rate * factor
 			}
-		}()
+		}
+		if err == nil {
+			params, err = generator.ResolveParams(r.Context(), scenario, params)
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status": "error",
+				"error":  err.Error(),
+			})
+			return
+		}
+
+		run, err := loads.Start(scenario, params)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status": "error",
+				"error":  err.Error(),
+			})
+			return
+		}
+
+		markLoadStarted(run, manifest)
 
-		response := map[string]string{
+		response := map[string]interface{}{
 			"status":      "started",
+			"run_id":      run.ID,
 			"scenario":    scenario,
+			"driver":      run.Driver,
+			"params":      params,
 			"message":     "Load started.",
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
 	}))
 
+	http.HandleFunc("/load/result", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		result := loads.LatestResult()
+
+		w.Header().Set("Content-Type", "application/json")
+		if result == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "no load run has finished yet"})
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+
+	http.HandleFunc("/load/runs", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(loads.List())
+	}))
+
+	http.HandleFunc("/load/runs/{id}", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid run id"})
+			return
+		}
+		run, err := loads.Get(id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(run)
+	}))
+
+	http.HandleFunc("/load/runs/{id}/report", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid run id"})
+			return
+		}
+		run, err := loads.Get(id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if run.Active() || run.Result == nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "load run has no result yet", "run": run})
+			return
+		}
+
+		// Время прогона — по часам сервера, семплы — по часам БД
+		from, to := calc.DBTime(run.Result.StartedAt), calc.DBTime(run.Result.FinishedAt)
+		server, err := calc.CalculateBetween(r.Context(), from, to)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(generator.NewRunReport(run, server, calc.Curve(from, to)))
+	}))
+
+	http.HandleFunc("/load/runs/{id}/stop", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid run id"})
+			return
+		}
+		run, err := loads.Stop(id)
+		switch {
+		case errors.Is(err, generator.ErrRunNotFound):
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		case errors.Is(err, generator.ErrRunFinished):
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error(), "run": run})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "stopping",
+			"run":     run,
+			"message": "Load run is being cancelled.",
+		})
+	}))
+
+	http.HandleFunc("/scenarios", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(generator.Scenarios())
+	}))
+
 	// -------------------------------------------------------------------------
 	// Эндпоинт 5: Статус (AI Diagnosis)
 	// GET /status
@@ -455,12 +816,16 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 		
 		response := struct {
 			Timestamp       time.Time          `json:"timestamp"`
-			ActiveScenario  *ScenarioInfo      `json:"ground_truth"` 
+			ActiveScenario  *models.ScenarioInfo `json:"ground_truth"` 
 			Diagnosis       analyzer.Diagnosis `json:"diagnosis"`
+			Stable          analyzer.StableState `json:"stable"`
+			Windows         map[string]analyzer.WindowSummary `json:"windows"`
 		}{
 			Timestamp:      state.LastUpdate,
 			ActiveScenario: state.CurrentScenario,
 			Diagnosis:      state.LatestDiagnosis,
+			Stable:         state.Stable,
+			Windows:        state.Windows,
 		}
 
 		if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -483,14 +848,879 @@ func setupHTTPServer(pool *pgxpool.Pool, mlClient *client.MLClient) {
 		json.NewEncoder(w).Encode(summary)
 	}))
 
-	go func() {
-		log.Println("Server running on :8080")
-		if err := http.ListenAndServe(":8080", nil); err != nil {
-			log.Fatal(err)
+	// -------------------------------------------------------------------------
+	// Эндпоинт 7: Метрики за исторический период (по profile_metrics.snapshots)
+	// GET /metrics/range?from=2025-11-27T15:00:00Z&to=2025-11-27T16:00:00Z
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/metrics/range", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseTimeRange(r, time.Hour)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-	}()
+
+		metrics, err := calc.CalculateRange(r.Context(), from, to)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("Failed to calculate metrics: %v", err),
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"from":      from,
+			"to":        to,
+			"diagnosis": analyzer.ClassifyWorkload(metrics),
+		})
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 8: История диагнозов (таймлайн классификатора + ground truth)
+	// GET /history?from=2025-11-27T00:00:00Z&to=2025-11-27T06:00:00Z&profile=olap
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/history", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseTimeRange(r, 24*time.Hour)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		filter := storage.HistoryFilter{
+			From:    from,
+			To:      to,
+			Profile: r.URL.Query().Get("profile"),
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil || limit <= 0 {
+				http.Error(w, "Invalid 'limit'", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = limit
+		}
+
+		records, err := storage.QueryHistory(r.Context(), pool, filter)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": fmt.Sprintf("Failed to query history: %v", err),
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"from":    from,
+			"to":      to,
+			"count":   len(records),
+			"history": records,
+		})
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 9: Правила классификатора
+	// GET  /rules        — текущие правила и файл, из которого они загружены
+	// POST /rules/reload — перечитать файл правил (CLASSIFIER_RULES_FILE)
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/rules", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		rules, source := analyzer.DefaultEngine().Rules()
+		if source == "" {
+			source = "built-in"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"source": source,
+			"rules":  rules,
+		})
+	}))
+
+	http.HandleFunc("/rules/reload", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := analyzer.DefaultEngine().Reload(); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status": "error",
+				"error":  fmt.Sprintf("Rules are invalid, previous rules kept: %v", err),
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "success",
+			"message": "Classifier rules reloaded.",
+		})
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 10: Автопилот тюнинга
+	// GET  /autopilot        — состояние, настройки и журнал решений
+	// POST /autopilot/pause  — остановить (примененный пресет остается)
+	// POST /autopilot/resume — включить
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/autopilot", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pilot.Status())
+	}))
+
+	http.HandleFunc("/autopilot/pause", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+		pilot.Pause("paused via API")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pilot.Status())
+	}))
+
+	http.HandleFunc("/autopilot/resume", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+		pilot.Resume("resumed via API")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pilot.Status())
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 11: История изменений конфигурации и откат
+	// GET  /config/history?limit=50 — версии конфигурации (новые первыми)
+	// POST /config/rollback/{id}    — вернуть параметры к состоянию до версии id
+	// Кто и зачем: заголовок X-Actor (или ?actor=) и ?reason=
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/config/history", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		limit := 50
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid 'limit'", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		changes, err := configurator.GetChangeHistory(pool, limit)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(changes)
+	}))
+
+	http.HandleFunc("/config/rollback/{id}", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "Invalid change id", http.StatusBadRequest)
+			return
+		}
+
+		change, err := configurator.Rollback(pool, id, changeMeta(r, configurator.SourceRollback))
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, configurator.ErrChangeNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, configurator.ErrInvalidConfig):
+				status = http.StatusBadRequest
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status": "error",
+				"error":  fmt.Sprintf("Failed to roll back change %d: %v", id, err),
+			})
+			return
+		}
+
+		// Откат в области базы/роли не меняет конфигурацию кластера
+		if change.Scope.IsCluster() {
+			state.mu.Lock()
+			if state.CurrentScenario == nil {
+				state.CurrentScenario = &models.ScenarioInfo{}
+			}
+			state.CurrentScenario.ActiveConfig = fmt.Sprintf("ROLLBACK (#%d)", id)
+			state.mu.Unlock()
+			pilot.SetActivePreset("")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": fmt.Sprintf("Configuration rolled back to state before change #%d.", id),
+			"change":  change,
+		})
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 12: Параметры, ждущие рестарта, и подтверждаемый перезапуск
+	// GET  /config/restart                 — что ждет рестарта и настроена ли команда
+	// POST /config/restart                 — шаг 1: получить токен подтверждения
+	// POST /config/restart/confirm?token=… — шаг 2: выполнить DB_RESTART_COMMAND
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/config/restart", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			status, err := restarter.Status()
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			json.NewEncoder(w).Encode(status)
+		case http.MethodPost:
+			req, err := restarter.Request()
+			if err != nil {
+				code := http.StatusConflict
+				if errors.Is(err, configurator.ErrRestartNotConfigured) {
+					code = http.StatusNotImplemented
+				}
+				w.WriteHeader(code)
+				json.NewEncoder(w).Encode(map[string]string{"status": "error", "error": err.Error()})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":  "confirm_required",
+				"message": fmt.Sprintf("PostgreSQL will be restarted with %q. Confirm with POST /config/restart/confirm?token=%s before %s.", req.Command, req.Token, req.ExpiresAt.Format(time.RFC3339)),
+				"request": req,
+			})
+		default:
+			http.Error(w, "Method not allowed (use GET or POST)", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/config/restart/confirm", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+
+		result, err := restarter.Confirm(context.Background(), r.URL.Query().Get("token"))
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status": "error",
+				"error":  err.Error(),
+				"result": result,
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": "PostgreSQL restarted.",
+			"result":  result,
+		})
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 13: Ресурсы сервера БД и рассчитанные по ним пресеты
+	// GET /config/resources              — текущие ресурсы и значения всех пресетов (как их применит /config/apply)
+	// GET /config/resources?refresh=true — определить ресурсы заново
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/config/resources", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh")); refresh {
+			configurator.SetResources(configurator.DetectResources(pool))
+		}
+
+		// Значения, которые применит /config/apply: пользовательский пресет (в том числе
+		// перекрывающий встроенный) как есть, встроенный — по текущим ресурсам
+		res := configurator.CurrentResources()
+		presets := make(map[string]map[string]string)
+		for _, name := range configurator.PresetNames() {
+			presets[name] = configurator.GetSettingsForPreset(name)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"resources": res,
+			"presets":   presets,
+		})
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 14: Пресеты конфигурации
+	// GET    /presets                  — все пресеты (встроенные и пользовательские)
+	// POST   /presets                  — создать пользовательский пресет
+	// GET    /presets/{name}?version=N — пресет (или его сохраненная версия)
+	// PUT    /presets/{name}           — обновить пользовательский пресет (новая версия)
+	// DELETE /presets/{name}           — удалить пользовательский пресет
+	// Body: {"name": "my_oltp", "description": "...", "settings": {"work_mem": "8MB"}, "ml_profiles": ["oltp"]}
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/presets", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(configurator.ListPresets())
+		case http.MethodPost:
+			var p configurator.Preset
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			saved, err := configurator.CreatePreset(pool, p, changeMeta(r, "").Actor)
+			writePreset(w, saved, err, http.StatusCreated)
+		default:
+			http.Error(w, "Method not allowed (use GET or POST)", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/presets/{name}", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+
+		switch r.Method {
+		case http.MethodGet:
+			if v := r.URL.Query().Get("version"); v != "" {
+				version, err := strconv.Atoi(v)
+				if err != nil || version <= 0 {
+					http.Error(w, "Invalid 'version'", http.StatusBadRequest)
+					return
+				}
+				p, err := configurator.GetPresetVersion(pool, name, version)
+				writePreset(w, p, err, http.StatusOK)
+				return
+			}
+			p, ok := configurator.GetPreset(name)
+			if !ok {
+				writePreset(w, nil, fmt.Errorf("%w: %s", configurator.ErrPresetNotFound, name), http.StatusOK)
+				return
+			}
+			writePreset(w, &p, nil, http.StatusOK)
+		case http.MethodPut:
+			var p configurator.Preset
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+				return
+			}
+			saved, err := configurator.UpdatePreset(pool, name, p, changeMeta(r, "").Actor)
+			writePreset(w, saved, err, http.StatusOK)
+		case http.MethodDelete:
+			// Пресет, на который ссылаются правила классификатора, не удаляется (409)
+			if err := configurator.DeletePreset(pool, name); err != nil {
+				writePreset(w, nil, err, http.StatusOK)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{
+				"status":  "success",
+				"message": fmt.Sprintf("Preset %s deleted.", name),
+			})
+		default:
+			http.Error(w, "Method not allowed (use GET, PUT or DELETE)", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 15: Каталог настраиваемых параметров (для панели настроек)
+	// GET /config/parameters — категории, безопасные диапазоны, рестарт,
+	// важность для профилей и текущие значения из pg_settings
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/config/parameters", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		params, err := configurator.DescribeParameters(pool)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"categories": configurator.GetCatalogue().Categories,
+			"parameters": params,
+		})
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 16: Настройки и диагноз по базам и ролям
+	// GET /config/scoped — параметры, заданные через ALTER DATABASE / ALTER ROLE
+	// GET /diagnosis/scopes?by=database|role&window=5m — профиль каждой базы/роли
+	//     и параметры уровня сессии, которые можно задать только для нее
+	//     (применить: PATCH /config/custom?role=report)
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/config/scoped", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		settings, err := configurator.GetScopedSettings(pool)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+	}))
+
+	http.HandleFunc("/diagnosis/scopes", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		by := r.URL.Query().Get("by")
+		if by == "" {
+			by = analyzer.ScopeByDatabase
+		}
+		window := analyzer.DiagnosisWindow
+		if v := r.URL.Query().Get("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 || d > analyzer.MaxWindow {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": fmt.Sprintf("invalid window %q (max %s)", v, analyzer.MaxWindow),
+				})
+				return
+			}
+			window = d
+		}
+		if by != analyzer.ScopeByDatabase && by != analyzer.ScopeByRole {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "by must be database or role"})
+			return
+		}
+
+		scopes, err := calc.DiagnoseScopes(r.Context(), window, by)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"by":     by,
+			"window": analyzer.WindowLabel(window),
+			"scopes": scopes,
+		})
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 17: Захват и воспроизведение реальной нагрузки
+	// POST /replay/capture?name=prod&window=60s&top=20&database=shop
+	//     — частые запросы pg_stat_statements за window (без window — с последнего
+	//       сброса) с примерами из ash_samples и генераторами параметров
+	// POST /replay/scenarios — сохранить захват (с исправленными генераторами)
+	//     как сценарий replay_<name>; запуск — /load/start?scenario=replay_prod&factor=2.
+	//     Тексты запросов берутся заново из pg_stat_statements по query_id, а не из тела;
+	//     "target" в теле — база для воспроизведения (LOAD_TARGET_<NAME>)
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/replay/capture", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		q := r.URL.Query()
+		opts := generator.CaptureOptions{Name: q.Get("name"), Database: q.Get("database")}
+		if v := q.Get("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("invalid window %q", v)})
+				return
+			}
+			opts.Window = d
+		}
+		if v := q.Get("top"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("invalid top %q", v)})
+				return
+			}
+			opts.Top = n
+		}
+
+		capture, err := generator.CaptureWorkload(r.Context(), pool, opts)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, generator.ErrInvalidCapture) {
+				status = http.StatusBadRequest
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(capture)
+	}))
+
+	http.HandleFunc("/replay/scenarios", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		var capture generator.Capture
+		if err := json.NewDecoder(r.Body).Decode(&capture); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON body"})
+			return
+		}
+		manifest, err := generator.SaveReplay(r.Context(), pool, generator.ScenariosDir(), capture)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, generator.ErrInvalidCapture) {
+				status = http.StatusBadRequest
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(manifest)
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 18: Оценка точности классификаторов
+	// POST /evaluation/start?scenarios=oltp,olap&presets=oltp,olap&duration=2m&interval=5s&warmup=30s
+	//     — каждый сценарий под каждым пресетом; правила и ML-сервис сравниваются
+	//       с expected_profile, отчет пишется в EVALUATION_DIR/<id> (JSON и CSV)
+	// GET  /evaluation      — ход текущей или итог последней оценки
+	// POST /evaluation/stop — прервать (отчет по собранному все равно пишется)
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/evaluation/start", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		q := r.URL.Query()
+		cfg := evaluation.Config{Scenarios: splitList(q.Get("scenarios")), Presets: splitList(q.Get("presets"))}
+		for name, dst := range map[string]*time.Duration{"duration": &cfg.Duration, "interval": &cfg.Interval, "warmup": &cfg.Warmup} {
+			if v := q.Get(name); v != "" {
+				d, err := time.ParseDuration(v)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("invalid %s: %v", name, err)})
+					return
+				}
+				*dst = d
+			}
+		}
+
+		st, err := evaluator.Start(cfg)
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, evaluation.ErrInvalidConfig):
+				status = http.StatusBadRequest
+			case errors.Is(err, evaluation.ErrEvaluationRunning):
+				status = http.StatusConflict
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(st)
+	}))
+
+	http.HandleFunc("/evaluation", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		st := evaluator.Status()
+		if st == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "no evaluation has been run yet"})
+			return
+		}
+		json.NewEncoder(w).Encode(st)
+	}))
+
+	http.HandleFunc("/evaluation/stop", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed (use POST)", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		st, err := evaluator.Stop()
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(st)
+	}))
+
+	// -------------------------------------------------------------------------
+	// Эндпоинт 19: Выгрузка датасета для обучения ML-модели
+	// GET /dataset/export?format=csv|jsonl&from=...&to=...&scenario=oltp,olap
+	//     — диагнозы, снятые во время прогонов нагрузки (по умолчанию за последние
+	//       7 дней), в схеме признаков /predict плюс load_scenario, expected_profile,
+	//       phase и run_id
+	// То же из командной строки: go run ./cmd/dataset
+	// -------------------------------------------------------------------------
+	http.HandleFunc("/dataset/export", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseTimeRange(r, 7*24*time.Hour)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		contentType := ""
+		switch format {
+		case "", dataset.FormatCSV:
+			format, contentType = dataset.FormatCSV, "text/csv"
+		case dataset.FormatJSONL:
+			contentType = "application/x-ndjson"
+		default:
+			http.Error(w, dataset.ErrInvalidFormat.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="dataset.%s"`, format))
+		filter := dataset.Filter{From: from, To: to, Scenarios: splitList(r.URL.Query().Get("scenario"))}
+		// Строки пишутся по мере чтения: после первой статус уже не поменять, ошибка идет только в лог
+		if n, err := dataset.Export(r.Context(), pool, filter, format, w); err != nil {
+			log.Printf("[ERROR] Dataset export failed after %d rows: %v", n, err)
+		}
+	}))
+
+	go func() {
+		log.Println("Server running on :8080")
+		if err := http.ListenAndServe(":8080", nil); err != nil {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// markLoadStarted записывает запущенную нагрузку в ground truth
+func markLoadStarted(run generator.LoadRun, manifest *generator.Manifest) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if run.ID <= state.FinishedRunID {
+		return // прогон успел завершиться (например, сразу упал) — размечать нечего
+	}
+	if state.CurrentScenario == nil {
+		state.CurrentScenario = &models.ScenarioInfo{}
+	}
+	params := run.Params
+	state.CurrentScenario.LoadScenario = run.Scenario
+	state.CurrentScenario.StartTime = run.QueuedAt
+	state.CurrentScenario.Load = &params
+	state.CurrentScenario.ExpectedProfile = manifest.ExpectedProfile
+	state.CurrentScenario.Phase = ""
+	state.CurrentScenario.PhaseStart = nil
+	state.CurrentScenario.RunID = run.ID
+	if manifest.Composite() {
+		// Первая фаза могла уже начаться и вызвать хук раньше, чем сюда дошли
+		start := run.QueuedAt
+		state.CurrentScenario.Phase = manifest.Phases[0].Label
+		state.CurrentScenario.PhaseStart = &start
+		state.CurrentScenario.ExpectedProfile = manifest.Phases[0].ExpectedProfile
+	}
+}
+
+// splitList — значения через запятую без пробелов и пустых элементов
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// changeMeta собирает автора и причину изменения конфигурации из запроса.
+// Автор — заголовок X-Actor или ?actor=, по умолчанию адрес клиента.
+func changeMeta(r *http.Request, source string) configurator.ChangeMeta {
+	actor := r.Header.Get("X-Actor")
+	if actor == "" {
+		actor = r.URL.Query().Get("actor")
+	}
+	if actor == "" {
+		actor = r.RemoteAddr
+	}
+	return configurator.ChangeMeta{
+		Actor:  actor,
+		Reason: r.URL.Query().Get("reason"),
+		Source: source,
+		Scope:  requestScope(r),
+	}
+}
+
+// requestScope — область изменения из ?database= и ?role= (пусто — весь кластер)
+func requestScope(r *http.Request) configurator.Scope {
+	return configurator.Scope{
+		Database: strings.TrimSpace(r.URL.Query().Get("database")),
+		Role:     strings.TrimSpace(r.URL.Query().Get("role")),
+	}
+}
+
+// applyDescription описывает, что стало с параметрами после применения:
+// часть из них (shared_buffers, max_connections...) заработает только после рестарта
+func applyDescription(change *configurator.ChangeSet) string {
+	if !change.Scope.IsCluster() {
+		description := fmt.Sprintf("Settings saved for %s, they take effect in new sessions.", change.Scope)
+		if len(change.Skipped) > 0 {
+			description += fmt.Sprintf(" Skipped server-wide parameters: %s.", strings.Join(change.Skipped, ", "))
+		}
+		return description
+	}
+	pending := change.PendingRestart()
+	if len(pending) == 0 {
+		return "PostgreSQL configuration reloaded."
+	}
+	return fmt.Sprintf("PostgreSQL configuration reloaded, but %s will take effect only after a restart (see GET /config/restart).", strings.Join(pending, ", "))
+}
+
+// writePreset отдает пресет или ошибку хранилища пресетов с подходящим HTTP-кодом
+func writePreset(w http.ResponseWriter, p *configurator.Preset, err error, okCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		code := http.StatusConflict
+		switch {
+		case errors.Is(err, configurator.ErrPresetNotFound):
+			code = http.StatusNotFound
+		case errors.Is(err, configurator.ErrInvalidConfig):
+			code = http.StatusBadRequest
+		case !errors.Is(err, configurator.ErrPresetExists) && !errors.Is(err, configurator.ErrPresetInUse):
+			code = http.StatusInternalServerError
+		}
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"status": "error", "error": err.Error()})
+		return
+	}
+	w.WriteHeader(okCode)
+	json.NewEncoder(w).Encode(p)
+}
+
+// isDryRun — запрошен ли только план изменений (?dry_run=true)
+func isDryRun(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	return v
+}
+
+// writePlan отдает результат dry-run: какие параметры изменятся, совпадают,
+// требуют рестарта или будут отклонены. ALTER SYSTEM не выполняется.
+func writePlan(w http.ResponseWriter, plan *configurator.Plan, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, configurator.ErrInvalidConfig) {
+			code = http.StatusBadRequest
+		}
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "error",
+			"error":  fmt.Sprintf("Failed to build dry-run plan: %v", err),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "dry_run",
+		"message": "No changes were applied.",
+		"plan":    plan,
+	})
+}
+
+// parseLoadParams читает интенсивность нагрузки из query: duration (10m или секунды),
+// clients, jobs, rate, scale, think_time (50ms или миллисекунды). Пропущенные параметры
+// остаются нулевыми.
+func parseLoadParams(r *http.Request) (models.LoadParams, error) {
+	var p models.LoadParams
+	q := r.URL.Query()
+
+	if v := q.Get("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			seconds, errInt := strconv.Atoi(v)
+			if errInt != nil {
+				return p, fmt.Errorf("%w: invalid duration %q", generator.ErrInvalidParams, v)
+			}
+			d = time.Duration(seconds) * time.Second
+		}
+		if d < time.Second {
+			return p, fmt.Errorf("%w: duration must be at least 1s", generator.ErrInvalidParams)
+		}
+		p.DurationSeconds = int(d / time.Second)
+	}
+	for name, dst := range map[string]*int{"clients": &p.Clients, "jobs": &p.Jobs, "scale": &p.Scale} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return p, fmt.Errorf("%w: %s must be a positive integer", generator.ErrInvalidParams, name)
+			}
+			*dst = n
+		}
+	}
+	if v := q.Get("rate"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 {
+			return p, fmt.Errorf("%w: rate must be a non-negative number", generator.ErrInvalidParams)
+		}
+		p.Rate = rate
+	}
+	if v := q.Get("think_time"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			ms, errInt := strconv.Atoi(v)
+			if errInt != nil {
+				return p, fmt.Errorf("%w: invalid think_time %q", generator.ErrInvalidParams, v)
+			}
+			d = time.Duration(ms) * time.Millisecond
+		}
+		if d < time.Millisecond {
+			return p, fmt.Errorf("%w: think_time must be at least 1ms", generator.ErrInvalidParams)
+		}
+		p.ThinkTimeMS = int(d / time.Millisecond)
+	}
+	// База для нагрузки: имя из LOAD_TARGET_<NAME>, проверяется в ResolveParams
+	p.Target = q.Get("target")
+	return p, nil
+}
+
+// parseTimeRange читает from/to (RFC3339) из query. Если from не задан,
+// берется последний defaultSpan до to; если не задан to — текущий момент.
+func parseTimeRange(r *http.Request, defaultSpan time.Duration) (time.Time, time.Time, error) {
+	to := time.Now()
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid 'to' (use RFC3339): %v", err)
+		}
+		to = t
+	}
+
+	from := to.Add(-defaultSpan)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid 'from' (use RFC3339): %v", err)
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("'from' must be before 'to'")
+	}
+	return from, to, nil
 }
 
-func printMetricsToConsole(m models.WorkloadMetrics, d analyzer.Diagnosis) {
-	fmt.Printf("[Analyzer] Profile: %s | IO: %.0f%% CPU: %.0f%%\n", d.Profile, m.IOPercent, m.CPUPercent)
+func printMetricsToConsole(m models.WorkloadMetrics, d analyzer.Diagnosis, stable analyzer.StableState) {
+	fmt.Printf("[Analyzer] Profile: %s (stable: %s) | IO: %.0f%% CPU: %.0f%%\n", d.Profile, stable.Profile, m.IOPercent, m.CPUPercent)
 }
@@ -53,7 +53,7 @@ const Dashboard: React.FC = () => {
     { label: "Archive-Scan", value: "cold" },
   ];
 
-  const loadScenarios = [
+  const [loadScenarios, setLoadScenarios] = useState([
     { value: "oltp", label: "OLTP" },
     { value: "olap", label: "OLAP" },
     { value: "iot", label: "Write-heavy" },
@@ -62,7 +62,21 @@ const Dashboard: React.FC = () => {
     { value: "mixed", label: "HTAP" },
     { value: "etl", label: "Bulk ETL" },
     { value: "cold", label: "Archive-Scan" },
-  ];
+  ]);
+
+  // Сценарии из библиотеки сервера (setup-сценарии вроде init запускаются отдельно)
+  useEffect(() => {
+    ApiService.getScenarios()
+      .then((scenarios) => {
+        const runnable = scenarios
+          .filter((s) => !scenarios.some((other) => other.setup === s.name))
+          .map((s) => ({ value: s.name, label: s.title }));
+        if (runnable.length > 0) {
+          setLoadScenarios(runnable);
+        }
+      })
+      .catch(() => {}); // Остается встроенный список
+  }, []);
 
   // Загрузка данных
   const fetchData = async () => {
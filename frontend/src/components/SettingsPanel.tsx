@@ -1,5 +1,5 @@
 import React, { useState, useEffect } from "react";
-import { TuningConfig } from "../types/api";
+import { TuningConfig, ParameterCatalogue } from "../types/api";
 import { ApiService } from "../services/api";
 
 interface SettingsPanelProps {
@@ -15,9 +15,13 @@ interface SettingsPanelProps {
 interface ConfigField {
   id: string;
   label: string;
+  category: string;
+  description: string;
   currentValue: string;
   value: string;
-  unit: string;
+  restart: boolean;
+  range?: string;
+  profiles: string[];
 }
 
 interface MLPrediction {
@@ -39,146 +43,42 @@ export const SettingsPanel: React.FC<SettingsPanelProps> = ({
   const [mlPrediction, setMlPrediction] = useState<MLPrediction | null>(null);
   const [isLoadingML, setIsLoadingML] = useState(false);
   const [mlError, setMlError] = useState<string | null>(null);
-  // Парсим значение из строки типа "128MB" или "4MB"
-  const parseValue = (str: string | undefined): string => {
-    if (!str) return "8";
-    const match = str.match(/(\d+\.?\d*)/);
-    return match ? match[1] : "8";
-  };
-
-  // Получаем единицу измерения из строки
-  const parseUnit = (str: string | undefined, defaultUnit: string): string => {
-    if (!str) return defaultUnit;
-    if (str.includes("MB")) return "MB";
-    if (str.includes("GB")) return "GB";
-    if (str.includes("min")) return "MIN";
-    if (str.includes("ms")) return "ms";
-    if (str.includes("s") && !str.includes("ms")) return "s"; // для deadlock_timeout "1s"
-    if (str.includes("workers")) return "workers";
-    return defaultUnit;
-  };
+  // Каталог параметров (категории, диапазоны, рестарт) приходит с бэкенда
+  const [catalogue, setCatalogue] = useState<ParameterCatalogue | null>(null);
+  const [fields, setFields] = useState<ConfigField[]>([]);
 
-  const [memoryFields, setMemoryFields] = useState<ConfigField[]>([
-    {
-      id: "shared_buffers",
-      label: "shared_buffers",
-      currentValue: currentConfig?.shared_buffers || "128MB",
-      value: parseValue(currentConfig?.shared_buffers),
-      unit: parseUnit(currentConfig?.shared_buffers, "MB"),
-    },
-    {
-      id: "work_mem",
-      label: "work_mem",
-      currentValue: currentConfig?.work_mem || "4MB",
-      value: parseValue(currentConfig?.work_mem),
-      unit: parseUnit(currentConfig?.work_mem, "MB"),
-    },
-  ]);
-
-  const [walFields, setWalFields] = useState<ConfigField[]>([
-    {
-      id: "max_wal_size",
-      label: "max_wal_size",
-      currentValue: currentConfig?.max_wal_size || "1GB",
-      value: parseValue(currentConfig?.max_wal_size),
-      unit: parseUnit(currentConfig?.max_wal_size, "GB"),
-    },
-    {
-      id: "checkpoint_timeout",
-      label: "checkpoint_timeout",
-      currentValue: currentConfig?.checkpoint_timeout || "15min",
-      value: parseValue(currentConfig?.checkpoint_timeout),
-      unit: parseUnit(currentConfig?.checkpoint_timeout, "MIN"),
-    },
-    {
-      id: "synchronous_commit",
-      label: "synchronous_commit",
-      currentValue: currentConfig?.synchronous_commit || "on",
-      value: currentConfig?.synchronous_commit || "on",
-      unit: "",
-    },
-  ]);
-
-  const [parallelFields, setParallelFields] = useState<ConfigField[]>([
-    {
-      id: "max_parallel_workers_per_gather",
-      label: "max_parallel_workers_per_gather",
-      currentValue: currentConfig?.max_parallel_workers_per_gather || "0",
-      value: parseValue(currentConfig?.max_parallel_workers_per_gather),
-      unit: parseUnit(currentConfig?.max_parallel_workers_per_gather, "workers"),
-    },
-    {
-      id: "deadlock_timeout",
-      label: "deadlock_timeout",
-      currentValue: currentConfig?.deadlock_timeout || "1s",
-      value: parseValue(currentConfig?.deadlock_timeout),
-      unit: parseUnit(currentConfig?.deadlock_timeout, "s"),
-    },
-  ]);
+  useEffect(() => {
+    if (!isOpen) return;
+    ApiService.getParameterCatalogue()
+      .then(setCatalogue)
+      .catch((error) => console.error("Error loading parameter catalogue:", error));
+  }, [isOpen]);
 
-  // Обновляем значения при изменении currentConfig
+  // Обновляем значения при изменении каталога или currentConfig
   useEffect(() => {
-    if (currentConfig) {
-      setMemoryFields([
-        {
-          id: "shared_buffers",
-          label: "shared_buffers",
-          currentValue: currentConfig.shared_buffers || "128MB",
-          value: parseValue(currentConfig.shared_buffers),
-          unit: parseUnit(currentConfig.shared_buffers, "MB"),
-        },
-        {
-          id: "work_mem",
-          label: "work_mem",
-          currentValue: currentConfig.work_mem || "4MB",
-          value: parseValue(currentConfig.work_mem),
-          unit: parseUnit(currentConfig.work_mem, "MB"),
-        },
-      ]);
-      setWalFields([
-        {
-          id: "max_wal_size",
-          label: "max_wal_size",
-          currentValue: currentConfig.max_wal_size || "1GB",
-          value: parseValue(currentConfig.max_wal_size),
-          unit: parseUnit(currentConfig.max_wal_size, "GB"),
-        },
-        {
-          id: "checkpoint_timeout",
-          label: "checkpoint_timeout",
-          currentValue: currentConfig.checkpoint_timeout || "15min",
-          value: parseValue(currentConfig.checkpoint_timeout),
-          unit: parseUnit(currentConfig.checkpoint_timeout, "MIN"),
-        },
-        {
-          id: "synchronous_commit",
-          label: "synchronous_commit",
-          currentValue: currentConfig.synchronous_commit || "on",
-          value: currentConfig.synchronous_commit || "on",
-          unit: "",
-        },
-      ]);
-      setParallelFields([
-        {
-          id: "max_parallel_workers_per_gather",
-          label: "max_parallel_workers_per_gather",
-          currentValue: currentConfig.max_parallel_workers_per_gather || "0",
-          value: parseValue(currentConfig.max_parallel_workers_per_gather),
-          unit: parseUnit(currentConfig.max_parallel_workers_per_gather, "workers"),
-        },
-        {
-          id: "deadlock_timeout",
-          label: "deadlock_timeout",
-          currentValue: currentConfig.deadlock_timeout || "1s",
-          value: parseValue(currentConfig.deadlock_timeout),
-          unit: parseUnit(currentConfig.deadlock_timeout, "s"),
-        },
-      ]);
-    }
-  }, [currentConfig]);
+    if (!catalogue) return;
+    setFields(
+      catalogue.parameters
+        .filter((param) => param.available)
+        .map((param) => {
+          const current = currentConfig?.[param.name] || param.current;
+          return {
+            id: param.name,
+            label: param.name,
+            category: param.category,
+            description: param.description,
+            currentValue: current,
+            value: current,
+            restart: param.restart,
+            range: param.min && param.max ? `${param.min} … ${param.max}` : undefined,
+            profiles: param.profiles,
+          };
+        }),
+    );
+  }, [catalogue, currentConfig]);
 
-  const handleValueChange = (setter: React.Dispatch<React.SetStateAction<ConfigField[]>>, id: string, newValue: string) => {
-    setter((prev) =>
+  const handleValueChange = (id: string, newValue: string) => {
+    setFields((prev) =>
       prev.map((field) =>
         field.id === id ? { ...field, value: newValue } : field,
       ),
@@ -230,30 +130,20 @@ export const SettingsPanel: React.FC<SettingsPanelProps> = ({
   };
 
   const handleApplyCustom = async () => {
+    // Отправляем только измененные параметры (значения вводятся с единицами: 256MB, 15min)
     const customConfig: Record<string, string> = {};
-    
-    [...memoryFields, ...walFields, ...parallelFields].forEach(field => {
-      if (field.unit) {
-        if (field.unit === "MB") {
-          customConfig[field.id] = `${field.value}MB`;
-        } else if (field.unit === "GB") {
-          customConfig[field.id] = `${field.value}GB`;
-        } else if (field.unit === "MIN") {
-          customConfig[field.id] = `${field.value}min`;
-        } else if (field.unit === "ms") {
-          customConfig[field.id] = `${field.value}ms`;
-        } else if (field.unit === "s") {
-          customConfig[field.id] = `${field.value}s`;
-        } else if (field.unit === "workers") {
-          customConfig[field.id] = field.value;
-        } else {
-          customConfig[field.id] = field.value;
-        }
-      } else {
-        customConfig[field.id] = field.value;
+    fields.forEach((field) => {
+      const value = field.value.trim();
+      if (value !== "" && value !== field.currentValue) {
+        customConfig[field.id] = value;
       }
     });
 
+    if (Object.keys(customConfig).length === 0) {
+      console.warn("[SettingsPanel] No changed parameters to apply");
+      return;
+    }
+
     try {
       await onApplyCustomConfig(customConfig);
       onClose();
@@ -383,121 +273,50 @@ export const SettingsPanel: React.FC<SettingsPanelProps> = ({
                 </p>
               </div>
 
-              {/* Memory Section */}
-              <div className="bg-[#212020] rounded-[20px] border border-[#312f2f] p-6">
-                <h2 className="font-['Inter'] font-semibold text-[#7b7575] text-base mb-6">
-                  Память
-                </h2>
-                <div className="space-y-6">
-                  {memoryFields.map((field) => (
-                    <div key={field.id} className="flex items-center gap-4">
-                      <div className="w-[148px] flex flex-col gap-1.5">
-                        <label className="font-['Inter'] font-normal text-white text-base">
-                          {field.label}
-                        </label>
-                        <div className="font-['Inter'] font-normal text-[#727278] text-xs">
-                          Текущее значение: {field.currentValue}
-                        </div>
-                      </div>
-                      <div className="w-[202px] h-11 relative">
-                        <div className="absolute top-0 left-0 w-full h-full bg-[#373636] rounded-[30px] border border-[#656161]" />
-                        <input
-                          type="text"
-                          value={field.value}
-                          onChange={(e) => handleValueChange(setMemoryFields, field.id, e.target.value)}
-                          className="absolute top-2.5 left-[23px] font-['Inter'] font-normal text-white text-xl bg-transparent border-none outline-none w-[150px]"
-                        />
-                      </div>
-                      {field.unit && (
-                        <div className="w-[108px] h-11 relative">
-                          <div className="absolute top-0 left-0 w-full h-full bg-[#373636] rounded-[30px] border border-[#656161]" />
-                          <span className="absolute top-2.5 left-[39px] font-['Inter'] font-normal text-white text-xl">
-                            {field.unit}
-                          </span>
-                        </div>
-                      )}
-                    </div>
-                  ))}
-                </div>
-              </div>
-
-              {/* WAL and Checkpoints Section */}
-              <div className="bg-[#212020] rounded-[20px] border border-[#312f2f] p-6">
-                <h2 className="font-['Inter'] font-semibold text-[#7b7575] text-base mb-6">
-                  Wall и чекпоинты
-                </h2>
-                <div className="space-y-6">
-                  {walFields.map((field) => (
-                    <div key={field.id} className="flex items-center gap-4">
-                      <div className="w-[148px] flex flex-col gap-1.5">
-                        <label className="font-['Inter'] font-normal text-white text-base">
-                          {field.label}
-                        </label>
-                        <div className="font-['Inter'] font-normal text-[#727278] text-xs">
-                          Текущее значение: {field.currentValue}
-                        </div>
-                      </div>
-                      <div className="w-[202px] h-11 relative">
-                        <div className="absolute top-0 left-0 w-full h-full bg-[#373636] rounded-[30px] border border-[#656161]" />
-                        <input
-                          type="text"
-                          value={field.value}
-                          onChange={(e) => handleValueChange(setWalFields, field.id, e.target.value)}
-                          className="absolute top-2.5 left-[23px] font-['Inter'] font-normal text-white text-xl bg-transparent border-none outline-none w-[150px]"
-                        />
-                      </div>
-                      {field.unit && (
-                        <div className="w-[108px] h-11 relative">
-                          <div className="absolute top-0 left-0 w-full h-full bg-[#373636] rounded-[30px] border border-[#656161]" />
-                          <span className="absolute top-2.5 left-[39px] font-['Inter'] font-normal text-white text-xl">
-                            {field.unit}
-                          </span>
-                        </div>
-                      )}
-                    </div>
-                  ))}
-                </div>
-              </div>
-
-              {/* Parallelism and Locks Section */}
-              <div className="bg-[#212020] rounded-[20px] border border-[#312f2f] p-6">
-                <h2 className="font-['Inter'] font-semibold text-[#7b7575] text-base mb-6">
-                  Параллелизм и блокировки
-                </h2>
-                <div className="space-y-6">
-                  {parallelFields.map((field) => (
-                    <div key={field.id} className="flex items-center gap-4">
-                      <div className="w-[148px] flex flex-col gap-1.5">
-                        <label className="font-['Inter'] font-normal text-white text-base">
-                          {field.label}
-                        </label>
-                        <div className="font-['Inter'] font-normal text-[#727278] text-xs">
-                          Текущее значение: {field.currentValue}
-                        </div>
-                      </div>
-                      <div className="w-[202px] h-11 relative">
-                        <div className="absolute top-0 left-0 w-full h-full bg-[#373636] rounded-[30px] border border-[#656161]" />
-                        <input
-                          type="text"
-                          value={field.value}
-                          onChange={(e) => handleValueChange(setParallelFields, field.id, e.target.value)}
-                          className="absolute top-2.5 left-[23px] font-['Inter'] font-normal text-white text-xl bg-transparent border-none outline-none w-[150px]"
-                        />
-                      </div>
-                      {field.unit && (
-                        <div className="w-[108px] h-11 relative">
-                          <div className="absolute top-0 left-0 w-full h-full bg-[#373636] rounded-[30px] border border-[#656161]" />
-                          <span className={`absolute top-2.5 font-['Inter'] font-normal text-white text-xl ${
-                            field.unit === "workers" ? "left-[15px]" : "left-[39px]"
-                          }`}>
-                            {field.unit}
-                          </span>
+              {/* Секции по категориям каталога параметров */}
+              {catalogue?.categories.map((category) => {
+                const categoryFields = fields.filter((field) => field.category === category.key);
+                if (categoryFields.length === 0) return null;
+                return (
+                  <div key={category.key} className="bg-[#212020] rounded-[20px] border border-[#312f2f] p-6">
+                    <h2 className="font-['Inter'] font-semibold text-[#7b7575] text-base mb-6">
+                      {category.title}
+                    </h2>
+                    <div className="space-y-6">
+                      {categoryFields.map((field) => (
+                        <div key={field.id} className="flex items-center gap-4">
+                          <div
+                            className="w-[260px] flex flex-col gap-1.5"
+                            title={`${field.description}${field.profiles.length ? `\nВажен для: ${field.profiles.join(", ")}` : ""}`}
+                          >
+                            <label className="font-['Inter'] font-normal text-white text-base break-all">
+                              {field.label}
+                              {field.restart && (
+                                <span className="ml-2 px-2 py-0.5 rounded-full bg-[#F59E0B]/20 text-[#F59E0B] text-xs align-middle">
+                                  рестарт
+                                </span>
+                              )}
+                            </label>
+                            <div className="font-['Inter'] font-normal text-[#727278] text-xs">
+                              Текущее значение: {field.currentValue}
+                              {field.range && <> · диапазон: {field.range}</>}
+                            </div>
+                          </div>
+                          <div className="w-[202px] h-11 relative">
+                            <div className="absolute top-0 left-0 w-full h-full bg-[#373636] rounded-[30px] border border-[#656161]" />
+                            <input
+                              type="text"
+                              value={field.value}
+                              onChange={(e) => handleValueChange(field.id, e.target.value)}
+                              className="absolute top-2.5 left-[23px] font-['Inter'] font-normal text-white text-xl bg-transparent border-none outline-none w-[150px]"
+                            />
+                          </div>
                         </div>
-                      )}
+                      ))}
                     </div>
-                  ))}
-                </div>
-              </div>
+                  </div>
+                );
+              })}
 
               {/* Apply Custom Button */}
               <button
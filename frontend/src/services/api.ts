@@ -1,4 +1,4 @@
-import { StatusResponse, DashboardData } from '../types/api';
+import { StatusResponse, DashboardData, ParameterCatalogue, ScenarioManifest } from '../types/api';
 
 const API_BASE_URL = process.env.REACT_APP_API_URL || 'http://localhost:8081';
 
@@ -47,6 +47,17 @@ export class ApiService {
     return response.json();
   }
 
+  /**
+   * Получить библиотеку сценариев нагрузки
+   */
+  static async getScenarios(): Promise<ScenarioManifest[]> {
+    const response = await fetch(`${API_BASE_URL}/scenarios`);
+    if (!response.ok) {
+      throw new Error(`Failed to get scenarios: ${response.statusText}`);
+    }
+    return response.json();
+  }
+
   /**
    * Применить рекомендации AI
    */
@@ -115,5 +126,16 @@ export class ApiService {
     }
     return response.json();
   }
+
+  /**
+   * Получить каталог настраиваемых параметров с текущими значениями
+   */
+  static async getParameterCatalogue(): Promise<ParameterCatalogue> {
+    const response = await fetch(`${API_BASE_URL}/config/parameters`);
+    if (!response.ok) {
+      throw new Error(`Failed to get parameter catalogue: ${response.statusText}`);
+    }
+    return response.json();
+  }
 }
 
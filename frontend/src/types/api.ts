@@ -19,38 +19,143 @@ export interface WorkloadMetrics {
   commit_ratio: number;
   wasted_db_time: number;
   dominate_db_time: number;
+  cache_hit_ratio?: number;
+  blks_read_per_sec?: number;
+  blks_hit_per_sec?: number;
+  tup_returned_per_sec?: number;
+  tup_fetched_per_sec?: number;
+  tup_inserted_per_sec?: number;
+  tup_updated_per_sec?: number;
+  tup_deleted_per_sec?: number;
+  buffers_checkpoint_per_sec?: number;
+  buffers_clean_per_sec?: number;
+  buffers_backend_per_sec?: number;
+  window_seconds?: number;
+  partial?: boolean;
+  resets?: string[];
 }
 
-export interface TuningConfig {
-  shared_buffers: string;
-  work_mem: string;
-  max_wal_size: string;
-  checkpoint_timeout: string;
-  synchronous_commit: string;
-  max_parallel_workers_per_gather: string;
-  deadlock_timeout: string;
-  autovacuum_naptime?: string;
+// Параметры postgresql.conf: имя параметра из каталога -> значение
+export type TuningConfig = Record<string, string>;
+
+export interface ParameterCategory {
+  key: string;
+  title: string;
+}
+
+export interface ParameterInfo {
+  name: string;
+  category: string;
+  description: string;
+  min?: string;
+  max?: string;
+  restart: boolean;
+  profiles: string[];
+  current: string;
+  unit?: string;
+  context?: string;
+  vartype?: string;
+  enumvals?: string[];
+  available: boolean;
+}
+
+export interface ParameterCatalogue {
+  categories: ParameterCategory[];
+  parameters: ParameterInfo[];
 }
 
 export interface Diagnosis {
   profile: string;
+  profile_key?: string;
   description: string;
   confidence: string;
   metrics: WorkloadMetrics;
   tuning_recommendations: TuningConfig;
   reasoning: string;
+  confidence_score?: number;
+  scores?: Record<string, number> | null;
+  runner_up?: string;
+  margin?: number;
+  fired_rules?: RuleContribution[] | null;
+}
+
+export interface RuleContribution {
+  rule: string;
+  metric: string;
+  observed: number;
+  op: string;
+  threshold: number;
+  points: Record<string, number>;
+}
+
+export interface LoadParams {
+  duration_seconds: number;
+  clients: number;
+  jobs: number;
+  rate: number;
+  scale: number;
+}
+
+export interface ScenarioManifest {
+  name: string;
+  title: string;
+  description: string;
+  scripts: Array<{ file: string; weight: number }>;
+  clients: number;
+  jobs: number;
+  max_clients: number;
+  duration?: string;
+  transactions?: number;
+  scale?: number;
+  rate?: number;
+  expected_profile?: string;
+  requires?: string[];
+  setup?: string;
+  phases?: ScenarioPhase[];
+}
+
+export interface ScenarioPhase {
+  label: string;
+  duration: string;
+  expected_profile?: string;
+  workloads: Array<{
+    scenario: string;
+    clients?: number;
+    rate?: number;
+    ramp_up?: string;
+    ramp_down?: string;
+  }>;
 }
 
 export interface ScenarioInfo {
   load_scenario: string;
   active_config: string;
   start_time: string;
+  load?: LoadParams;
+  expected_profile?: string;
+  phase?: string;
+}
+
+export interface WindowSummary {
+  metrics: WorkloadMetrics;
+  profile: string;
+}
+
+export interface StableState {
+  profile: string;
+  profile_key: string;
+  since: string;
+  candidate?: string;
+  candidate_streak: number;
+  config: { min_consecutive: number; min_margin: number };
 }
 
 export interface StatusResponse {
   timestamp: string;
   ground_truth: ScenarioInfo | null;
   diagnosis: Diagnosis;
+  stable?: StableState;
+  windows?: Record<string, WindowSummary> | null;
 }
 
 export interface WaitEventSummary {
@@ -3,6 +3,8 @@ package analyzer
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -11,96 +13,300 @@ import (
 )
 
 type Calculator struct {
-	pool *pgxpool.Pool
+	pool    *pgxpool.Pool
+	samples *sampleRing[collector.RawStats]
+	scopes  *sampleRing[collector.ScopeStats] // счетчики по базам и ролям (см. scopes.go)
+	skew    atomic.Int64                      // часы БД минус часы сервера, нс (см. DBTime)
 }
 
 func NewCalculator(pool *pgxpool.Pool) *Calculator {
-	return &Calculator{pool: pool}
+	// +2: чтобы окно MaxWindow целиком помещалось вместе с граничными семплами
+	capacity := int(MaxWindow/SampleInterval) + 2
+	return &Calculator{
+		pool:    pool,
+		samples: newSampleRing(capacity, func(s collector.RawStats) time.Time { return s.Timestamp }),
+		scopes:  newSampleRing(capacity, func(s collector.ScopeStats) time.Time { return s.Timestamp }),
+	}
 }
 
-// CalculateMetrics считает метрики: TPS/QPS (через дельты) + DB Time (через ASH/Snapshots)
-func (c *Calculator) CalculateMetrics(ctx context.Context, duration time.Duration) (models.WorkloadMetrics, error) {
-	var m models.WorkloadMetrics
+// Sample снимает сырые счетчики и кладет их в кольцевой буфер.
+// Вызывается по тикеру раз в SampleInterval.
+func (c *Calculator) Sample(ctx context.Context) error {
+	before := time.Now()
+	stats, err := collector.GetRawStats(c.pool)
+	if err != nil {
+		return fmt.Errorf("failed to get raw stats: %w", err)
+	}
+	// Время семпла — clock_timestamp() базы; расхождение с часами сервера меряем
+	// относительно середины запроса
+	after := time.Now()
+	c.skew.Store(int64(stats.Timestamp.Sub(before.Add(after.Sub(before) / 2))))
+	c.samples.push(*stats)
 
-	// ---------------------------------------------------------
-	// 1. Снапшот счетчиков "ДО" (Start Snapshot)
-	// ---------------------------------------------------------
-	startStats, err := collector.GetRawStats(c.pool)
+	// Разрез по базам и ролям нужен только для /diagnosis/scopes — его ошибка не мешает диагнозу
+	if scopes, err := collector.GetScopeStats(c.pool); err != nil {
+		fmt.Printf("[Calculator] Warning: failed to get scope stats: %v\n", err)
+	} else {
+		c.scopes.push(*scopes)
+	}
+	return nil
+}
+
+// CalculateMetrics считает метрики за последние window: TPS/QPS (через дельты
+// семплов из буфера) + DB Time (через ASH). Не ждет: если в буфере истории
+// меньше, чем window (например, сразу после рестарта), метрики добираются из
+// profile_metrics.snapshots. Фактическая длина окна — в WindowSeconds.
+func (c *Calculator) CalculateMetrics(ctx context.Context, window time.Duration) (models.WorkloadMetrics, error) {
+	// Окно отсчитываем от последнего семпла, а не от time.Now(): метки семплов
+	// ставит база, и расхождение часов сдвигало бы окно.
+	// Допуск в полинтервала, чтобы джиттер тикера не выкидывал граничный семпл
+	now := c.dbNow()
+	cutoff := now.Add(-window - SampleInterval/2)
+	samples := c.samples.since(cutoff)
+
+	if span(samples) < window-SampleInterval {
+		// Снэпшоты пишутся реже, поэтому берем их с запасом в один интервал
+		snapshots, err := collector.GetSnapshots(ctx, c.pool, cutoff.Add(-snapshotInterval), now)
+		if err != nil {
+			fmt.Printf("[Calculator] Warning: failed to read snapshots: %v\n", err)
+		} else if span(snapshots) > span(samples) {
+			samples = snapshots
+		}
+	}
+
+	m, err := c.metricsFromSamples(ctx, samples)
+	if span(samples) < window-SampleInterval {
+		// Истории меньше, чем запрошено — окно неполное
+		m.Partial = true
+	}
+	return m, err
+}
+
+// dbNow — текущее время по часам БД: метка последнего семпла, а до первого
+// семпла — часы сервера
+func (c *Calculator) dbNow() time.Time {
+	if last, ok := c.samples.latest(); ok {
+		return last.Timestamp
+	}
+	return time.Now()
+}
+
+// DBTime переводит момент по часам Go-сервера (например, начало и конец прогона
+// нагрузки) в часы БД, которыми помечены семплы, на расхождение, замеренное в Sample
+func (c *Calculator) DBTime(t time.Time) time.Time {
+	return t.Add(time.Duration(c.skew.Load()))
+}
+
+// CalculateRange пересчитывает метрики за произвольный исторический период
+// по сохраненным снэпшотам. Не зависит от того, сколько живет Go-сервер.
+func (c *Calculator) CalculateRange(ctx context.Context, from, to time.Time) (models.WorkloadMetrics, error) {
+	snapshots, err := collector.GetSnapshots(ctx, c.pool, from, to)
 	if err != nil {
-		// Логируем, но не падаем критично, если не смогли взять стату (например, нет pg_stat_statements)
-		fmt.Printf("[Calculator] Warning: failed to get start stats: %v\n", err)
+		return models.WorkloadMetrics{}, err
 	}
+	return c.metricsFromSamples(ctx, snapshots)
+}
 
-	// ---------------------------------------------------------
-	// 2. Ждем накопления данных
-	// ---------------------------------------------------------
-	select {
-	case <-ctx.Done():
-		return m, ctx.Err()
-	case <-time.After(duration):
+// CalculateBetween считает метрики за период [from, to]: по буферу в памяти, если
+// он покрывает период (точнее, шаг SampleInterval), иначе по снэпшотам (CalculateRange)
+func (c *Calculator) CalculateBetween(ctx context.Context, from, to time.Time) (models.WorkloadMetrics, error) {
+	samples := c.samples.between(from.Add(-SampleInterval/2), to.Add(SampleInterval/2))
+	if len(samples) >= 2 && !samples[0].Timestamp.After(from.Add(SampleInterval)) {
+		return c.metricsFromSamples(ctx, samples)
 	}
+	return c.CalculateRange(ctx, from.Add(-snapshotInterval), to.Add(snapshotInterval))
+}
+
+// Curve — TPS, QPS и средняя задержка запроса по каждому интервалу между соседними
+// семплами буфера в [from, to]. Периоды старше буфера (MaxWindow) не покрываются.
+func (c *Calculator) Curve(from, to time.Time) []models.MetricsPoint {
+	samples := c.samples.between(from.Add(-SampleInterval/2), to.Add(SampleInterval/2))
+	points := []models.MetricsPoint{}
+	for i := 1; i < len(samples); i++ {
+		m := deltaMetrics(samples[i-1 : i+1])
+		if m.WindowSeconds == 0 {
+			continue
+		}
+		points = append(points, models.MetricsPoint{
+			Timestamp:  samples[i].Timestamp,
+			TPS:        m.TPS,
+			QPS:        m.QPS,
+			AvgLatency: m.AvgLatency,
+		})
+	}
+	return points
+}
 
+func (c *Calculator) metricsFromSamples(ctx context.Context, samples []collector.RawStats) (models.WorkloadMetrics, error) {
 	// ---------------------------------------------------------
-	// 3. Снапшот счетчиков "ПОСЛЕ" (End Snapshot)
+	// 1. Расчет TPS, QPS, Latency и DB TIME по дельтам семплов
 	// ---------------------------------------------------------
-	endStats, err := collector.GetRawStats(c.pool)
-	if err != nil {
-		fmt.Printf("[Calculator] Warning: failed to get end stats: %v\n", err)
-	}
+	m := deltaMetrics(samples)
 
 	// ---------------------------------------------------------
-	// 4. Расчет TPS, QPS, Latency и DB TIME
+	// 2. Проверка на активность (EARLY EXIT)
 	// ---------------------------------------------------------
-	if startStats != nil && endStats != nil {
-		seconds := duration.Seconds()
-		if seconds == 0 {
-			seconds = 1
+	// Если за интервал времени не было выполнено ни одного запроса,
+	// то нет смысла анализировать дальше — это IDLE.
+	if m.DBTimeTotal <= 0 {
+		return m, nil
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	return m, c.applyASH(ctx, &m, first.Timestamp, last.Timestamp)
+}
+
+// span — сколько времени покрывают семплы
+func span(samples []collector.RawStats) time.Duration {
+	if len(samples) < 2 {
+		return 0
+	}
+	return samples[len(samples)-1].Timestamp.Sub(samples[0].Timestamp)
+}
+
+// CalculateWindows считает метрики сразу для нескольких окон
+func (c *Calculator) CalculateWindows(ctx context.Context, windows []time.Duration) (map[string]models.WorkloadMetrics, error) {
+	result := make(map[string]models.WorkloadMetrics, len(windows))
+	for _, w := range windows {
+		m, err := c.CalculateMetrics(ctx, w)
+		if err != nil {
+			return nil, fmt.Errorf("window %s: %w", WindowLabel(w), err)
 		}
+		result[WindowLabel(w)] = m
+	}
+	return result, nil
+}
+
+// deltaMetrics суммирует дельты между соседними семплами и переводит их в скорости.
+// Интервалы со сбросом статистики, рестартом или вытеснением pg_stat_statements
+// пересчитываются от момента сброса или отбрасываются (см. resets.go); в этом случае
+// окно помечается как Partial, а скорости делятся только на учтенное время.
+func deltaMetrics(samples []collector.RawStats) models.WorkloadMetrics {
+	var m models.WorkloadMetrics
+	if len(samples) < 2 {
+		return m
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	if !last.Timestamp.After(first.Timestamp) {
+		return m
+	}
+	m.WindowSeconds = last.Timestamp.Sub(first.Timestamp).Seconds()
 
-		deltaCommits := float64(endStats.XactCommit - startStats.XactCommit)
-		deltaRollbacks := float64(endStats.XactRollback - startStats.XactRollback)
-		deltaCalls := float64(endStats.TotalCalls - startStats.TotalCalls)
-		deltaExecTime := endStats.TotalExecTime - startStats.TotalExecTime // ms
-
-		m.TPS = deltaCommits / seconds
-		m.QPS = deltaCalls / seconds
-		
-		// Rollback% - процент откатов от общего числа транзакций
-		totalTransactions := deltaCommits + deltaRollbacks
-		if totalTransactions > 0 {
-			m.RollbackRate = (deltaRollbacks / totalTransactions) * 100 // процент
-			m.CommitRatio = (deltaCommits / totalTransactions) * 100    // процент коммитов
-		} else {
-			m.RollbackRate = 0
-			m.CommitRatio = 0
+	var dbSeconds, bgSeconds, stmtSeconds float64
+	var deltaCommits, deltaRollbacks, deltaCalls, deltaExecTime float64
+	var deltaRead, deltaHit float64
+	var deltaReturned, deltaFetched, deltaInserted, deltaUpdated, deltaDeleted float64
+	var deltaCheckpoint, deltaClean, deltaBackend float64
+	resets := make(map[string]bool)
+
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+
+		iv := databaseInterval(prev, cur)
+		if iv.reason != "" {
+			resets[iv.reason] = true
+		}
+		if iv.mode != modeDiscard {
+			base := iv.base(prev)
+			dbSeconds += iv.seconds
+			deltaCommits += float64(cur.XactCommit - base.XactCommit)
+			deltaRollbacks += float64(cur.XactRollback - base.XactRollback)
+			deltaRead += float64(cur.BlksRead - base.BlksRead)
+			deltaHit += float64(cur.BlksHit - base.BlksHit)
+			deltaReturned += float64(cur.TupReturned - base.TupReturned)
+			deltaFetched += float64(cur.TupFetched - base.TupFetched)
+			deltaInserted += float64(cur.TupInserted - base.TupInserted)
+			deltaUpdated += float64(cur.TupUpdated - base.TupUpdated)
+			deltaDeleted += float64(cur.TupDeleted - base.TupDeleted)
 		}
 
-		// DB Time Total теперь берется из pg_stat_statements
-		// Это общее время, которое база потратила на выполнение запросов
-		if deltaExecTime > 0 {
-			m.DBTimeTotal = deltaExecTime / 1000.0 // в секунды
+		iv = bgwriterInterval(prev, cur)
+		if iv.reason != "" {
+			resets[iv.reason] = true
 		}
-		if deltaCalls > 0 {
-			m.AvgLatency = deltaExecTime / deltaCalls // ms
+		if iv.mode != modeDiscard {
+			base := iv.base(prev)
+			bgSeconds += iv.seconds
+			deltaCheckpoint += float64(cur.BuffersCheckpoint - base.BuffersCheckpoint)
+			deltaClean += float64(cur.BuffersClean - base.BuffersClean)
+			deltaBackend += float64(cur.BuffersBackend - base.BuffersBackend)
 		}
 
-		// Заполняем абсолютные счетчики (для отладки/инфо)
-		m.TotalCommits = endStats.XactCommit
-		m.TotalRollbacks = endStats.XactRollback
-		m.TotalCalls = endStats.TotalCalls
+		iv = statementsInterval(prev, cur)
+		if iv.reason != "" {
+			resets[iv.reason] = true
+		}
+		if iv.mode != modeDiscard {
+			base := iv.base(prev)
+			stmtSeconds += iv.seconds
+			deltaCalls += float64(cur.TotalCalls - base.TotalCalls)
+			deltaExecTime += cur.TotalExecTime - base.TotalExecTime // ms
+		}
 	}
 
-	// ---------------------------------------------------------
-	// 5. Проверка на активность (EARLY EXIT)
-	// ---------------------------------------------------------
-	// Если за интервал времени не было выполнено ни одного запроса,
-	// то нет смысла анализировать дальше — это IDLE.
-	if m.DBTimeTotal <= 0 {
-		return m, nil
+	for reason := range resets {
+		m.Resets = append(m.Resets, reason)
+	}
+	sort.Strings(m.Resets)
+	m.Partial = len(m.Resets) > 0
+
+	if dbSeconds > 0 {
+		m.TPS = deltaCommits / dbSeconds
+	}
+	if stmtSeconds > 0 {
+		m.QPS = deltaCalls / stmtSeconds
+	}
+
+	// Rollback% - процент откатов от общего числа транзакций
+	totalTransactions := deltaCommits + deltaRollbacks
+	if totalTransactions > 0 {
+		m.RollbackRate = (deltaRollbacks / totalTransactions) * 100 // процент
+		m.CommitRatio = (deltaCommits / totalTransactions) * 100    // процент коммитов
+	}
+
+	// DB Time Total берется из pg_stat_statements
+	// Это общее время, которое база потратила на выполнение запросов.
+	// Если часть окна отброшена, экстраполируем на полное окно, чтобы
+	// DB Time оставался сопоставим с окнами без сбросов.
+	if deltaExecTime > 0 && stmtSeconds > 0 {
+		m.DBTimeTotal = deltaExecTime / 1000.0 * m.WindowSeconds / stmtSeconds // в секунды
+	}
+	if deltaCalls > 0 {
+		m.AvgLatency = deltaExecTime / deltaCalls // ms
+	}
+
+	// Cache hit ratio и пропускная способность по блокам/строкам/буферам
+	if deltaRead+deltaHit > 0 {
+		m.CacheHitRatio = deltaHit / (deltaRead + deltaHit) * 100
+	}
+	if dbSeconds > 0 {
+		m.BlksReadPS = deltaRead / dbSeconds
+		m.BlksHitPS = deltaHit / dbSeconds
+		m.TupReturnedPS = deltaReturned / dbSeconds
+		m.TupFetchedPS = deltaFetched / dbSeconds
+		m.TupInsertedPS = deltaInserted / dbSeconds
+		m.TupUpdatedPS = deltaUpdated / dbSeconds
+		m.TupDeletedPS = deltaDeleted / dbSeconds
+	}
+	if bgSeconds > 0 {
+		m.BuffersCheckpointPS = deltaCheckpoint / bgSeconds
+		m.BuffersCleanPS = deltaClean / bgSeconds
+		m.BuffersBackendPS = deltaBackend / bgSeconds
 	}
 
+	// Заполняем абсолютные счетчики (для отладки/инфо)
+	m.TotalCommits = last.XactCommit
+	m.TotalRollbacks = last.XactRollback
+	m.TotalCalls = last.TotalCalls
+
+	return m
+}
+
+// applyASH раскладывает DB Time на CPU/IO/Lock по пропорциям ASH за тот же период
+func (c *Calculator) applyASH(ctx context.Context, m *models.WorkloadMetrics, from, to time.Time) error {
 	// ========================================================================
-	// 6. Считаем пропорции нагрузки через ASH (pg_stat_activity)
+	// Считаем пропорции нагрузки через ASH (pg_stat_activity)
 	// ========================================================================
 	queryASH := `
         WITH ash_stats AS (
@@ -118,17 +324,23 @@ func (c *Calculator) CalculateMetrics(ctx context.Context, duration time.Duratio
                 -- DB Time ASH (Lock): блокировки
                 count(*) FILTER (WHERE wait_event_type IN ('Lock', 'LWLock')) as lock_samples
             FROM profile_metrics.ash_samples
-            WHERE sample_time >= NOW() - $1::interval
+            WHERE sample_time BETWEEN $1 AND $2
         )
         SELECT total_samples, cpu_samples, io_samples, lock_samples FROM ash_stats;
     `
 
 	var totalSamples, cpuSamples, ioSamples, lockSamples float64
-	err = c.pool.QueryRow(ctx, queryASH, duration.String()).Scan(&totalSamples, &cpuSamples, &ioSamples, &lockSamples)
+	err := c.pool.QueryRow(ctx, queryASH, from, to).Scan(&totalSamples, &cpuSamples, &ioSamples, &lockSamples)
 	if err != nil {
-		return m, fmt.Errorf("failed to get ash stats: %w", err)
+		return fmt.Errorf("failed to get ash stats: %w", err)
 	}
 
+	applyASHRatios(m, totalSamples, cpuSamples, ioSamples, lockSamples)
+	return nil
+}
+
+// applyASHRatios применяет формулы DB Time к уже посчитанным долям ASH
+func applyASHRatios(m *models.WorkloadMetrics, totalSamples, cpuSamples, ioSamples, lockSamples float64) {
 	if totalSamples == 0 {
 		// Если ASH пуст, это означает, что база простаивает (нет активных запросов)
 		// В этом случае все метрики должны быть 0
@@ -139,11 +351,11 @@ func (c *Calculator) CalculateMetrics(ctx context.Context, duration time.Duratio
 		m.CPUPercent = 0
 		m.IOPercent = 0
 		m.LockPercent = 0
-		return m, nil
+		return
 	}
 
 	// ========================================================================
-	// 7. Применяем формулы
+	// Применяем формулы
 	// ========================================================================
 
 	// Вычисляем доли (percentages)
@@ -181,6 +393,4 @@ func (c *Calculator) CalculateMetrics(ctx context.Context, duration time.Duratio
 	} else {
 		m.DominateDBTime = m.LockPercent
 	}
-
-	return m, nil
 }
@@ -1,178 +1,313 @@
 package analyzer
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/lypolix/pg_load_profile/internal/configurator"
 	"github.com/lypolix/pg_load_profile/internal/models"
 )
 
 type Diagnosis struct {
 	Profile     string                 `json:"profile"`
+	ProfileKey  string                 `json:"profile_key"`
 	Description string                 `json:"description"`
-	Confidence  string                 `json:"confidence"`
+	Confidence  string                 `json:"confidence"` // High / Medium / Low по ConfidenceScore
 	Metrics     models.WorkloadMetrics `json:"metrics"`
 	Tuning      models.TuningConfig    `json:"tuning_recommendations"`
+	Preset      string                 `json:"preset,omitempty"` // Пресет, из которого взят Tuning
 	Reasoning   string                 `json:"reasoning"`
+
+	// --- Почему выбран этот профиль ---
+	ConfidenceScore float64            `json:"confidence_score"`   // Калиброванная вероятность победителя (0..1)
+	Scores          map[string]float64 `json:"scores"`             // Баллы всех профилей
+	RunnerUp        string             `json:"runner_up"`          // Второй по баллам профиль
+	Margin          float64            `json:"margin"`             // Отрыв победителя от второго места
+	Fallback        bool               `json:"fallback,omitempty"` // Никто не набрал fallback.min_score, профиль — fallback
+	FiredRules      []RuleContribution `json:"fired_rules"`        // Сработавшие правила и их вклад
+}
+
+// RuleContribution — сработавшее правило: что с чем сравнивали и сколько баллов оно дало
+type RuleContribution struct {
+	Rule      string             `json:"rule"`
+	Metric    string             `json:"metric"`
+	Observed  float64            `json:"observed"` // значение метрики
+	Op        string             `json:"op"`
+	Threshold float64            `json:"threshold"` // value или ref_metric * factor
+	Points    map[string]float64 `json:"points"`
+}
+
+// RuleEngine — классификатор на баллах, правила которого берутся из RuleSet.
+// Правила можно подменить на лету (Reload/Watch), не перезапуская сервер.
+type RuleEngine struct {
+	mu      sync.RWMutex
+	rules   *RuleSet
+	path    string // пусто — встроенные правила
+	modTime time.Time
+}
+
+var defaultEngine = newDefaultEngine()
+
+func init() {
+	// Пресет, на который ссылаются текущие правила, нельзя удалить
+	configurator.SetPresetUsers(defaultEngine.ProfilesUsingPreset)
+}
+
+func newDefaultEngine() *RuleEngine {
+	rs, err := ParseRuleSet(defaultRulesJSON)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in classifier rules: %v", err))
+	}
+	return &RuleEngine{rules: rs}
+}
+
+// DefaultEngine возвращает движок, которым пользуется ClassifyWorkload
+func DefaultEngine() *RuleEngine {
+	return defaultEngine
 }
 
 // ClassifyWorkload использует систему баллов для определения победителя
 func ClassifyWorkload(m models.WorkloadMetrics) Diagnosis {
+	return defaultEngine.Classify(m)
+}
+
+// LoadFile переключает движок на правила из файла. При ошибке остаются старые правила.
+func (e *RuleEngine) LoadFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat rules file: %w", err)
+	}
+	rs, err := LoadRuleSet(path)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.rules = rs
+	e.path = path
+	e.modTime = info.ModTime()
+	e.mu.Unlock()
+	return nil
+}
+
+// Reload перечитывает текущий файл правил (для встроенных правил — no-op)
+func (e *RuleEngine) Reload() error {
+	e.mu.RLock()
+	path := e.path
+	e.mu.RUnlock()
+
+	if path == "" {
+		return nil
+	}
+	return e.LoadFile(path)
+}
+
+// Watch раз в interval проверяет mtime файла правил и перечитывает его при изменении
+func (e *RuleEngine) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.mu.RLock()
+			path, modTime := e.path, e.modTime
+			e.mu.RUnlock()
+			if path == "" {
+				continue
+			}
+
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			if err := e.LoadFile(path); err != nil {
+				log.Printf("[Classifier] Rules reload failed, keeping previous rules: %v", err)
+				continue
+			}
+			log.Printf("[Classifier] Rules reloaded from %s", path)
+		}
+	}
+}
+
+// ProfilesUsingPreset возвращает профили текущих правил, настройки которых
+// берутся из пресета name (в стабильном порядке)
+func (e *RuleEngine) ProfilesUsingPreset(name string) []string {
+	if name == "" {
+		return nil
+	}
+	rs, _ := e.Rules()
+	var users []string
+	if rs.Idle.Preset == name {
+		users = append(users, "classifier profile IDLE")
+	}
+	for _, key := range rs.profileKeys() {
+		if rs.Profiles[key].Preset == name {
+			users = append(users, "classifier profile "+key)
+		}
+	}
+	return users
+}
+
+// Rules возвращает текущие правила и файл, из которого они загружены
+func (e *RuleEngine) Rules() (*RuleSet, string) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.rules, e.path
+}
+
+// Classify считает баллы профилей по правилам и выбирает победителя
+func (e *RuleEngine) Classify(m models.WorkloadMetrics) Diagnosis {
+	rs, _ := e.Rules()
 	d := Diagnosis{Metrics: m}
 
 	// 0. IDLE Check (Fast Path)
-	if m.DBTimeTotal < 1.0 {
-		d.Profile = "IDLE"
-		d.Description = "Система простаивает. Нагрузки нет."
-		d.Confidence = "High"
-		// Легкие настройки для простоя
-		d.Tuning = models.TuningConfig{
-			SharedBuffers:      "128MB",
-			WorkMem:            "4MB",
-			CheckpointTimeout:  "30min",
-			MaxWalSize:         "1GB",
-			SynchronousCommit:  "on",
-			MaxParallelWorkers: "0",
-			DeadlockTimeout:    "1s",
-		}
-		return d
+	if m.DBTimeTotal < rs.IdleDBTime {
+		d.ProfileKey = "IDLE"
+		// Чем дальше DB Time от порога, тем увереннее IDLE
+		d.ConfidenceScore = 1 - 0.5*m.DBTimeTotal/rs.IdleDBTime
+		d.Confidence = rs.Confidence.label(d.ConfidenceScore)
+		return fillDetails(d, rs.Idle)
 	}
 
 	// --- SCORING SYSTEM ---
-	scores := map[string]float64{
-		"OLAP":      0,
-		"OLTP":      0,
-		"IOT":       0,
-		"LOCKS":     0,
-		"COLD":      0,
-		"REPORTING": 0,
-		"ETL":       0,
-	}
-
-	// 1. Анализ IO (Disk bound)
-	if m.IOPercent > 40 {
-		scores["COLD"] += 3.0
-		scores["OLAP"] += 2.0
-		scores["ETL"] += 1.5
-	} else if m.IOPercent > 20 {
-		scores["OLAP"] += 1.5
-		scores["IOT"] += 2.0
-		scores["ETL"] += 1.0
-	} else if m.IOPercent < 5 {
-		scores["OLTP"] += 2.0
-		scores["REPORTING"] += 2.0
-	}
-
-	// 2. Анализ CPU (Compute bound)
-	if m.CPUPercent > 80 {
-		scores["REPORTING"] += 3.0
-		scores["OLTP"] += 2.0
-	} else if m.CPUPercent > 50 {
-		scores["OLTP"] += 2.0
-		scores["OLAP"] += 1.0
-	} else if m.CPUPercent < 15 {
-		scores["COLD"] += 2.0
-		scores["IOT"] += 1.0
-	}
-
-	// 3. Анализ Locks (Contention)
-	if m.LockPercent > 15 {
-		scores["LOCKS"] += 5.0
-	} else if m.LockPercent > 5 {
-		scores["LOCKS"] += 2.0
-		scores["OLTP"] -= 1.0
-	}
-
-	// 4. Анализ Соотношений (Ratio)
-	if m.CPUPercent > m.IOPercent*4 {
-		scores["REPORTING"] += 1.0
-	}
-	if m.IOPercent > m.CPUPercent*2 {
-		scores["COLD"] += 1.0
-		scores["IOT"] += 1.0
+	keys := rs.profileKeys()
+	scores := make(map[string]float64, len(keys))
+	for _, key := range keys {
+		scores[key] = 0
+	}
+	firedGroups := make(map[string]bool)
+
+	for _, rule := range rs.Rules {
+		if rule.Group != "" && firedGroups[rule.Group] {
+			continue
+		}
+		if !rule.matches(m) {
+			continue
+		}
+		if rule.Group != "" {
+			firedGroups[rule.Group] = true
+		}
+		for profile, points := range rule.Scores {
+			scores[profile] += points
+		}
+		d.FiredRules = append(d.FiredRules, rule.contribution(m))
 	}
 
 	// --- ВЫБОР ПОБЕДИТЕЛЯ ---
 	var winner string
 	var maxScore float64
 
-	for name, score := range scores {
-		if score > maxScore {
-			maxScore = score
+	for _, name := range keys {
+		if scores[name] > maxScore {
+			maxScore = scores[name]
 			winner = name
 		}
 	}
 
-	// Fallback
-	if maxScore < 2.0 {
-		winner = "MIXED"
+	// Fallback (в том числе когда ни один профиль не набрал положительных баллов)
+	fallback := winner == "" || maxScore < rs.Fallback.MinScore
+	if fallback {
+		winner = rs.Fallback.Profile
 	}
 
-	d.Profile = winner
-	d.Reasoning = fmt.Sprintf("Score: %.1f | IO: %.0f%%, CPU: %.0f%%, Lock: %.0f%%", maxScore, m.IOPercent, m.CPUPercent, m.LockPercent)
+	// Второе место и отрыв от него
+	runnerUp, runnerUpScore := "", 0.0
+	for _, name := range keys {
+		if name == winner {
+			continue
+		}
+		if runnerUp == "" || scores[name] > runnerUpScore {
+			runnerUp, runnerUpScore = name, scores[name]
+		}
+	}
 
-	// Заполняем детали
-	return fillDetails(d)
-}
-
-// fillDetails заполняет конфиг РЕАЛЬНЫМИ параметрами postgresql.conf
-func fillDetails(d Diagnosis) Diagnosis {
-	presetName := ""
-	switch d.Profile {
-	case "LOCKS":
-		d.Profile = "HIGH CONCURRENCY"
-		d.Description = "Критическая конкуренция за ресурсы (Row locks, LWLock)."
-		d.Confidence = "High"
-		presetName = "high_concurrency"
-	case "COLD":
-		d.Profile = "COLD / ARCHIVE-SCAN"
-		d.Description = "Полное сканирование холодных данных. Бэкап или SeqScan."
-		d.Confidence = "High"
-		presetName = "cold"
-	case "OLAP":
-		d.Profile = "OLAP (ANALYTICAL)"
-		d.Description = "Тяжелые запросы, JOIN, агрегации. Data Mining."
-		d.Confidence = "Medium"
-		presetName = "olap"
-	case "ETL":
-		d.Profile = "BULK ETL / BATCH LOAD"
-		d.Description = "Массовая загрузка данных. Высокая нагрузка на WAL."
-		d.Confidence = "Medium"
-		presetName = "etl"
-	case "IOT":
-		d.Profile = "WRITE-HEAVY (IoT)"
-		d.Description = "Постоянный поток вставок. Телеметрия."
+	d.ProfileKey = winner
+	d.Scores = scores
+	d.RunnerUp = runnerUp
+	if fallback {
+		// Fallback-профиль не выигрывал по баллам: отрыва нет, а в runner_up —
+		// настоящий лидер (если им был не сам fallback). Уверенность нулевая.
+		d.Fallback = true
 		d.Confidence = "Low"
-		presetName = "write_heavy"
-	case "REPORTING":
-		d.Profile = "READ-HEAVY / REPORTING"
-		d.Description = "Агрессивное чтение из кэша (RAM). Горячие отчеты."
-		d.Confidence = "Medium"
-		presetName = "reporting"
-	case "OLTP":
-		d.Profile = "CLASSIC OLTP"
-		d.Description = "Банкинг, Биржа. Короткие транзакции."
-		d.Confidence = "High"
-		presetName = "oltp"
-	default: // MIXED
-		d.Profile = "MIXED / HTAP"
-		d.Description = "Смешанная нагрузка: транзакции + аналитика."
-		d.Confidence = "Low"
-		presetName = "mixed"
+	} else {
+		d.Margin = scores[winner] - runnerUpScore
+		d.ConfidenceScore = rs.Confidence.probability(scores, winner)
+		d.Confidence = rs.Confidence.label(d.ConfidenceScore)
+	}
+	d.Reasoning = fmt.Sprintf("Score: %.1f (runner-up %s: %.1f, margin %.1f, confidence %.0f%%) | IO: %.0f%%, CPU: %.0f%%, Lock: %.0f%% | Rules: %s",
+		scores[winner], runnerUp, runnerUpScore, d.Margin, d.ConfidenceScore*100,
+		m.IOPercent, m.CPUPercent, m.LockPercent, firedRuleNames(d.FiredRules))
+	if fallback {
+		d.Reasoning = fmt.Sprintf("Fallback: no profile reached %.1f points | ", rs.Fallback.MinScore) + d.Reasoning
 	}
 
-	settings := configurator.GetSettingsForPreset(presetName)
+	// Заполняем детали
+	return fillDetails(d, rs.Profiles[winner])
+}
+
+// fillDetails заполняет описание профиля и конфиг РЕАЛЬНЫМИ параметрами postgresql.conf
+func fillDetails(d Diagnosis, p ProfileSpec) Diagnosis {
+	d.Profile = p.Title
+	d.Description = p.Description
+	d.Preset = p.Preset
+
+	settings := p.Settings
+	if p.Preset != "" {
+		settings = configurator.GetSettingsForPreset(p.Preset)
+	}
 	if settings != nil {
-		d.Tuning = models.TuningConfig{
-			SharedBuffers:      settings["shared_buffers"],
-			WorkMem:            settings["work_mem"],
-			MaxWalSize:         settings["max_wal_size"],
-			CheckpointTimeout:  settings["checkpoint_timeout"],
-			SynchronousCommit:  settings["synchronous_commit"],
-			MaxParallelWorkers: settings["max_parallel_workers_per_gather"],
-			DeadlockTimeout:    settings["deadlock_timeout"],
-			AutovacuumNaptime:  settings["autovacuum_naptime"],
-		}
+		d.Tuning = models.TuningConfig(settings)
 	}
 
 	return d
 }
+
+// probability — softmax-вероятность победителя по баллам всех профилей
+func (c ConfidenceSpec) probability(scores map[string]float64, winner string) float64 {
+	// Вычитаем максимум, чтобы exp не переполнялся
+	maxScore := math.Inf(-1)
+	for _, score := range scores {
+		maxScore = math.Max(maxScore, score)
+	}
+
+	var sum float64
+	for _, score := range scores {
+		sum += math.Exp((score - maxScore) / c.Temperature)
+	}
+	if sum == 0 {
+		return 0
+	}
+	return math.Exp((scores[winner]-maxScore)/c.Temperature) / sum
+}
+
+// label переводит числовую уверенность в High / Medium / Low
+func (c ConfidenceSpec) label(p float64) string {
+	switch {
+	case p >= c.High:
+		return "High"
+	case p >= c.Medium:
+		return "Medium"
+	default:
+		return "Low"
+	}
+}
+
+func firedRuleNames(rules []RuleContribution) string {
+	if len(rules) == 0 {
+		return "none"
+	}
+	names := make([]string, len(rules))
+	for i, r := range rules {
+		names[i] = r.Rule
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,110 @@
+package analyzer
+
+import (
+	"time"
+
+	"github.com/lypolix/pg_load_profile/internal/collector"
+)
+
+// Причины, по которым интервал между семплами был отброшен или пересчитан
+const (
+	ResetServerRestart     = "server_restart"
+	ResetDatabaseStats     = "pg_stat_reset"
+	ResetBgwriterStats     = "pg_stat_bgwriter_reset"
+	ResetStatementsStats   = "pg_stat_statements_reset"
+	ResetStatementsDealloc = "pg_stat_statements_dealloc"
+)
+
+// intervalMode — как учитывать дельту группы счетчиков между двумя соседними семплами
+type intervalMode int
+
+const (
+	// modeDelta — обычный случай: cur - prev за весь интервал
+	modeDelta intervalMode = iota
+	// modeRebase — счетчики сброшены в известный момент внутри интервала:
+	// считаем от нуля и только время после сброса
+	modeRebase
+	// modeDiscard — интервал нельзя использовать (рестарт, неизвестный момент сброса, вытеснение)
+	modeDiscard
+)
+
+// interval — результат анализа группы счетчиков на одном интервале
+type interval struct {
+	mode    intervalMode
+	seconds float64 // сколько секунд интервала можно учесть
+	reason  string  // почему интервал пересчитан/отброшен (пусто для modeDelta)
+}
+
+// base возвращает семпл, от которого считать дельту группы
+func (iv interval) base(prev collector.RawStats) collector.RawStats {
+	if iv.mode == modeRebase {
+		return collector.RawStats{}
+	}
+	return prev
+}
+
+func serverRestarted(prev, cur collector.RawStats) bool {
+	return !prev.PostmasterStart.IsZero() && !cur.PostmasterStart.IsZero() &&
+		!prev.PostmasterStart.Equal(cur.PostmasterStart)
+}
+
+// checkReset решает судьбу интервала для одной группы счетчиков.
+// prevReset/curReset — значение stats_reset группы в соседних семплах,
+// decreased — уменьшился ли хоть один счетчик группы (признак сброса без отметки).
+func checkReset(prev, cur collector.RawStats, prevReset, curReset *time.Time, decreased bool, reason string) interval {
+	seconds := cur.Timestamp.Sub(prev.Timestamp).Seconds()
+
+	// Рестарт: интервал включает простой, счетчики могли как сохраниться, так и обнулиться
+	if serverRestarted(prev, cur) {
+		return interval{mode: modeDiscard, reason: ResetServerRestart}
+	}
+
+	if !sameTime(prevReset, curReset) {
+		// Знаем момент сброса и он внутри интервала — пересчитываем от нуля
+		if curReset != nil && curReset.After(prev.Timestamp) && !curReset.After(cur.Timestamp) {
+			return interval{mode: modeRebase, seconds: cur.Timestamp.Sub(*curReset).Seconds(), reason: reason}
+		}
+		return interval{mode: modeDiscard, reason: reason}
+	}
+
+	// stats_reset не изменился, но счетчик уменьшился — сброс, момент которого неизвестен
+	if decreased {
+		return interval{mode: modeDiscard, reason: reason}
+	}
+
+	return interval{mode: modeDelta, seconds: seconds}
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
+
+// databaseInterval — счетчики pg_stat_database (транзакции, блоки, строки)
+func databaseInterval(prev, cur collector.RawStats) interval {
+	decreased := cur.XactCommit < prev.XactCommit || cur.XactRollback < prev.XactRollback ||
+		cur.BlksRead < prev.BlksRead || cur.BlksHit < prev.BlksHit ||
+		cur.TupReturned < prev.TupReturned || cur.TupFetched < prev.TupFetched ||
+		cur.TupInserted < prev.TupInserted || cur.TupUpdated < prev.TupUpdated || cur.TupDeleted < prev.TupDeleted
+	return checkReset(prev, cur, prev.StatsReset, cur.StatsReset, decreased, ResetDatabaseStats)
+}
+
+// bgwriterInterval — счетчики pg_stat_bgwriter
+func bgwriterInterval(prev, cur collector.RawStats) interval {
+	decreased := cur.BuffersCheckpoint < prev.BuffersCheckpoint || cur.BuffersClean < prev.BuffersClean ||
+		cur.BuffersBackend < prev.BuffersBackend
+	return checkReset(prev, cur, prev.BgwriterReset, cur.BgwriterReset, decreased, ResetBgwriterStats)
+}
+
+// statementsInterval — суммы pg_stat_statements. Вытеснение записей (dealloc) уменьшает
+// суммы без сброса, поэтому такой интервал отбрасываем целиком.
+func statementsInterval(prev, cur collector.RawStats) interval {
+	iv := checkReset(prev, cur, prev.StatementsReset, cur.StatementsReset,
+		cur.TotalCalls < prev.TotalCalls || cur.TotalExecTime < prev.TotalExecTime, ResetStatementsStats)
+	if iv.mode == modeDelta && cur.StatementsDealloc != prev.StatementsDealloc {
+		return interval{mode: modeDiscard, reason: ResetStatementsDealloc}
+	}
+	return iv
+}
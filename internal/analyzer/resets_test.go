@@ -0,0 +1,150 @@
+package analyzer
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lypolix/pg_load_profile/internal/collector"
+)
+
+func TestCheckReset(t *testing.T) {
+	t0 := time.Date(2025, 11, 27, 12, 0, 0, 0, time.UTC)
+	at := func(s int) *time.Time {
+		v := t0.Add(time.Duration(s) * time.Second)
+		return &v
+	}
+	boot := t0.Add(-time.Hour)
+	prev := collector.RawStats{Timestamp: t0, PostmasterStart: boot}
+	cur := collector.RawStats{Timestamp: t0.Add(10 * time.Second), PostmasterStart: boot}
+	restarted := cur
+	restarted.PostmasterStart = t0.Add(5 * time.Second)
+	unknownStart := cur
+	unknownStart.PostmasterStart = time.Time{}
+
+	tests := []struct {
+		name                string
+		cur                 collector.RawStats
+		prevReset, curReset *time.Time
+		decreased           bool
+		want                interval
+	}{
+		{"plain delta", cur, nil, nil, false, interval{mode: modeDelta, seconds: 10}},
+		{"same reset mark", cur, at(-100), at(-100), false, interval{mode: modeDelta, seconds: 10}},
+		{"server restart", restarted, nil, nil, false, interval{mode: modeDiscard, reason: ResetServerRestart}},
+		{"restart wins over reset mark", restarted, nil, at(7), true, interval{mode: modeDiscard, reason: ResetServerRestart}},
+		{"postmaster start unknown", unknownStart, nil, nil, false, interval{mode: modeDelta, seconds: 10}},
+		{"reset inside interval", cur, nil, at(4), true, interval{mode: modeRebase, seconds: 6, reason: ResetDatabaseStats}},
+		{"reset after earlier reset", cur, at(-100), at(8), true, interval{mode: modeRebase, seconds: 2, reason: ResetDatabaseStats}},
+		{"reset exactly at sample", cur, nil, at(10), true, interval{mode: modeRebase, seconds: 0, reason: ResetDatabaseStats}},
+		{"reset at previous sample", cur, nil, at(0), true, interval{mode: modeDiscard, reason: ResetDatabaseStats}},
+		{"reset outside interval", cur, at(-100), at(-50), false, interval{mode: modeDiscard, reason: ResetDatabaseStats}},
+		{"reset mark disappeared", cur, at(-100), nil, false, interval{mode: modeDiscard, reason: ResetDatabaseStats}},
+		{"counter decreased without mark", cur, nil, nil, true, interval{mode: modeDiscard, reason: ResetDatabaseStats}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkReset(prev, tt.cur, tt.prevReset, tt.curReset, tt.decreased, ResetDatabaseStats)
+			if got.mode != tt.want.mode || got.reason != tt.want.reason || math.Abs(got.seconds-tt.want.seconds) > 1e-9 {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatementsIntervalDealloc(t *testing.T) {
+	t0 := time.Date(2025, 11, 27, 12, 0, 0, 0, time.UTC)
+	reset := t0.Add(3 * time.Second)
+	prev := collector.RawStats{Timestamp: t0, TotalCalls: 1000, TotalExecTime: 500, StatementsDealloc: 2}
+
+	tests := []struct {
+		name   string
+		cur    collector.RawStats
+		mode   intervalMode
+		reason string
+	}{
+		{"growth", collector.RawStats{Timestamp: t0.Add(5 * time.Second), TotalCalls: 1500, TotalExecTime: 700, StatementsDealloc: 2},
+			modeDelta, ""},
+		{"dealloc", collector.RawStats{Timestamp: t0.Add(5 * time.Second), TotalCalls: 1500, TotalExecTime: 700, StatementsDealloc: 3},
+			modeDiscard, ResetStatementsDealloc},
+		{"dealloc shrinks sums", collector.RawStats{Timestamp: t0.Add(5 * time.Second), TotalCalls: 900, TotalExecTime: 400, StatementsDealloc: 3},
+			modeDiscard, ResetStatementsStats},
+		{"reset", collector.RawStats{Timestamp: t0.Add(5 * time.Second), TotalCalls: 20, TotalExecTime: 4, StatementsReset: &reset},
+			modeRebase, ResetStatementsStats},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := statementsInterval(prev, tt.cur)
+			if got.mode != tt.mode || got.reason != tt.reason {
+				t.Fatalf("got %+v, want mode %d reason %q", got, tt.mode, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDeltaMetricsRebase(t *testing.T) {
+	t0 := time.Date(2025, 11, 27, 12, 0, 0, 0, time.UTC)
+	boot := t0.Add(-time.Hour)
+	sample := func(s int, commits, calls int64) collector.RawStats {
+		return collector.RawStats{
+			Timestamp:       t0.Add(time.Duration(s) * time.Second),
+			PostmasterStart: boot,
+			XactCommit:      commits,
+			TotalCalls:      calls,
+			TotalExecTime:   float64(calls),
+		}
+	}
+	reset := t0.Add(12 * time.Second)
+
+	tests := []struct {
+		name    string
+		samples []collector.RawStats
+		tps     float64
+		qps     float64
+		resets  []string
+	}{
+		{"no resets", []collector.RawStats{sample(0, 0, 0), sample(10, 100, 200), sample(20, 200, 400)},
+			10, 20, nil},
+		{"pg_stat_reset rebased from reset time", func() []collector.RawStats {
+			s := []collector.RawStats{sample(0, 1000, 0), sample(10, 1100, 200), sample(20, 80, 400)}
+			// Сброс через 2 с после второго семпла: 80 коммитов за 8 с, 100 за первые 10 с
+			s[2].StatsReset = &reset
+			return s
+		}(), 180.0 / 18, 20, []string{ResetDatabaseStats}},
+		{"unmarked decrease is discarded", []collector.RawStats{sample(0, 1000, 0), sample(10, 1100, 200), sample(20, 50, 400)},
+			10, 20, []string{ResetDatabaseStats}},
+		{"restart discards every group", func() []collector.RawStats {
+			s := []collector.RawStats{sample(0, 0, 0), sample(10, 100, 200), sample(20, 5, 10)}
+			s[2].PostmasterStart = t0.Add(15 * time.Second)
+			return s
+		}(), 10, 20, []string{ResetServerRestart}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := deltaMetrics(tt.samples)
+			if math.Abs(m.TPS-tt.tps) > 1e-9 || math.Abs(m.QPS-tt.qps) > 1e-9 {
+				t.Errorf("tps/qps = %v/%v, want %v/%v", m.TPS, m.QPS, tt.tps, tt.qps)
+			}
+			if !reflect.DeepEqual(m.Resets, tt.resets) {
+				t.Errorf("resets = %v, want %v", m.Resets, tt.resets)
+			}
+			if m.Partial != (len(tt.resets) > 0) {
+				t.Errorf("partial = %v", m.Partial)
+			}
+			if m.WindowSeconds != 20 {
+				t.Errorf("window = %v, want 20", m.WindowSeconds)
+			}
+		})
+	}
+}
+
+func TestIntervalBase(t *testing.T) {
+	prev := collector.RawStats{XactCommit: 100}
+	if got := (interval{mode: modeDelta}).base(prev); got.XactCommit != 100 {
+		t.Errorf("delta base = %d, want 100", got.XactCommit)
+	}
+	if got := (interval{mode: modeRebase}).base(prev); got.XactCommit != 0 {
+		t.Errorf("rebase base = %d, want 0", got.XactCommit)
+	}
+}
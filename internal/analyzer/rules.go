@@ -0,0 +1,248 @@
+package analyzer
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/lypolix/pg_load_profile/internal/configurator"
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// defaultRulesJSON — правила, с которыми классификатор работает без внешнего файла
+//
+//go:embed default_rules.json
+var defaultRulesJSON []byte
+
+// RuleSet — описание классификатора: профили, правила начисления баллов и пороги
+type RuleSet struct {
+	IdleDBTime float64                `json:"idle_db_time"` // DB Time (сек) ниже которого нагрузка считается IDLE
+	Idle       ProfileSpec            `json:"idle"`
+	Confidence ConfidenceSpec         `json:"confidence"`
+	Fallback   FallbackSpec           `json:"fallback"`
+	Profiles   map[string]ProfileSpec `json:"profiles"`
+	Rules      []Rule                 `json:"rules"`
+}
+
+// FallbackSpec — какой профиль выбрать, если победитель набрал слишком мало баллов
+type FallbackSpec struct {
+	MinScore float64 `json:"min_score"`
+	Profile  string  `json:"profile"`
+}
+
+// ConfidenceSpec — калибровка уверенности. Баллы профилей переводятся в вероятности
+// через softmax с температурой temperature; high/medium — пороги для меток High/Medium/Low.
+type ConfidenceSpec struct {
+	Temperature float64 `json:"temperature"`
+	High        float64 `json:"high"`
+	Medium      float64 `json:"medium"`
+}
+
+// ProfileSpec — как профиль показывается и какой тюнинг ему рекомендуется.
+// Задается либо preset (имя пресета configurator), либо явные settings.
+type ProfileSpec struct {
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Preset      string            `json:"preset,omitempty"`
+	Settings    map[string]string `json:"settings,omitempty"`
+}
+
+// Rule — одно условие над метрикой и баллы, которые оно дает профилям.
+// Правила с одинаковой group работают как if / else if: срабатывает первое подходящее.
+// Если задан ref_metric, метрика сравнивается не с value, а с ref_metric * factor.
+type Rule struct {
+	Name      string             `json:"name"`
+	Group     string             `json:"group,omitempty"`
+	Metric    string             `json:"metric"`
+	Op        string             `json:"op"`
+	Value     float64            `json:"value"`
+	RefMetric string             `json:"ref_metric,omitempty"`
+	Factor    float64            `json:"factor,omitempty"`
+	Scores    map[string]float64 `json:"scores"`
+}
+
+// metricGetters — метрики, доступные в правилах (по именам JSON-полей WorkloadMetrics)
+var metricGetters = map[string]func(m models.WorkloadMetrics) float64{
+	"db_time_total":              func(m models.WorkloadMetrics) float64 { return m.DBTimeTotal },
+	"db_time_committed":          func(m models.WorkloadMetrics) float64 { return m.DBTimeCommitted },
+	"cpu_time":                   func(m models.WorkloadMetrics) float64 { return m.CPUTime },
+	"io_time":                    func(m models.WorkloadMetrics) float64 { return m.IOTime },
+	"lock_time":                  func(m models.WorkloadMetrics) float64 { return m.LockTime },
+	"cpu_percent":                func(m models.WorkloadMetrics) float64 { return m.CPUPercent },
+	"io_percent":                 func(m models.WorkloadMetrics) float64 { return m.IOPercent },
+	"lock_percent":               func(m models.WorkloadMetrics) float64 { return m.LockPercent },
+	"tps":                        func(m models.WorkloadMetrics) float64 { return m.TPS },
+	"qps":                        func(m models.WorkloadMetrics) float64 { return m.QPS },
+	"avg_query_latency_ms":       func(m models.WorkloadMetrics) float64 { return m.AvgLatency },
+	"rollback_rate":              func(m models.WorkloadMetrics) float64 { return m.RollbackRate },
+	"commit_ratio":               func(m models.WorkloadMetrics) float64 { return m.CommitRatio },
+	"wasted_db_time":             func(m models.WorkloadMetrics) float64 { return m.WastedDBTime },
+	"dominate_db_time":           func(m models.WorkloadMetrics) float64 { return m.DominateDBTime },
+	"cache_hit_ratio":            func(m models.WorkloadMetrics) float64 { return m.CacheHitRatio },
+	"blks_read_per_sec":          func(m models.WorkloadMetrics) float64 { return m.BlksReadPS },
+	"blks_hit_per_sec":           func(m models.WorkloadMetrics) float64 { return m.BlksHitPS },
+	"tup_returned_per_sec":       func(m models.WorkloadMetrics) float64 { return m.TupReturnedPS },
+	"tup_fetched_per_sec":        func(m models.WorkloadMetrics) float64 { return m.TupFetchedPS },
+	"tup_inserted_per_sec":       func(m models.WorkloadMetrics) float64 { return m.TupInsertedPS },
+	"tup_updated_per_sec":        func(m models.WorkloadMetrics) float64 { return m.TupUpdatedPS },
+	"tup_deleted_per_sec":        func(m models.WorkloadMetrics) float64 { return m.TupDeletedPS },
+	"buffers_checkpoint_per_sec": func(m models.WorkloadMetrics) float64 { return m.BuffersCheckpointPS },
+	"buffers_clean_per_sec":      func(m models.WorkloadMetrics) float64 { return m.BuffersCleanPS },
+	"buffers_backend_per_sec":    func(m models.WorkloadMetrics) float64 { return m.BuffersBackendPS },
+}
+
+// matches проверяет условие правила на метриках
+func (r Rule) matches(m models.WorkloadMetrics) bool {
+	left := metricGetters[r.Metric](m)
+	right := r.Value
+	if r.RefMetric != "" {
+		right = metricGetters[r.RefMetric](m) * r.Factor
+	}
+
+	switch r.Op {
+	case ">":
+		return left > right
+	case ">=":
+		return left >= right
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	}
+	return false
+}
+
+// contribution описывает сработавшее правило для Diagnosis.FiredRules
+func (r Rule) contribution(m models.WorkloadMetrics) RuleContribution {
+	threshold := r.Value
+	if r.RefMetric != "" {
+		threshold = metricGetters[r.RefMetric](m) * r.Factor
+	}
+	return RuleContribution{
+		Rule:      r.Name,
+		Metric:    r.Metric,
+		Observed:  metricGetters[r.Metric](m),
+		Op:        r.Op,
+		Threshold: threshold,
+		Points:    r.Scores,
+	}
+}
+
+// defaultConfidence — калибровка для правил без секции confidence (файлы,
+// написанные до нее, задавали метку confidence в каждом профиле; это поле игнорируется)
+var defaultConfidence = ConfidenceSpec{Temperature: 1.5, High: 0.7, Medium: 0.45}
+
+// ParseRuleSet разбирает и валидирует правила из JSON
+func ParseRuleSet(data []byte) (*RuleSet, error) {
+	var rs RuleSet
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+	if rs.Confidence.Temperature == 0 {
+		rs.Confidence.Temperature = defaultConfidence.Temperature
+	}
+	if rs.Confidence.High == 0 && rs.Confidence.Medium == 0 {
+		rs.Confidence.High, rs.Confidence.Medium = defaultConfidence.High, defaultConfidence.Medium
+	}
+	if err := rs.Validate(); err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+// LoadRuleSet читает правила из файла
+func LoadRuleSet(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+	rs, err := ParseRuleSet(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rs, nil
+}
+
+// Validate проверяет, что правила ссылаются только на существующие метрики, профили и пресеты
+func (rs *RuleSet) Validate() error {
+	if len(rs.Profiles) == 0 {
+		return fmt.Errorf("rules: no profiles defined")
+	}
+	if err := validateProfile("idle", rs.Idle); err != nil {
+		return err
+	}
+	for key, p := range rs.Profiles {
+		if err := validateProfile(key, p); err != nil {
+			return err
+		}
+	}
+	if rs.Confidence.Temperature <= 0 {
+		return fmt.Errorf("rules: confidence.temperature must be positive")
+	}
+	if rs.Confidence.Medium < 0 || rs.Confidence.Medium > rs.Confidence.High || rs.Confidence.High > 1 {
+		return fmt.Errorf("rules: confidence thresholds must satisfy 0 <= medium <= high <= 1")
+	}
+	if _, ok := rs.Profiles[rs.Fallback.Profile]; !ok {
+		return fmt.Errorf("rules: fallback profile %q is not defined", rs.Fallback.Profile)
+	}
+	if rs.Fallback.MinScore <= 0 {
+		// Иначе при нулевых баллах у всех профилей победителя не будет
+		return fmt.Errorf("rules: fallback.min_score must be positive")
+	}
+
+	for i, r := range rs.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("rules: rule #%d has no name", i)
+		}
+		if _, ok := metricGetters[r.Metric]; !ok {
+			return fmt.Errorf("rules: rule %q: unknown metric %q", r.Name, r.Metric)
+		}
+		switch r.Op {
+		case ">", ">=", "<", "<=":
+		default:
+			return fmt.Errorf("rules: rule %q: unknown op %q", r.Name, r.Op)
+		}
+		if r.RefMetric != "" {
+			if _, ok := metricGetters[r.RefMetric]; !ok {
+				return fmt.Errorf("rules: rule %q: unknown ref_metric %q", r.Name, r.RefMetric)
+			}
+			if r.Factor <= 0 {
+				return fmt.Errorf("rules: rule %q: factor must be positive with ref_metric", r.Name)
+			}
+		}
+		if len(r.Scores) == 0 {
+			return fmt.Errorf("rules: rule %q: no scores", r.Name)
+		}
+		for profile := range r.Scores {
+			if _, ok := rs.Profiles[profile]; !ok {
+				return fmt.Errorf("rules: rule %q: unknown profile %q", r.Name, profile)
+			}
+		}
+	}
+	return nil
+}
+
+func validateProfile(key string, p ProfileSpec) error {
+	if p.Title == "" {
+		return fmt.Errorf("rules: profile %q has no title", key)
+	}
+	if p.Preset == "" && len(p.Settings) == 0 {
+		return fmt.Errorf("rules: profile %q needs either preset or settings", key)
+	}
+	if p.Preset != "" && configurator.GetSettingsForPreset(p.Preset) == nil {
+		return fmt.Errorf("rules: profile %q: unknown preset %q", key, p.Preset)
+	}
+	return nil
+}
+
+// profileKeys возвращает ключи профилей в стабильном порядке
+func (rs *RuleSet) profileKeys() []string {
+	keys := make([]string, 0, len(rs.Profiles))
+	for key := range rs.Profiles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
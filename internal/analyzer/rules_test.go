@@ -0,0 +1,150 @@
+package analyzer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+func TestRuleSetValidateFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		minScore float64
+		profile  string
+		wantErr  string
+	}{
+		{"default", 2, "MIXED", ""},
+		{"zero min score", 0, "MIXED", "min_score"},
+		{"negative min score", -1, "MIXED", "min_score"},
+		{"unknown profile", 2, "NOPE", "fallback profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ParseRuleSet(defaultRulesJSON)
+			if err != nil {
+				t.Fatalf("built-in rules: %v", err)
+			}
+			rs.Fallback = FallbackSpec{MinScore: tt.minScore, Profile: tt.profile}
+			err = rs.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClassifyFallback(t *testing.T) {
+	busy := models.WorkloadMetrics{DBTimeTotal: 100, TPS: 10}
+	rule := func(name string, scores map[string]float64) Rule {
+		return Rule{Name: name, Metric: "tps", Op: ">", Value: 1, Scores: scores}
+	}
+
+	tests := []struct {
+		name     string
+		rules    []Rule
+		minScore float64
+		want     string
+		fallback bool
+		runnerUp string // "" — не проверяется
+	}{
+		{"no rule fired", nil, 2, "MIXED", true, ""},
+		{"winner below min score", []Rule{rule("weak", map[string]float64{"OLTP": 1})}, 2, "MIXED", true, "OLTP"},
+		{"winner above min score", []Rule{rule("strong", map[string]float64{"OLTP": 3})}, 2, "OLTP", false, ""},
+		{"leader below min score over fallback", []Rule{rule("weak", map[string]float64{"OLAP": 1.5, "MIXED": 1})}, 2, "MIXED", true, "OLAP"},
+		// Правила в обход Validate: без победителя все равно выбирается fallback, а не пустой профиль
+		{"only negative scores", []Rule{rule("penalty", map[string]float64{"OLTP": -1})}, 0, "MIXED", true, ""},
+		{"all zero with zero min score", nil, 0, "MIXED", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ParseRuleSet(defaultRulesJSON)
+			if err != nil {
+				t.Fatalf("built-in rules: %v", err)
+			}
+			rs.Rules = tt.rules
+			rs.Fallback.MinScore = tt.minScore
+			d := (&RuleEngine{rules: rs}).Classify(busy)
+			if d.ProfileKey != tt.want {
+				t.Fatalf("profile = %q, want %q", d.ProfileKey, tt.want)
+			}
+			if d.Profile == "" {
+				t.Fatal("profile title is empty")
+			}
+			if d.Fallback != tt.fallback {
+				t.Fatalf("fallback = %v, want %v", d.Fallback, tt.fallback)
+			}
+			if tt.runnerUp != "" && d.RunnerUp != tt.runnerUp {
+				t.Fatalf("runner-up = %q, want the real top scorer %q", d.RunnerUp, tt.runnerUp)
+			}
+			if d.Margin < 0 {
+				t.Fatalf("margin = %v, must not be negative", d.Margin)
+			}
+			if d.Fallback && (d.Margin != 0 || d.ConfidenceScore != 0 || d.Confidence != "Low") {
+				t.Fatalf("fallback diagnosis: margin %v, confidence %v %s; want 0, 0 Low", d.Margin, d.ConfidenceScore, d.Confidence)
+			}
+		})
+	}
+}
+
+func TestParseRuleSetLegacyConfidence(t *testing.T) {
+	// Формат до секции confidence: метка уверенности в каждом профиле
+	legacy := []byte(`{
+		"idle_db_time": 1,
+		"idle": {"title": "IDLE", "settings": {"work_mem": "4MB"}},
+		"fallback": {"min_score": 2, "profile": "MIXED"},
+		"profiles": {
+			"OLTP": {"title": "OLTP", "confidence": "High", "settings": {"work_mem": "8MB"}},
+			"MIXED": {"title": "MIXED", "confidence": "Low", "settings": {"work_mem": "16MB"}}
+		},
+		"rules": [{"name": "tps", "metric": "tps", "op": ">", "value": 1, "scores": {"OLTP": 3}}]
+	}`)
+	rs, err := ParseRuleSet(legacy)
+	if err != nil {
+		t.Fatalf("legacy rules: %v", err)
+	}
+	if rs.Confidence != defaultConfidence {
+		t.Fatalf("confidence = %+v, want defaults %+v", rs.Confidence, defaultConfidence)
+	}
+
+	partial := []byte(strings.Replace(string(legacy), `"idle_db_time": 1,`, `"idle_db_time": 1, "confidence": {"temperature": 3},`, 1))
+	if rs, err = ParseRuleSet(partial); err != nil {
+		t.Fatalf("partial confidence: %v", err)
+	}
+	want := ConfidenceSpec{Temperature: 3, High: defaultConfidence.High, Medium: defaultConfidence.Medium}
+	if rs.Confidence != want {
+		t.Fatalf("confidence = %+v, want %+v", rs.Confidence, want)
+	}
+}
+
+func TestProfilesUsingPreset(t *testing.T) {
+	rs := &RuleSet{
+		Idle: ProfileSpec{Preset: "quiet"},
+		Profiles: map[string]ProfileSpec{
+			"OLTP": {Preset: "fast"},
+			"IOT":  {Preset: "fast"},
+			"OLAP": {Settings: map[string]string{"work_mem": "64MB"}},
+		},
+	}
+	tests := []struct {
+		preset string
+		want   []string
+	}{
+		{"fast", []string{"classifier profile IOT", "classifier profile OLTP"}},
+		{"quiet", []string{"classifier profile IDLE"}},
+		{"unused", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			got := (&RuleEngine{rules: rs}).ProfilesUsingPreset(tt.preset)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lypolix/pg_load_profile/internal/collector"
+	"github.com/lypolix/pg_load_profile/internal/configurator"
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// Разрезы, по которым можно диагностировать нагрузку
+const (
+	ScopeByDatabase = "database"
+	ScopeByRole     = "role"
+)
+
+// ScopeDiagnosis — диагноз одной базы или роли и настройки, которые можно задать
+// только для нее (ALTER DATABASE / ALTER ROLE ... SET). Серверные параметры пресета
+// (shared_buffers, max_wal_size...) в Recommended не попадают — они в Skipped.
+type ScopeDiagnosis struct {
+	Scope configurator.Scope `json:"scope"`
+	Diagnosis
+	Recommended map[string]string `json:"scoped_recommendations"`
+	Skipped     []string          `json:"skipped,omitempty"`
+}
+
+// DiagnoseScopes классифицирует нагрузку каждой базы (by = database) или роли
+// (by = role) за последние window. Считается только по буферу в памяти, поэтому
+// сразу после старта окно будет неполным (Partial). Простаивающие базы и роли пропускаются;
+// результат отсортирован по DB Time.
+func (c *Calculator) DiagnoseScopes(ctx context.Context, window time.Duration, by string) ([]ScopeDiagnosis, error) {
+	if by != ScopeByDatabase && by != ScopeByRole {
+		return nil, fmt.Errorf("unknown scope %q (use %s or %s)", by, ScopeByDatabase, ScopeByRole)
+	}
+
+	// Как и в CalculateMetrics, окно отсчитываем от последнего семпла (часы БД)
+	now := time.Now()
+	if last, ok := c.scopes.latest(); ok {
+		now = last.Timestamp
+	}
+	cutoff := now.Add(-window - SampleInterval/2)
+	samples := c.scopes.since(cutoff)
+
+	// Ряды семплов по каждой базе/роли
+	series := make(map[string][]collector.RawStats)
+	for _, s := range samples {
+		stats := s.Databases
+		if by == ScopeByRole {
+			stats = s.Roles
+		}
+		for name, raw := range stats {
+			series[name] = append(series[name], raw)
+		}
+	}
+
+	result := []ScopeDiagnosis{}
+	for name, scopeSamples := range series {
+		m := deltaMetrics(scopeSamples)
+		if m.DBTimeTotal <= 0 && m.TPS == 0 {
+			continue
+		}
+		if m.DBTimeTotal > 0 {
+			first, last := scopeSamples[0], scopeSamples[len(scopeSamples)-1]
+			if err := c.applyScopeASH(ctx, &m, first.Timestamp, last.Timestamp, by, name); err != nil {
+				return nil, err
+			}
+		}
+		if span(scopeSamples) < window-SampleInterval {
+			m.Partial = true
+		}
+
+		d := ScopeDiagnosis{Diagnosis: ClassifyWorkload(m), Recommended: map[string]string{}}
+		if by == ScopeByDatabase {
+			d.Scope.Database = name
+		} else {
+			d.Scope.Role = name
+		}
+		if len(d.Tuning) > 0 {
+			kept, skipped, err := configurator.ScopeSettings(c.pool, d.Tuning)
+			if err != nil {
+				return nil, err
+			}
+			d.Recommended, d.Skipped = kept, skipped
+		}
+		result = append(result, d)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Metrics.DBTimeTotal > result[j].Metrics.DBTimeTotal
+	})
+	return result, nil
+}
+
+// applyScopeASH — то же, что applyASH, но по сессиям одной базы или роли
+func (c *Calculator) applyScopeASH(ctx context.Context, m *models.WorkloadMetrics, from, to time.Time, by, name string) error {
+	column := "datname"
+	if by == ScopeByRole {
+		column = "usename"
+	}
+	query := fmt.Sprintf(`
+		SELECT
+			count(*),
+			count(*) FILTER (WHERE wait_event IS NULL),
+			count(*) FILTER (WHERE wait_event IN (
+				'DataFileRead', 'DataFileWrite', 'DataFileExtend', 'DataFileTruncate',
+				'WALWrite', 'WALSync'
+			) OR wait_event_type = 'IO'),
+			count(*) FILTER (WHERE wait_event_type IN ('Lock', 'LWLock'))
+		FROM profile_metrics.ash_samples
+		WHERE sample_time BETWEEN $1 AND $2 AND %s = $3
+	`, column)
+
+	var totalSamples, cpuSamples, ioSamples, lockSamples float64
+	if err := c.pool.QueryRow(ctx, query, from, to, name).Scan(&totalSamples, &cpuSamples, &ioSamples, &lockSamples); err != nil {
+		return fmt.Errorf("failed to get ash stats for %s %s: %w", by, name, err)
+	}
+	applyASHRatios(m, totalSamples, cpuSamples, ioSamples, lockSamples)
+	return nil
+}
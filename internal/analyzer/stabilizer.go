@@ -0,0 +1,106 @@
+package analyzer
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StabilizerConfig — условия, при которых новый профиль становится стабильным
+type StabilizerConfig struct {
+	// MinConsecutive — сколько окон подряд новый профиль должен побеждать
+	MinConsecutive int `json:"min_consecutive"`
+	// MinMargin — на сколько баллов новый профиль должен опережать текущий стабильный,
+	// чтобы переключиться сразу, не дожидаясь MinConsecutive (0 — отключено)
+	MinMargin float64 `json:"min_margin"`
+}
+
+// StabilizerConfigFromEnv читает STABLE_MIN_WINDOWS и STABLE_MIN_MARGIN
+func StabilizerConfigFromEnv() StabilizerConfig {
+	cfg := StabilizerConfig{MinConsecutive: 3}
+	if v, err := strconv.Atoi(os.Getenv("STABLE_MIN_WINDOWS")); err == nil && v > 0 {
+		cfg.MinConsecutive = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("STABLE_MIN_MARGIN"), 64); err == nil && v > 0 {
+		cfg.MinMargin = v
+	}
+	return cfg
+}
+
+// StableState — стабильный профиль и претендент на смену
+type StableState struct {
+	Profile         string           `json:"profile"`
+	ProfileKey      string           `json:"profile_key"`
+	Since           time.Time        `json:"since"`
+	Candidate       string           `json:"candidate,omitempty"` // ключ профиля, который пытается сменить стабильный
+	CandidateStreak int              `json:"candidate_streak"`    // сколько окон подряд он побеждает
+	Config          StabilizerConfig `json:"config"`
+
+	// Diagnosis — последний диагноз, в котором победил стабильный профиль
+	Diagnosis Diagnosis `json:"-"`
+}
+
+// Stabilizer — обертка над классификатором с гистерезисом: профиль не «прыгает»
+// между соседними классами, когда метрики стоят около порога.
+type Stabilizer struct {
+	mu    sync.Mutex
+	cfg   StabilizerConfig
+	state StableState
+}
+
+func NewStabilizer(cfg StabilizerConfig) *Stabilizer {
+	if cfg.MinConsecutive < 1 {
+		cfg.MinConsecutive = 1
+	}
+	return &Stabilizer{cfg: cfg, state: StableState{Config: cfg}}
+}
+
+// Update учитывает очередной мгновенный диагноз и возвращает стабильное состояние
+func (s *Stabilizer) Update(d Diagnosis, now time.Time) StableState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.state.ProfileKey == "":
+		// Первый диагноз — принимаем как есть
+		s.promote(d, now)
+	case d.ProfileKey == s.state.ProfileKey:
+		// Стабильный профиль подтвердился — претендент теряет серию
+		s.state.Diagnosis = d
+		s.state.Profile = d.Profile
+		s.state.Candidate = ""
+		s.state.CandidateStreak = 0
+	default:
+		if d.ProfileKey == s.state.Candidate {
+			s.state.CandidateStreak++
+		} else {
+			s.state.Candidate = d.ProfileKey
+			s.state.CandidateStreak = 1
+		}
+
+		margin := d.Scores[d.ProfileKey] - d.Scores[s.state.ProfileKey]
+		if s.state.CandidateStreak >= s.cfg.MinConsecutive || (s.cfg.MinMargin > 0 && margin >= s.cfg.MinMargin) {
+			s.promote(d, now)
+		}
+	}
+
+	return s.state
+}
+
+// State возвращает текущее стабильное состояние
+func (s *Stabilizer) State() StableState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *Stabilizer) promote(d Diagnosis, now time.Time) {
+	s.state = StableState{
+		Profile:    d.Profile,
+		ProfileKey: d.ProfileKey,
+		Since:      now,
+		Config:     s.cfg,
+		Diagnosis:  d,
+	}
+}
@@ -0,0 +1,98 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStabilizerUpdate(t *testing.T) {
+	diag := func(key string, scores map[string]float64) Diagnosis {
+		return Diagnosis{Profile: key + " profile", ProfileKey: key, Scores: scores}
+	}
+	oltp := diag("OLTP", map[string]float64{"OLTP": 5, "OLAP": 3})
+	olap := diag("OLAP", map[string]float64{"OLTP": 4, "OLAP": 5})    // отрыв 1
+	olapBig := diag("OLAP", map[string]float64{"OLTP": 1, "OLAP": 9}) // отрыв 8
+	iot := diag("IOT", map[string]float64{"OLTP": 4, "IOT": 5})
+
+	type step struct {
+		d         Diagnosis
+		stable    string
+		candidate string
+		streak    int
+	}
+	tests := []struct {
+		name  string
+		cfg   StabilizerConfig
+		steps []step
+	}{
+		{"first diagnosis is accepted", StabilizerConfig{MinConsecutive: 3}, []step{
+			{olap, "OLAP", "", 0},
+		}},
+		{"candidate needs MinConsecutive windows", StabilizerConfig{MinConsecutive: 3}, []step{
+			{oltp, "OLTP", "", 0},
+			{olap, "OLTP", "OLAP", 1},
+			{olap, "OLTP", "OLAP", 2},
+			{olap, "OLAP", "", 0},
+		}},
+		{"stable profile resets the streak", StabilizerConfig{MinConsecutive: 3}, []step{
+			{oltp, "OLTP", "", 0},
+			{olap, "OLTP", "OLAP", 1},
+			{olap, "OLTP", "OLAP", 2},
+			{oltp, "OLTP", "", 0},
+			{olap, "OLTP", "OLAP", 1},
+		}},
+		{"another candidate restarts the streak", StabilizerConfig{MinConsecutive: 2}, []step{
+			{oltp, "OLTP", "", 0},
+			{olap, "OLTP", "OLAP", 1},
+			{iot, "OLTP", "IOT", 1},
+			{iot, "IOT", "", 0},
+		}},
+		{"margin promotes at once", StabilizerConfig{MinConsecutive: 5, MinMargin: 6}, []step{
+			{oltp, "OLTP", "", 0},
+			{olap, "OLTP", "OLAP", 1},
+			{olapBig, "OLAP", "", 0},
+		}},
+		{"margin below threshold waits", StabilizerConfig{MinConsecutive: 5, MinMargin: 6}, []step{
+			{oltp, "OLTP", "", 0},
+			{olap, "OLTP", "OLAP", 1},
+			{olap, "OLTP", "OLAP", 2},
+		}},
+		{"zero margin is disabled", StabilizerConfig{MinConsecutive: 3}, []step{
+			{oltp, "OLTP", "", 0},
+			{olapBig, "OLTP", "OLAP", 1},
+		}},
+		{"MinConsecutive below 1 switches at once", StabilizerConfig{}, []step{
+			{oltp, "OLTP", "", 0},
+			{olap, "OLAP", "", 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStabilizer(tt.cfg)
+			start := time.Date(2025, 11, 27, 12, 0, 0, 0, time.UTC)
+			since := start
+			prevStable := ""
+			for i, st := range tt.steps {
+				now := start.Add(time.Duration(i) * 5 * time.Second)
+				got := s.Update(st.d, now)
+				if got.ProfileKey != st.stable || got.Candidate != st.candidate || got.CandidateStreak != st.streak {
+					t.Fatalf("step %d: stable %s, candidate %q x%d; want %s, %q x%d",
+						i, got.ProfileKey, got.Candidate, got.CandidateStreak, st.stable, st.candidate, st.streak)
+				}
+				if got.ProfileKey != prevStable {
+					since = now
+				}
+				if !got.Since.Equal(since) {
+					t.Fatalf("step %d: since = %s, want %s", i, got.Since, since)
+				}
+				if got.ProfileKey == st.d.ProfileKey && got.Diagnosis.ProfileKey != st.d.ProfileKey {
+					t.Fatalf("step %d: diagnosis of the stable profile was not kept", i)
+				}
+				prevStable = got.ProfileKey
+			}
+			if s.State().ProfileKey != prevStable {
+				t.Fatalf("State() = %s, want %s", s.State().ProfileKey, prevStable)
+			}
+		})
+	}
+}
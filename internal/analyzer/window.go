@@ -0,0 +1,121 @@
+package analyzer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+const (
+	// SampleInterval — как часто снимаются сырые счетчики в кольцевой буфер
+	SampleInterval = 5 * time.Second
+	// MaxWindow — самое длинное окно, которое можно посчитать из буфера
+	MaxWindow = time.Hour
+	// DiagnosisWindow — окно, по которому строится основной диагноз
+	DiagnosisWindow = 30 * time.Second
+
+	// snapshotInterval — период profile_metrics.take_snapshot() (см. collector.Start)
+	snapshotInterval = 10 * time.Second
+)
+
+// StatusWindows — окна, которые отдаются в /status рядом друг с другом,
+// чтобы отличать кратковременный всплеск от устойчивого тренда
+var StatusWindows = []time.Duration{
+	10 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	time.Hour,
+}
+
+// WindowLabel возвращает короткое имя окна: 10s, 1m, 5m, 1h
+func WindowLabel(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d >= time.Minute && d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
+
+// sampleRing — кольцевой буфер семплов. Старые семплы вытесняются новыми.
+type sampleRing[T any] struct {
+	mu    sync.RWMutex
+	buf   []T
+	start int
+	size  int
+	at    func(T) time.Time // время снятия семпла
+}
+
+func newSampleRing[T any](capacity int, at func(T) time.Time) *sampleRing[T] {
+	return &sampleRing[T]{buf: make([]T, capacity), at: at}
+}
+
+func (r *sampleRing[T]) push(s T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size < len(r.buf) {
+		r.buf[(r.start+r.size)%len(r.buf)] = s
+		r.size++
+		return
+	}
+	// Буфер заполнен — затираем самый старый семпл
+	r.buf[r.start] = s
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// since возвращает (в хронологическом порядке) все семплы, снятые не раньше cutoff
+func (r *sampleRing[T]) since(cutoff time.Time) []T {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var out []T
+	for i := 0; i < r.size; i++ {
+		s := r.buf[(r.start+i)%len(r.buf)]
+		if !r.at(s).Before(cutoff) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// latest возвращает самый свежий семпл; ok = false, если буфер пуст
+func (r *sampleRing[T]) latest() (s T, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.size == 0 {
+		return s, false
+	}
+	return r.buf[(r.start+r.size-1)%len(r.buf)], true
+}
+
+// between возвращает семплы, снятые в [from, to], от старых к новым
+func (r *sampleRing[T]) between(from, to time.Time) []T {
+	var out []T
+	for _, s := range r.since(from) {
+		if !r.at(s).After(to) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// WindowSummary — метрики и профиль, определенный по одному окну
+type WindowSummary struct {
+	Metrics models.WorkloadMetrics `json:"metrics"`
+	Profile string                 `json:"profile"`
+}
+
+// SummarizeWindows классифицирует каждое окно отдельно
+func SummarizeWindows(windows map[string]models.WorkloadMetrics) map[string]WindowSummary {
+	result := make(map[string]WindowSummary, len(windows))
+	for label, m := range windows {
+		result[label] = WindowSummary{Metrics: m, Profile: ClassifyWorkload(m).Profile}
+	}
+	return result
+}
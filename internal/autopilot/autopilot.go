@@ -0,0 +1,487 @@
+package autopilot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/lypolix/pg_load_profile/internal/analyzer"
+	"github.com/lypolix/pg_load_profile/internal/configurator"
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// Действия, которые попадают в журнал решений
+const (
+	ActionPropose = "propose" // профиль сменился, но cooldown еще не прошел
+	ActionApply   = "apply"   // пресет применен, идет сравнение
+	ActionKeep    = "keep"    // сравнение прошло, пресет оставлен
+	ActionRevert  = "revert"  // нагрузка деградировала, настройки откатены
+	// за окно сравнения сменились профиль или нагрузка: сравнивать нечего, пресет остается
+	ActionInconclusive = "inconclusive"
+	ActionPause        = "pause"
+	ActionResume       = "resume"
+	ActionError        = "error"
+)
+
+const maxDecisions = 200
+
+// Config — настройки автопилота
+type Config struct {
+	Cooldown           time.Duration `json:"cooldown"`             // минимум между двумя изменениями конфигурации
+	CompareWindow      time.Duration `json:"compare_window"`       // окно до/после для сравнения latency и TPS
+	MaxLatencyIncrease float64       `json:"max_latency_increase"` // % роста средней задержки, после которого откатываемся
+	MaxTPSDrop         float64       `json:"max_tps_drop"`         // % падения TPS, после которого откатываемся
+	CheckInterval      time.Duration `json:"check_interval"`
+}
+
+// ConfigFromEnv читает AUTOPILOT_* переменные окружения
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Cooldown:           10 * time.Minute,
+		CompareWindow:      2 * time.Minute,
+		MaxLatencyIncrease: 20,
+		MaxTPSDrop:         15,
+		CheckInterval:      15 * time.Second,
+	}
+	if v, err := time.ParseDuration(os.Getenv("AUTOPILOT_COOLDOWN")); err == nil && v > 0 {
+		cfg.Cooldown = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("AUTOPILOT_COMPARE_WINDOW")); err == nil && v > 0 {
+		cfg.CompareWindow = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("AUTOPILOT_MAX_LATENCY_INCREASE"), 64); err == nil && v > 0 {
+		cfg.MaxLatencyIncrease = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("AUTOPILOT_MAX_TPS_DROP"), 64); err == nil && v > 0 {
+		cfg.MaxTPSDrop = v
+	}
+	return cfg
+}
+
+// Decision — запись журнала: что автопилот сделал и на основании чего
+type Decision struct {
+	Time     time.Time               `json:"time"`
+	Action   string                  `json:"action"`
+	Profile  string                  `json:"profile,omitempty"`
+	Preset   string                  `json:"preset,omitempty"`
+	Reason   string                  `json:"reason"`
+	Before   *models.WorkloadMetrics `json:"before,omitempty"`
+	After    *models.WorkloadMetrics `json:"after,omitempty"`
+	Settings map[string]string       `json:"settings,omitempty"`
+}
+
+// trial — примененный пресет, который еще проходит сравнение до/после
+type trial struct {
+	Preset    string                 `json:"preset"`
+	ChangeID  int64                  `json:"change_id"` // версия в profile_metrics.config_changes
+	AppliedAt time.Time              `json:"applied_at"`
+	Profile   string                 `json:"profile"`            // стабильный профиль, под который применен пресет
+	Workload  string                 `json:"workload,omitempty"` // нагрузка в момент применения (см. New)
+	Before    models.WorkloadMetrics `json:"-"`
+	Previous  map[string]string      `json:"previous"`
+}
+
+// Status — состояние автопилота для API
+type Status struct {
+	Enabled      bool       `json:"enabled"`
+	ActivePreset string     `json:"active_preset"`
+	LastChange   time.Time  `json:"last_change"`
+	Evaluating   *trial     `json:"evaluating,omitempty"`
+	Config       Config     `json:"config"`
+	Decisions    []Decision `json:"decisions"`
+}
+
+// Autopilot — замкнутый контур тюнинга: следит за стабильным диагнозом, применяет
+// соответствующий пресет после cooldown, сравнивает latency/TPS до и после и
+// откатывается, если нагрузка деградировала. По умолчанию выключен.
+type Autopilot struct {
+	mu   sync.Mutex
+	cfg  Config
+	pool *pgxpool.Pool
+	calc *analyzer.Calculator
+
+	stable   func() analyzer.StableState // источник стабильного диагноза
+	workload func() string               // идентификатор текущей нагрузки (прогон и фаза); пусто — не размечена
+	onApply  func(preset string)         // уведомление о смене активного конфига
+
+	enabled      bool
+	activePreset string
+	lastChange   time.Time
+	lastProposal string
+	pending      *trial
+	decisions    []Decision
+	epoch        uint64 // растет при паузе, возобновлении и ручной смене пресета
+}
+
+// New создает автопилот (выключенным). workload нужен, чтобы не сравнивать
+// до/после через смену нагрузки: если он вернул другое значение к концу окна,
+// сравнение не проводится.
+func New(cfg Config, pool *pgxpool.Pool, calc *analyzer.Calculator, stable func() analyzer.StableState, workload func() string, onApply func(preset string)) *Autopilot {
+	return &Autopilot{
+		cfg:      cfg,
+		pool:     pool,
+		calc:     calc,
+		stable:   stable,
+		workload: workload,
+		onApply:  onApply,
+	}
+}
+
+// Start запускает фоновый цикл. Пока автопилот на паузе, цикл ничего не делает.
+func (a *Autopilot) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(a.cfg.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.tick(ctx, time.Now())
+			}
+		}
+	}()
+}
+
+// Pause останавливает автопилот. Незавершенное сравнение отменяется, примененный пресет остается.
+func (a *Autopilot) Pause(reason string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.enabled = false
+	a.pending = nil
+	a.epoch++
+	a.record(Decision{Action: ActionPause, Reason: reason})
+}
+
+// Resume включает автопилот
+func (a *Autopilot) Resume(reason string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.enabled = true
+	a.lastProposal = ""
+	a.epoch++
+	a.record(Decision{Action: ActionResume, Reason: reason})
+}
+
+// Suspend ставит включенный автопилот на паузу и возвращает функцию, которая
+// включит его обратно. Если автопилот уже был выключен, возвращенная функция
+// ничего не делает; если его за это время включили вручную — тоже.
+func (a *Autopilot) Suspend(reason string) (resume func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.enabled {
+		return func() {}
+	}
+	a.enabled = false
+	a.pending = nil
+	a.epoch++
+	a.record(Decision{Action: ActionPause, Reason: reason})
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.enabled {
+			return
+		}
+		a.enabled = true
+		a.lastProposal = ""
+		a.epoch++
+		a.record(Decision{Action: ActionResume, Reason: reason + ": finished"})
+	}
+}
+
+// SetActivePreset сообщает автопилоту о пресете, примененном вручную, и сбрасывает cooldown
+func (a *Autopilot) SetActivePreset(preset string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.activePreset = preset
+	a.lastChange = time.Now()
+	a.epoch++
+}
+
+// Status возвращает состояние и журнал решений (новые в конце)
+func (a *Autopilot) Status() Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	decisions := make([]Decision, len(a.decisions))
+	copy(decisions, a.decisions)
+	return Status{
+		Enabled:      a.enabled,
+		ActivePreset: a.activePreset,
+		LastChange:   a.lastChange,
+		Evaluating:   a.pending,
+		Config:       a.cfg,
+		Decisions:    decisions,
+	}
+}
+
+func (a *Autopilot) tick(ctx context.Context, now time.Time) {
+	t, stable, epoch := a.next(now)
+	switch {
+	case t != nil:
+		a.evaluate(ctx, now, t, epoch)
+	case stable != nil:
+		a.apply(ctx, now, *stable, epoch)
+	}
+}
+
+// next решает под a.mu, что делать на этой проверке: завершить сравнение (trial)
+// или применить пресет стабильного диагноза. Сами действия ходят в базу и идут
+// без блокировки; по epoch они замечают, что состояние за это время поменялось.
+func (a *Autopilot) next(now time.Time) (*trial, *analyzer.StableState, uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.enabled {
+		return nil, nil, 0
+	}
+
+	// 1. Идет сравнение — ждем конца окна и решаем, оставить или откатить
+	if a.pending != nil {
+		if now.Sub(a.pending.AppliedAt) >= a.cfg.CompareWindow {
+			return a.pending, nil, a.epoch
+		}
+		return nil, nil, 0
+	}
+
+	// 2. Ищем, не пора ли сменить пресет
+	stable := a.stable()
+	preset := stable.Diagnosis.Preset
+	if stable.ProfileKey == "" || preset == "" || preset == a.activePreset {
+		return nil, nil, 0
+	}
+
+	if wait := a.cfg.Cooldown - now.Sub(a.lastChange); wait > 0 {
+		if a.lastProposal != preset {
+			a.lastProposal = preset
+			a.record(Decision{
+				Action:  ActionPropose,
+				Profile: stable.Profile,
+				Preset:  preset,
+				Reason:  fmt.Sprintf("stable profile since %s; cooldown, %s left", stable.Since.Format(time.RFC3339), wait.Round(time.Second)),
+			})
+		}
+		return nil, nil, 0
+	}
+	return nil, &stable, a.epoch
+}
+
+// apply применяет пресет стабильного диагноза и начинает сравнение до/после.
+// Вызывается без a.mu: замер и применение ходят в базу.
+func (a *Autopilot) apply(ctx context.Context, now time.Time, stable analyzer.StableState, epoch uint64) {
+	preset := stable.Diagnosis.Preset
+	settings := configurator.GetSettingsForPreset(preset)
+	if settings == nil {
+		a.recordLocked(Decision{Action: ActionError, Preset: preset, Reason: "unknown preset"})
+		return
+	}
+
+	// Базовая линия: метрики за окно сравнения перед изменением
+	before, err := a.calc.CalculateMetrics(ctx, a.cfg.CompareWindow)
+	if err != nil {
+		a.recordLocked(Decision{Action: ActionError, Preset: preset, Reason: fmt.Sprintf("failed to measure baseline: %v", err)})
+		return
+	}
+	// Пока шел замер, автопилот могли поставить на паузу или сменить пресет вручную
+	a.mu.Lock()
+	stale := a.epoch != epoch
+	a.mu.Unlock()
+	if stale {
+		return
+	}
+
+	workload := a.currentWorkload()
+	reason := fmt.Sprintf("stable profile %s (confidence %.0f%%): %s", stable.Profile, stable.Diagnosis.ConfidenceScore*100, stable.Diagnosis.Reasoning)
+	change, err := configurator.ApplyPreset(a.pool, preset, configurator.ChangeMeta{
+		Actor:  "autopilot",
+		Reason: reason,
+		Source: configurator.SourceAutopilot,
+	})
+
+	a.mu.Lock()
+	if err != nil {
+		a.record(Decision{Action: ActionError, Preset: preset, Reason: fmt.Sprintf("failed to apply preset: %v", err)})
+		a.lastChange = now // не долбим базу каждую проверку
+		a.mu.Unlock()
+		return
+	}
+
+	a.lastChange = now
+	a.lastProposal = ""
+	if pending := change.PendingRestart(); len(pending) > 0 {
+		// Сравнение до/после покажет эффект только параметров, примененных без рестарта
+		reason += fmt.Sprintf(" | pending restart: %v", pending)
+	}
+	stale = a.epoch != epoch
+	if stale {
+		// Изменение уже в базе, но состояние автопилота сменилось, пока оно применялось:
+		// сравнивать не с чем, активный пресет остается тем, что задали снаружи
+		reason += " | autopilot state changed while applying, comparison skipped"
+	} else {
+		// Прежние значения сохранены в версии изменения — по ним и откатимся
+		a.pending = &trial{
+			Preset:    preset,
+			ChangeID:  change.ID,
+			AppliedAt: now,
+			Profile:   stable.ProfileKey,
+			Workload:  workload,
+			Before:    before,
+			Previous:  change.Previous,
+		}
+		a.activePreset = preset
+	}
+	a.record(Decision{
+		Action:   ActionApply,
+		Profile:  stable.Profile,
+		Preset:   preset,
+		Reason:   reason,
+		Before:   &before,
+		Settings: settings,
+	})
+	a.mu.Unlock()
+
+	if !stale && a.onApply != nil {
+		a.onApply(preset)
+	}
+}
+
+// evaluate завершает сравнение t: оставляет пресет или откатывает его.
+// Вызывается без a.mu: замер и откат ходят в базу.
+func (a *Autopilot) evaluate(ctx context.Context, now time.Time, t *trial, epoch uint64) {
+	after, err := a.calc.CalculateMetrics(ctx, a.cfg.CompareWindow)
+
+	a.mu.Lock()
+	if a.epoch != epoch || a.pending != t {
+		// Пока шел замер, сравнение отменили (пауза или ручное применение)
+		a.mu.Unlock()
+		return
+	}
+	a.pending = nil
+	if err != nil {
+		a.record(Decision{Action: ActionError, Preset: t.Preset, Reason: fmt.Sprintf("failed to measure result: %v", err)})
+		a.mu.Unlock()
+		return
+	}
+
+	// Латентность и TPS другой нагрузки или другого профиля с базовой линией не сравнить:
+	// падение TPS после конца прогона — не повод откатывать пресет
+	stable := a.stable()
+	if changed := a.trialChanged(t, stable.ProfileKey, a.currentWorkload()); changed != "" {
+		a.record(Decision{
+			Action: ActionInconclusive,
+			Preset: t.Preset,
+			Reason: changed + " during the compare window, preset kept without comparison",
+			Before: &t.Before,
+			After:  &after,
+		})
+		a.mu.Unlock()
+		return
+	}
+
+	regression := a.regression(t.Before, after)
+	if regression == "" {
+		a.record(Decision{
+			Action: ActionKeep,
+			Preset: t.Preset,
+			Reason: fmt.Sprintf("no regression: latency %.2f -> %.2f ms, TPS %.1f -> %.1f", t.Before.AvgLatency, after.AvgLatency, t.Before.TPS, after.TPS),
+			Before: &t.Before,
+			After:  &after,
+		})
+		a.mu.Unlock()
+		return
+	}
+	a.mu.Unlock()
+
+	_, err = configurator.ApplyCustomConfig(a.pool, t.Previous, configurator.ChangeMeta{
+		Actor:  "autopilot",
+		Reason: fmt.Sprintf("revert change #%d: %s", t.ChangeID, regression),
+		Source: configurator.SourceAutopilot,
+	})
+
+	a.mu.Lock()
+	if err != nil {
+		a.record(Decision{Action: ActionError, Preset: t.Preset, Reason: fmt.Sprintf("%s; revert failed: %v", regression, err), Before: &t.Before, After: &after})
+		a.mu.Unlock()
+		return
+	}
+
+	a.lastChange = now
+	// Если пресет за время отката сменили вручную, активным остается он
+	stale := a.epoch != epoch
+	if !stale {
+		a.activePreset = ""
+	}
+	a.record(Decision{
+		Action:   ActionRevert,
+		Preset:   t.Preset,
+		Reason:   regression,
+		Before:   &t.Before,
+		After:    &after,
+		Settings: t.Previous,
+	})
+	a.mu.Unlock()
+
+	if !stale && a.onApply != nil {
+		a.onApply("")
+	}
+}
+
+// trialChanged описывает, чем условия в конце окна отличаются от условий применения
+// (пустая строка — ничего не поменялось)
+func (a *Autopilot) trialChanged(t *trial, profile, workload string) string {
+	switch {
+	case profile != t.Profile:
+		return fmt.Sprintf("stable profile changed from %s to %s", t.Profile, profile)
+	case workload != t.Workload:
+		return fmt.Sprintf("workload changed from %q to %q", t.Workload, workload)
+	}
+	return ""
+}
+
+// currentWorkload — идентификатор текущей нагрузки (пусто, если источник не задан)
+func (a *Autopilot) currentWorkload() string {
+	if a.workload == nil {
+		return ""
+	}
+	return a.workload()
+}
+
+// regression возвращает описание деградации или пустую строку, если ее нет
+func (a *Autopilot) regression(before, after models.WorkloadMetrics) string {
+	if before.AvgLatency > 0 {
+		increase := (after.AvgLatency - before.AvgLatency) / before.AvgLatency * 100
+		if increase > a.cfg.MaxLatencyIncrease {
+			return fmt.Sprintf("latency regressed by %.0f%% (%.2f -> %.2f ms, limit %.0f%%)", increase, before.AvgLatency, after.AvgLatency, a.cfg.MaxLatencyIncrease)
+		}
+	}
+	if before.TPS > 0 {
+		drop := (before.TPS - after.TPS) / before.TPS * 100
+		if drop > a.cfg.MaxTPSDrop {
+			return fmt.Sprintf("TPS dropped by %.0f%% (%.1f -> %.1f, limit %.0f%%)", drop, before.TPS, after.TPS, a.cfg.MaxTPSDrop)
+		}
+	}
+	return ""
+}
+
+// recordLocked — record с захватом a.mu
+func (a *Autopilot) recordLocked(d Decision) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.record(d)
+}
+
+// record добавляет решение в журнал (вызывать под a.mu)
+func (a *Autopilot) record(d Decision) {
+	d.Time = time.Now()
+	a.decisions = append(a.decisions, d)
+	if len(a.decisions) > maxDecisions {
+		a.decisions = a.decisions[len(a.decisions)-maxDecisions:]
+	}
+	log.Printf("[Autopilot] %s preset=%s: %s", d.Action, d.Preset, d.Reason)
+}
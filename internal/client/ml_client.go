@@ -77,9 +77,9 @@ func NewMLClient() *MLClient {
 	}
 }
 
-// Predict отправляет метрики для получения предсказания.
-func (c *MLClient) Predict(ctx context.Context, metrics models.WorkloadMetrics, activeConfig string) (*MLPredictionResponse, error) {
-	mlMetrics := MLMetrics{
+// NewMLMetrics переводит метрики анализатора в признаки модели.
+func NewMLMetrics(metrics models.WorkloadMetrics, activeConfig string) MLMetrics {
+	return MLMetrics{
 		DBTimeTotal:       metrics.DBTimeTotal,
 		DBTimeCommitted:   metrics.DBTimeCommitted,
 		CPUTime:           metrics.CPUTime,
@@ -97,8 +97,11 @@ func (c *MLClient) Predict(ctx context.Context, metrics models.WorkloadMetrics,
 		TotalCalls:        metrics.TotalCalls,
 		ActiveConfig:      activeConfig,
 	}
+}
 
-	reqPayload := MLPredictionRequest{Metrics: mlMetrics}
+// Predict отправляет метрики для получения предсказания.
+func (c *MLClient) Predict(ctx context.Context, metrics models.WorkloadMetrics, activeConfig string) (*MLPredictionResponse, error) {
+	reqPayload := MLPredictionRequest{Metrics: NewMLMetrics(metrics, activeConfig)}
 	payloadBytes, err := json.Marshal(reqPayload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal prediction request: %w", err)
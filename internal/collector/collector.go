@@ -45,25 +45,51 @@ type RawStats struct {
 	// Из pg_stat_database
 	XactCommit    int64     `json:"xact_commit"`
 	XactRollback  int64     `json:"xact_rollback"`
+	BlksRead      int64     `json:"blks_read"`
+	BlksHit       int64     `json:"blks_hit"`
+	TupReturned   int64     `json:"tup_returned"`
+	TupFetched    int64     `json:"tup_fetched"`
+	TupInserted   int64     `json:"tup_inserted"`
+	TupUpdated    int64     `json:"tup_updated"`
+	TupDeleted    int64     `json:"tup_deleted"`
+
+	// Из pg_stat_bgwriter
+	BuffersCheckpoint int64 `json:"buffers_checkpoint"`
+	BuffersClean      int64 `json:"buffers_clean"`
+	BuffersBackend    int64 `json:"buffers_backend"`
 	
 	// Из pg_stat_statements (может быть 0, если расширения нет)
 	TotalCalls    int64     `json:"total_calls"`
 	TotalExecTime float64   `json:"total_exec_time"`
+
+	// Отметки сбросов: по ним калькулятор понимает, что счетчики обнулились
+	PostmasterStart   time.Time  `json:"postmaster_start"`
+	StatsReset        *time.Time `json:"stats_reset"`            // pg_stat_database.stats_reset (NULL, если не сбрасывали)
+	BgwriterReset     *time.Time `json:"bgwriter_reset"`         // pg_stat_bgwriter.stats_reset
+	StatementsReset   *time.Time `json:"statements_reset"`       // pg_stat_statements_info.stats_reset
+	StatementsDealloc int64      `json:"statements_dealloc"`     // pg_stat_statements_info.dealloc (вытеснения)
 }
 
-// GetRawStats собирает счетчики для вычисления дельт
+// GetRawStats собирает счетчики для вычисления дельт.
+// Время семпла берется с часов БД, чтобы совпадать с ASH и snapshots.
 func GetRawStats(pool *pgxpool.Pool) (*RawStats, error) {
 	ctx := context.Background()
-	stats := &RawStats{
-		Timestamp: time.Now(),
-	}
+	stats := &RawStats{}
 
-	// 1. Транзакции (Commit / Rollback)
+	// 1. Транзакции, блоки и строки из pg_stat_database + буферы из pg_stat_bgwriter
 	err := pool.QueryRow(ctx, `
-		SELECT xact_commit, xact_rollback 
-		FROM pg_stat_database 
-		WHERE datname = current_database()
-	`).Scan(&stats.XactCommit, &stats.XactRollback)
+		SELECT clock_timestamp(),
+			d.xact_commit, d.xact_rollback, d.blks_read, d.blks_hit,
+			d.tup_returned, d.tup_fetched, d.tup_inserted, d.tup_updated, d.tup_deleted,
+			b.buffers_checkpoint, b.buffers_clean, b.buffers_backend,
+			pg_postmaster_start_time(), d.stats_reset, b.stats_reset
+		FROM pg_stat_database d, pg_stat_bgwriter b
+		WHERE d.datname = current_database()
+	`).Scan(&stats.Timestamp,
+		&stats.XactCommit, &stats.XactRollback, &stats.BlksRead, &stats.BlksHit,
+		&stats.TupReturned, &stats.TupFetched, &stats.TupInserted, &stats.TupUpdated, &stats.TupDeleted,
+		&stats.BuffersCheckpoint, &stats.BuffersClean, &stats.BuffersBackend,
+		&stats.PostmasterStart, &stats.StatsReset, &stats.BgwriterReset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch pg_stat_database: %w", err)
 	}
@@ -85,5 +111,64 @@ func GetRawStats(pool *pgxpool.Pool) (*RawStats, error) {
 		stats.TotalExecTime = 0
 	}
 
+	// 3. Сбросы и вытеснения pg_stat_statements (PG14+). Без них дельты
+	// по statements могут уйти в минус после pg_stat_statements_reset()
+	err = pool.QueryRow(ctx, `
+		SELECT stats_reset, dealloc FROM pg_stat_statements_info
+	`).Scan(&stats.StatementsReset, &stats.StatementsDealloc)
+	if err != nil {
+		stats.StatementsReset = nil
+		stats.StatementsDealloc = 0
+	}
+
 	return stats, nil
 }
+
+// GetSnapshots читает сохраненные profile_metrics.take_snapshot() строки за период
+// в хронологическом порядке. Позволяет пересчитать метрики задним числом.
+func GetSnapshots(ctx context.Context, pool *pgxpool.Pool, from, to time.Time) ([]RawStats, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT snapshot_time,
+			COALESCE(xact_commit, 0), COALESCE(xact_rollback, 0),
+			COALESCE(blks_read, 0), COALESCE(blks_hit, 0),
+			COALESCE(tup_returned, 0), COALESCE(tup_fetched, 0),
+			COALESCE(tup_inserted, 0), COALESCE(tup_updated, 0), COALESCE(tup_deleted, 0),
+			COALESCE(buffers_checkpoint, 0), COALESCE(buffers_clean, 0), COALESCE(buffers_backend, 0),
+			COALESCE(total_calls, 0), COALESCE(total_exec_time, 0),
+			postmaster_start, stats_reset, bgwriter_reset,
+			statements_reset, COALESCE(statements_dealloc, 0)
+		FROM profile_metrics.snapshots
+		WHERE snapshot_time BETWEEN $1 AND $2
+		ORDER BY snapshot_time
+	`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var snapshots []RawStats
+	for rows.Next() {
+		var s RawStats
+		var postmasterStart *time.Time // NULL у строк, снятых до появления колонки
+		if err := rows.Scan(&s.Timestamp,
+			&s.XactCommit, &s.XactRollback,
+			&s.BlksRead, &s.BlksHit,
+			&s.TupReturned, &s.TupFetched,
+			&s.TupInserted, &s.TupUpdated, &s.TupDeleted,
+			&s.BuffersCheckpoint, &s.BuffersClean, &s.BuffersBackend,
+			&s.TotalCalls, &s.TotalExecTime,
+			&postmasterStart, &s.StatsReset, &s.BgwriterReset,
+			&s.StatementsReset, &s.StatementsDealloc); err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot: %w", err)
+		}
+		if postmasterStart != nil {
+			s.PostmasterStart = *postmasterStart
+		}
+		snapshots = append(snapshots, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read snapshots: %w", err)
+	}
+
+	return snapshots, nil
+}
@@ -0,0 +1,111 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ScopeStats — счетчики в разрезе баз и ролей, снятые в один момент.
+// Для каждой базы/роли заполняется RawStats, чтобы дельты и сбросы
+// считались так же, как для всего сервера:
+//   - база: транзакции, блоки и строки из pg_stat_database + запросы из pg_stat_statements;
+//   - роль: только запросы и блоки из pg_stat_statements (транзакций по ролям PostgreSQL не считает).
+type ScopeStats struct {
+	Timestamp time.Time
+	Databases map[string]RawStats
+	Roles     map[string]RawStats
+}
+
+// GetScopeStats собирает счетчики по всем базам и ролям
+func GetScopeStats(pool *pgxpool.Pool) (*ScopeStats, error) {
+	ctx := context.Background()
+	stats := &ScopeStats{
+		Databases: make(map[string]RawStats),
+		Roles:     make(map[string]RawStats),
+	}
+
+	// Общие для всех отметки: время семпла, рестарт, сброс pg_stat_statements
+	var base RawStats
+	if err := pool.QueryRow(ctx, `SELECT clock_timestamp(), pg_postmaster_start_time()`).
+		Scan(&base.Timestamp, &base.PostmasterStart); err != nil {
+		return nil, fmt.Errorf("failed to fetch sample time: %w", err)
+	}
+	if err := pool.QueryRow(ctx, `SELECT stats_reset, dealloc FROM pg_stat_statements_info`).
+		Scan(&base.StatementsReset, &base.StatementsDealloc); err != nil {
+		base.StatementsReset, base.StatementsDealloc = nil, 0
+	}
+	stats.Timestamp = base.Timestamp
+
+	// 1. Базы из pg_stat_database
+	rows, err := pool.Query(ctx, `
+		SELECT datname, xact_commit, xact_rollback, blks_read, blks_hit,
+			tup_returned, tup_fetched, tup_inserted, tup_updated, tup_deleted, stats_reset
+		FROM pg_stat_database
+		WHERE datname IS NOT NULL AND datname NOT IN ('template0', 'template1')
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pg_stat_database: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		s := base
+		if err := rows.Scan(&name, &s.XactCommit, &s.XactRollback, &s.BlksRead, &s.BlksHit,
+			&s.TupReturned, &s.TupFetched, &s.TupInserted, &s.TupUpdated, &s.TupDeleted, &s.StatsReset); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan pg_stat_database: %w", err)
+		}
+		stats.Databases[name] = s
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pg_stat_database: %w", err)
+	}
+
+	// 2. Запросы по базам и ролям из pg_stat_statements (если расширения нет — только базы)
+	rows, err = pool.Query(ctx, `
+		SELECT d.datname, r.rolname,
+			sum(s.calls)::bigint, sum(s.total_exec_time)::float8,
+			sum(s.shared_blks_read)::bigint, sum(s.shared_blks_hit)::bigint
+		FROM pg_stat_statements s
+		JOIN pg_database d ON d.oid = s.dbid
+		JOIN pg_roles r ON r.oid = s.userid
+		GROUP BY d.datname, r.rolname
+	`)
+	if err != nil {
+		return stats, nil
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var datname, rolname string
+		var calls, blksRead, blksHit int64
+		var execTime float64
+		if err := rows.Scan(&datname, &rolname, &calls, &execTime, &blksRead, &blksHit); err != nil {
+			return nil, fmt.Errorf("failed to scan pg_stat_statements: %w", err)
+		}
+
+		if db, ok := stats.Databases[datname]; ok {
+			db.TotalCalls += calls
+			db.TotalExecTime += execTime
+			stats.Databases[datname] = db
+		}
+
+		role, ok := stats.Roles[rolname]
+		if !ok {
+			role = base
+		}
+		role.TotalCalls += calls
+		role.TotalExecTime += execTime
+		role.BlksRead += blksRead
+		role.BlksHit += blksHit
+		stats.Roles[rolname] = role
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pg_stat_statements: %w", err)
+	}
+
+	return stats, nil
+}
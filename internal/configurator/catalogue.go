@@ -0,0 +1,152 @@
+package configurator
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// parametersJSON — каталог параметров, которые можно менять через API
+//
+//go:embed parameters.json
+var parametersJSON []byte
+
+// Category — группа параметров (так они показываются в панели настроек)
+type Category struct {
+	Key   string `json:"key"`
+	Title string `json:"title"`
+}
+
+// Parameter — описание настраиваемого параметра. Min/Max — безопасный диапазон
+// (строже, чем min_val/max_val в pg_settings), в тех же единицах, что и значения.
+type Parameter struct {
+	Name        string   `json:"name"`
+	Category    string   `json:"category"`
+	Description string   `json:"description"`
+	Min         string   `json:"min,omitempty"`
+	Max         string   `json:"max,omitempty"`
+	Restart     bool     `json:"restart"`
+	Profiles    []string `json:"profiles"` // пресеты, для которых параметр важен
+}
+
+// Catalogue — все настраиваемые параметры по категориям
+type Catalogue struct {
+	Categories []Category  `json:"categories"`
+	Parameters []Parameter `json:"parameters"`
+}
+
+var (
+	catalogue      = mustLoadCatalogue()
+	catalogueIndex = indexCatalogue(catalogue)
+)
+
+func mustLoadCatalogue() Catalogue {
+	var c Catalogue
+	if err := json.Unmarshal(parametersJSON, &c); err != nil {
+		panic(fmt.Sprintf("invalid parameter catalogue: %v", err))
+	}
+
+	categories := make(map[string]bool, len(c.Categories))
+	for _, cat := range c.Categories {
+		categories[cat.Key] = true
+	}
+	known := make(map[string]bool, len(c.Parameters))
+	for _, p := range c.Parameters {
+		if !categories[p.Category] {
+			panic(fmt.Sprintf("parameter catalogue: %s has unknown category %q", p.Name, p.Category))
+		}
+		known[p.Name] = true
+	}
+	// Встроенные пресеты могут ставить только параметры из каталога
+	for _, b := range builtinPresets {
+		for key := range SizePreset(b.name, DefaultResources()) {
+			if !known[key] {
+				panic(fmt.Sprintf("parameter catalogue: preset %s uses %s, which is not in the catalogue", b.name, key))
+			}
+		}
+	}
+	return c
+}
+
+func indexCatalogue(c Catalogue) map[string]Parameter {
+	index := make(map[string]Parameter, len(c.Parameters))
+	for _, p := range c.Parameters {
+		index[p.Name] = p
+	}
+	return index
+}
+
+// GetCatalogue возвращает каталог параметров
+func GetCatalogue() Catalogue {
+	return catalogue
+}
+
+// catalogueNames — имена всех параметров каталога в порядке описания
+func catalogueNames() []string {
+	names := make([]string, len(catalogue.Parameters))
+	for i, p := range catalogue.Parameters {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// checkSafeRange проверяет значение по безопасному диапазону из каталога
+func (p Parameter) checkSafeRange(s pgSetting, value string) error {
+	if s.vartype != "integer" && s.vartype != "real" || isDefault(value) {
+		return nil
+	}
+	v, err := parseNumeric(value, s.unit)
+	if err != nil {
+		return err
+	}
+	if p.Min != "" {
+		if min, err := parseNumeric(p.Min, s.unit); err == nil && v < min {
+			return fmt.Errorf("value %q is below safe minimum %s", value, p.Min)
+		}
+	}
+	if p.Max != "" {
+		if max, err := parseNumeric(p.Max, s.unit); err == nil && v > max {
+			return fmt.Errorf("value %q is above safe maximum %s", value, p.Max)
+		}
+	}
+	return nil
+}
+
+// ParameterState — параметр каталога вместе с его состоянием на сервере
+type ParameterState struct {
+	Parameter
+	Current   string   `json:"current"`
+	Unit      string   `json:"unit,omitempty"`
+	Context   string   `json:"context,omitempty"`
+	VarType   string   `json:"vartype,omitempty"`
+	EnumVals  []string `json:"enumvals,omitempty"`
+	Available bool     `json:"available"` // есть ли параметр в этой версии PostgreSQL
+	Scopable  bool     `json:"scopable"`  // можно ли задать для базы или роли
+}
+
+// DescribeParameters возвращает каталог с текущими значениями из pg_settings
+func DescribeParameters(pool *pgxpool.Pool) ([]ParameterState, error) {
+	settings, err := readSettings(context.Background(), pool, catalogueNames())
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]ParameterState, 0, len(catalogue.Parameters))
+	for _, p := range catalogue.Parameters {
+		state := ParameterState{Parameter: p}
+		if s, ok := settings[p.Name]; ok {
+			state.Available = true
+			state.Current = s.current
+			state.Unit = s.unit
+			state.Context = s.context
+			state.VarType = s.vartype
+			state.EnumVals = s.enumVals
+			state.Scopable = settableInScope(s)
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
@@ -0,0 +1,163 @@
+package configurator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Источники изменений конфигурации
+const (
+	SourcePreset          = "preset"
+	SourceCustom          = "custom"
+	SourceRecommendations = "recommendations"
+	SourceRollback        = "rollback"
+	SourceAutopilot       = "autopilot"
+	SourceEvaluation      = "evaluation"
+)
+
+// ErrChangeNotFound — в истории нет изменения с таким id
+var ErrChangeNotFound = errors.New("config change not found")
+
+// ChangeMeta — кто, зачем и через что меняет конфигурацию
+type ChangeMeta struct {
+	Actor  string `json:"actor"`
+	Reason string `json:"reason"`
+	Source string `json:"source"`
+	Preset string `json:"preset,omitempty"`
+	Scope  Scope  `json:"scope"` // пустой — весь кластер
+}
+
+// ChangeSet — версия конфигурации: что записали и какие значения были до этого
+type ChangeSet struct {
+	ID        int64     `json:"id"`
+	AppliedAt time.Time `json:"applied_at"`
+	ChangeMeta
+	Settings map[string]string `json:"settings"`
+	Previous map[string]string `json:"previous"`
+
+	// Params — что стало с каждым параметром при применении (в историю не сохраняется)
+	Params []ParamResult `json:"params,omitempty"`
+	// Skipped — параметры пресета, которые нельзя задать для базы/роли (в историю не сохраняется)
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// recordChange сохраняет версию в profile_metrics.config_changes
+func recordChange(ctx context.Context, pool *pgxpool.Pool, cs *ChangeSet) error {
+	settings, err := json.Marshal(cs.Settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal settings: %w", err)
+	}
+	previous, err := json.Marshal(cs.Previous)
+	if err != nil {
+		return fmt.Errorf("failed to marshal previous settings: %w", err)
+	}
+
+	err = pool.QueryRow(ctx, `
+		INSERT INTO profile_metrics.config_changes (actor, reason, source, preset, settings, previous, scope_database, scope_role)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, applied_at
+	`, cs.Actor, cs.Reason, cs.Source, cs.Preset, settings, previous, cs.Scope.Database, cs.Scope.Role).Scan(&cs.ID, &cs.AppliedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record config change: %w", err)
+	}
+	return nil
+}
+
+// GetChangeHistory возвращает последние изменения конфигурации (новые первыми)
+func GetChangeHistory(pool *pgxpool.Pool, limit int) ([]ChangeSet, error) {
+	ctx := context.Background()
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT id, applied_at, actor, reason, source, preset, settings, previous, scope_database, scope_role
+		FROM profile_metrics.config_changes
+		ORDER BY id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query config history: %w", err)
+	}
+	return scanChangeSets(rows)
+}
+
+// Rollback возвращает параметры к состоянию перед изменением id. Учитываются и все
+// более поздние изменения: для каждого затронутого параметра берется значение,
+// которое было до самого раннего из них. Изменения других областей (база/роль)
+// не учитываются — откат действует в той же области, что и изменение id.
+// Откат сам записывается как новая версия.
+func Rollback(pool *pgxpool.Pool, id int64, meta ChangeMeta) (*ChangeSet, error) {
+	ctx := context.Background()
+
+	rows, err := pool.Query(ctx, `
+		SELECT id, applied_at, actor, reason, source, preset, settings, previous, scope_database, scope_role
+		FROM profile_metrics.config_changes
+		WHERE id >= $1
+		ORDER BY id
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query config history: %w", err)
+	}
+	changes, err := scanChangeSets(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 || changes[0].ID != id {
+		return nil, fmt.Errorf("%w: %d", ErrChangeNotFound, id)
+	}
+
+	scope := changes[0].Scope
+	target := make(map[string]string)
+	for _, cs := range changes {
+		if cs.Scope != scope {
+			continue
+		}
+		for key, value := range cs.Previous {
+			if _, seen := target[key]; !seen {
+				target[key] = value
+			}
+		}
+	}
+
+	meta.Source = SourceRollback
+	meta.Scope = scope
+	if meta.Reason == "" {
+		meta.Reason = fmt.Sprintf("rollback to state before change #%d", id)
+	}
+	return ApplyCustomConfig(pool, target, meta)
+}
+
+func scanChangeSets(rows pgx.Rows) ([]ChangeSet, error) {
+	defer rows.Close()
+
+	changes := []ChangeSet{}
+	for rows.Next() {
+		var cs ChangeSet
+		var preset *string
+		var settings, previous []byte
+		if err := rows.Scan(&cs.ID, &cs.AppliedAt, &cs.Actor, &cs.Reason, &cs.Source, &preset, &settings, &previous, &cs.Scope.Database, &cs.Scope.Role); err != nil {
+			return nil, fmt.Errorf("failed to scan config change: %w", err)
+		}
+		if preset != nil {
+			cs.Preset = *preset
+		}
+		if err := json.Unmarshal(settings, &cs.Settings); err != nil {
+			return nil, fmt.Errorf("failed to decode settings of change %d: %w", cs.ID, err)
+		}
+		if err := json.Unmarshal(previous, &cs.Previous); err != nil {
+			return nil, fmt.Errorf("failed to decode previous settings of change %d: %w", cs.ID, err)
+		}
+		changes = append(changes, cs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config history: %w", err)
+	}
+	return changes, nil
+}
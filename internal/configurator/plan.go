@@ -0,0 +1,303 @@
+package configurator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// Статусы параметров в плане (dry-run)
+const (
+	PlanChange   = "change"   // значение изменится после pg_reload_conf()
+	PlanEqual    = "equal"    // уже установлено такое же значение
+	PlanRestart  = "restart"  // значение изменится, но только после рестарта (context = postmaster)
+	PlanRejected = "rejected" // параметр не будет записан
+)
+
+// PlanItem — что произойдет с одним параметром
+type PlanItem struct {
+	Name      string `json:"name"`
+	Current   string `json:"current"`   // current_setting(), например 128MB
+	Requested string `json:"requested"` // значение из запроса
+	Unit      string `json:"unit,omitempty"`
+	Context   string `json:"context,omitempty"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Plan — результат dry-run: сравнение запрошенных значений с pg_settings.
+// ALTER SYSTEM при построении плана не выполняется.
+type Plan struct {
+	Preset   string     `json:"preset,omitempty"`
+	Scope    Scope      `json:"scope"`
+	Items    []PlanItem `json:"items"`
+	Changes  int        `json:"changes"`
+	Equal    int        `json:"equal"`
+	Restart  int        `json:"restart"`
+	Rejected int        `json:"rejected"`
+}
+
+// pgSetting — строка pg_settings, нужная для сравнения значений
+type pgSetting struct {
+	setting  string
+	current  string
+	unit     string
+	vartype  string
+	context  string
+	minVal   string
+	maxVal   string
+	enumVals []string
+}
+
+// PlanCustomConfig — dry-run для ApplyCustomConfig. Для области (база/роль)
+// сравнение идет со значениями из pg_db_role_setting.
+func PlanCustomConfig(pool *pgxpool.Pool, configMap map[string]string, scope Scope) (*Plan, error) {
+	ctx := context.Background()
+
+	keys := make([]string, 0, len(configMap))
+	for key := range configMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	settings, err := readSettings(ctx, pool, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var scoped map[string]string
+	if !scope.IsCluster() {
+		if err := validateScope(ctx, pool, scope, nil, nil); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+		if scoped, err = scopedValues(ctx, pool, scope, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	plan := &Plan{Scope: scope, Items: make([]PlanItem, 0, len(keys))}
+	for _, key := range keys {
+		item := planItem(key, configMap[key], settings)
+		if !scope.IsCluster() {
+			item = scopePlanItem(item, settings[key], scoped, scope)
+		}
+		switch item.Status {
+		case PlanChange:
+			plan.Changes++
+		case PlanEqual:
+			plan.Equal++
+		case PlanRestart:
+			plan.Restart++
+		case PlanRejected:
+			plan.Rejected++
+		}
+		plan.Items = append(plan.Items, item)
+	}
+	return plan, nil
+}
+
+// PlanPreset — dry-run для ApplyPreset. Для области серверные параметры
+// пресета попадают в план как rejected — при применении они будут пропущены.
+func PlanPreset(pool *pgxpool.Pool, presetName string, scope Scope) (*Plan, error) {
+	settings := GetSettingsForPreset(presetName)
+	if settings == nil {
+		return nil, fmt.Errorf("unknown preset: %s", presetName)
+	}
+	plan, err := PlanCustomConfig(pool, settings, scope)
+	if err != nil {
+		return nil, err
+	}
+	plan.Preset = presetName
+	return plan, nil
+}
+
+// PlanRecommendations — dry-run для ApplyRecommendations
+func PlanRecommendations(pool *pgxpool.Pool, cfg models.TuningConfig, scope Scope) (*Plan, error) {
+	return PlanCustomConfig(pool, recommendationSettings(cfg), scope)
+}
+
+func readSettings(ctx context.Context, pool *pgxpool.Pool, keys []string) (map[string]pgSetting, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT name, setting, current_setting(name), COALESCE(unit, ''), vartype, context,
+		       COALESCE(min_val, ''), COALESCE(max_val, ''), COALESCE(enumvals, '{}')
+		FROM pg_settings
+		WHERE name = ANY($1)
+	`, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pg_settings: %w", err)
+	}
+	defer rows.Close()
+
+	settings := make(map[string]pgSetting, len(keys))
+	for rows.Next() {
+		var name string
+		var s pgSetting
+		if err := rows.Scan(&name, &s.setting, &s.current, &s.unit, &s.vartype, &s.context, &s.minVal, &s.maxVal, &s.enumVals); err != nil {
+			return nil, fmt.Errorf("failed to scan pg_settings: %w", err)
+		}
+		settings[name] = s
+	}
+	return settings, rows.Err()
+}
+
+func planItem(key, requested string, settings map[string]pgSetting) PlanItem {
+	item := PlanItem{Name: key, Requested: requested}
+
+	if !isValidKey(key) {
+		item.Status, item.Reason = PlanRejected, "parameter is not allowed to be changed via API"
+		return item
+	}
+	s, ok := settings[key]
+	if !ok {
+		item.Status, item.Reason = PlanRejected, "unknown parameter"
+		return item
+	}
+	item.Current, item.Unit, item.Context = s.current, s.unit, s.context
+
+	if err := validateValue(s, requested); err != nil {
+		item.Status, item.Reason = PlanRejected, err.Error()
+		return item
+	}
+	if err := catalogueIndex[key].checkSafeRange(s, requested); err != nil {
+		item.Status, item.Reason = PlanRejected, err.Error()
+		return item
+	}
+
+	if isDefault(requested) {
+		item.Status, item.Reason = PlanChange, "reset to default"
+		if s.context == "postmaster" {
+			item.Status = PlanRestart
+		}
+		return item
+	}
+
+	equal, err := sameValue(s, requested)
+	if err != nil {
+		item.Status, item.Reason = PlanRejected, err.Error()
+		return item
+	}
+
+	switch {
+	case equal:
+		item.Status = PlanEqual
+	case s.context == "postmaster":
+		item.Status, item.Reason = PlanRestart, "requires server restart"
+	default:
+		item.Status = PlanChange
+	}
+	return item
+}
+
+// sameValue сравнивает запрошенное значение с текущим с учетом единиц:
+// 128MB и 16384 (в единицах 8kB) считаются одним и тем же
+func sameValue(s pgSetting, requested string) (bool, error) {
+	requested = strings.TrimSpace(requested)
+
+	switch s.vartype {
+	case "integer", "real":
+		want, err := parseNumeric(requested, s.unit)
+		if err != nil {
+			return false, err
+		}
+		have, err := parseNumeric(s.setting, s.unit)
+		if err != nil {
+			return false, err
+		}
+		return math.Abs(want-have) <= 1e-9*math.Max(1, math.Abs(have)), nil
+	case "bool":
+		want, ok := parseBool(requested)
+		if !ok {
+			return false, fmt.Errorf("invalid boolean value %q", requested)
+		}
+		have, _ := parseBool(s.setting)
+		return want == have, nil
+	case "enum":
+		return strings.EqualFold(requested, s.setting), nil
+	default:
+		return requested == s.setting, nil
+	}
+}
+
+var memoryUnits = map[string]float64{
+	"B":  1,
+	"kB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+var timeUnits = map[string]float64{
+	"us":  0.001,
+	"ms":  1,
+	"s":   1000,
+	"min": 60 * 1000,
+	"h":   60 * 60 * 1000,
+	"d":   24 * 60 * 60 * 1000,
+}
+
+// parseNumeric переводит значение в базовые единицы (байты или миллисекунды).
+// Значение без суффикса трактуется в единицах параметра, как это делает PostgreSQL.
+func parseNumeric(value, unit string) (float64, error) {
+	i := 0
+	for i < len(value) && (value[i] >= '0' && value[i] <= '9' || value[i] == '.' || value[i] == '-' || value[i] == '+') {
+		i++
+	}
+	num, err := strconv.ParseFloat(value[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid numeric value %q", value)
+	}
+	suffix := strings.TrimSpace(value[i:])
+
+	scale, units := unitScale(unit)
+	if suffix == "" {
+		return num * scale, nil
+	}
+	if units == nil {
+		return 0, fmt.Errorf("parameter has no unit, got %q", value)
+	}
+	mult, ok := units[suffix]
+	if !ok {
+		return 0, fmt.Errorf("invalid unit in %q", value)
+	}
+	return num * mult, nil
+}
+
+// unitScale разбирает единицу из pg_settings (8kB, 16MB, ms, min...) в множитель
+// к базовой единице и таблицу допустимых суффиксов
+func unitScale(unit string) (float64, map[string]float64) {
+	if unit == "" {
+		return 1, nil
+	}
+	i := 0
+	for i < len(unit) && unit[i] >= '0' && unit[i] <= '9' {
+		i++
+	}
+	count := 1.0
+	if i > 0 {
+		count, _ = strconv.ParseFloat(unit[:i], 64)
+	}
+	base := unit[i:]
+	if mult, ok := memoryUnits[base]; ok {
+		return count * mult, memoryUnits
+	}
+	if mult, ok := timeUnits[base]; ok {
+		return count * mult, timeUnits
+	}
+	return 1, nil
+}
+
+func parseBool(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "1", "t", "y":
+		return true, true
+	case "off", "false", "no", "0", "f", "n":
+		return false, true
+	}
+	return false, false
+}
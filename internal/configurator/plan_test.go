@@ -0,0 +1,154 @@
+package configurator
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseNumeric(t *testing.T) {
+	tests := []struct {
+		value   string
+		unit    string
+		want    float64 // байты или миллисекунды
+		wantErr bool
+	}{
+		{"16384", "8kB", 16384 * 8192, false},
+		{"128MB", "8kB", 128 << 20, false},
+		{"4MB", "kB", 4 << 20, false},
+		{"64", "kB", 64 << 10, false},
+		{"1GB", "MB", 1 << 30, false},
+		{"2 GB", "kB", 2 << 30, false}, // пробел перед единицей допустим
+		{"200", "ms", 200, false},
+		{"1s", "ms", 1000, false},
+		{"30min", "s", 30 * 60 * 1000, false},
+		{"300", "s", 300 * 1000, false},
+		{"1h", "min", 60 * 60 * 1000, false},
+		{"1", "min", 60 * 1000, false},
+		{"500us", "ms", 0.5, false},
+		{"1d", "s", 24 * 60 * 60 * 1000, false},
+		{"-1", "kB", -1024, false},
+		{"-1", "ms", -1, false},
+		{"1.1", "", 1.1, false},
+		{"100", "", 100, false},
+		{"10MB", "", 0, true}, // у параметра нет единиц
+		{"10ms", "kB", 0, true},
+		{"10 parsecs", "kB", 0, true},
+		{"MB", "kB", 0, true},
+		{"", "kB", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value+" in "+tt.unit, func(t *testing.T) {
+			got, err := parseNumeric(tt.value, tt.unit)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnitScale(t *testing.T) {
+	tests := []struct {
+		unit      string
+		scale     float64
+		hasSuffix bool
+	}{
+		{"", 1, false},
+		{"8kB", 8192, true},
+		{"16MB", 16 << 20, true},
+		{"kB", 1024, true},
+		{"B", 1, true},
+		{"ms", 1, true},
+		{"s", 1000, true},
+		{"min", 60000, true},
+		{"furlongs", 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			scale, units := unitScale(tt.unit)
+			if scale != tt.scale || (units != nil) != tt.hasSuffix {
+				t.Fatalf("got %v, suffixes %v; want %v, suffixes %v", scale, units != nil, tt.scale, tt.hasSuffix)
+			}
+		})
+	}
+}
+
+func TestSameValue(t *testing.T) {
+	sharedBuffers := pgSetting{setting: "16384", unit: "8kB", vartype: "integer"}
+	workMem := pgSetting{setting: "4096", unit: "kB", vartype: "integer"}
+	checkpointTimeout := pgSetting{setting: "300", unit: "s", vartype: "integer"}
+	pageCost := pgSetting{setting: "1.1", vartype: "real"}
+	jit := pgSetting{setting: "on", vartype: "bool"}
+	syncCommit := pgSetting{setting: "on", vartype: "enum", enumVals: []string{"local", "remote_write", "remote_apply", "on", "off"}}
+	searchPath := pgSetting{setting: `"$user", public`, vartype: "string"}
+
+	tests := []struct {
+		name      string
+		s         pgSetting
+		requested string
+		want      bool
+		wantErr   bool
+	}{
+		{"128MB equals 16384 pages", sharedBuffers, "128MB", true, false},
+		{"raw pages", sharedBuffers, "16384", true, false},
+		{"1GB differs", sharedBuffers, "1GB", false, false},
+		{"131072kB equals", sharedBuffers, "131072kB", true, false},
+		{"4MB work_mem", workMem, "4MB", true, false},
+		{"5min checkpoint", checkpointTimeout, "5min", true, false},
+		{"300000ms checkpoint", checkpointTimeout, "300000ms", true, false},
+		{"10min differs", checkpointTimeout, "10min", false, false},
+		{"real equal", pageCost, "1.10", true, false},
+		{"real differs", pageCost, "4", false, false},
+		{"bool synonyms", jit, "true", true, false},
+		{"bool differs", jit, "off", false, false},
+		{"bad bool", jit, "maybe", false, true},
+		{"enum case-insensitive", syncCommit, "ON", true, false},
+		{"enum differs", syncCommit, "off", false, false},
+		{"string exact", searchPath, `"$user", public`, true, false},
+		{"bad number", sharedBuffers, "lots", false, true},
+		{"surrounding spaces", workMem, " 4MB ", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sameValue(tt.s, tt.requested)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   bool
+		wantOK bool
+	}{
+		{"on", true, true}, {"TRUE", true, true}, {"yes", true, true}, {"1", true, true}, {"t", true, true}, {"y", true, true},
+		{"off", false, true}, {"False", false, true}, {"no", false, true}, {"0", false, true}, {"f", false, true}, {"n", false, true},
+		{"", false, false}, {"2", false, false}, {"enabled", false, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseBool(tt.value)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseBool(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -3,126 +3,252 @@ package configurator
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lypolix/pg_load_profile/internal/models"
 )
 
-// ApplyCustomConfig применяет произвольные настройки из карты (для PATCH)
-func ApplyCustomConfig(pool *pgxpool.Pool, configMap map[string]string) error {
+// ApplyCustomConfig применяет произвольные настройки из карты (для PATCH).
+// Перед записью запоминает текущие значения из pg_settings и сохраняет изменение
+// как версию в profile_metrics.config_changes (см. GetChangeHistory / Rollback).
+// Значения сначала проверяются по pg_settings (тип, единицы, min/max, enumvals) — при
+// любой ошибке ничего не записывается. Если ALTER SYSTEM все же упал на середине,
+// уже записанные параметры возвращаются к тому, что было в postgresql.auto.conf.
+// Если в meta задана область (база/роль), вместо ALTER SYSTEM используется
+// ALTER DATABASE / ALTER ROLE ... SET (см. Scope).
+func ApplyCustomConfig(pool *pgxpool.Pool, configMap map[string]string, meta ChangeMeta) (*ChangeSet, error) {
 	ctx := context.Background()
 
-	for key, value := range configMap {
+	keys := make([]string, 0, len(configMap))
+	for key := range configMap {
 		// Валидация ключей (чтобы не выполнить SQL Injection или не сломать базу левым параметром)
 		if !isValidKey(key) {
-			return fmt.Errorf("invalid or forbidden parameter: %s", key)
+			return nil, fmt.Errorf("%w: invalid or forbidden parameter: %s", ErrInvalidConfig, key)
 		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-		query := fmt.Sprintf("ALTER SYSTEM SET %s = '%s';", key, value)
-		if _, err := pool.Exec(ctx, query); err != nil {
-			return fmt.Errorf("failed to set %s: %w", key, err)
+	before, err := readSettings(ctx, pool, keys)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateConfig(keys, configMap, before); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if !meta.Scope.IsCluster() {
+		if err := validateScope(ctx, pool, meta.Scope, keys, before); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+		return applyScoped(ctx, pool, keys, configMap, before, meta)
+	}
+	// Прежнее состояние — содержимое auto.conf: параметры, которых там не было,
+	// записываются как DEFAULT, и откат сбрасывает их (ALTER SYSTEM RESET), а не
+	// закрепляет текущее значение. Если auto.conf не прочитать — текущие значения.
+	previous := make(map[string]string, len(keys))
+	if auto, err := autoConfValues(ctx, pool, keys); err == nil {
+		for _, key := range keys {
+			previous[key] = scopeDefault
+			if value, ok := auto[key]; ok {
+				previous[key] = value
+			}
 		}
+	} else {
+		fmt.Printf("[Configurator] Warning: %v, rollback will use current values\n", err)
+		for name, s := range before {
+			previous[name] = s.current
+		}
+	}
+
+	var applied []string
+	for _, key := range keys {
+		if _, err := pool.Exec(ctx, alterSystemSQL(key, configMap[key])); err != nil {
+			restoreSettings(ctx, pool, applied, previous)
+			return nil, fmt.Errorf("failed to set %s (already written parameters restored): %w", key, err)
+		}
+		applied = append(applied, key)
 	}
 
 	// Перечитываем конфиг, чтобы применилось без рестарта (для поддерживаемых параметров)
 	if _, err := pool.Exec(ctx, "SELECT pg_reload_conf();"); err != nil {
-		return fmt.Errorf("failed to reload conf: %w", err)
+		return nil, fmt.Errorf("failed to reload conf: %w", err)
+	}
+
+	params, err := paramResults(ctx, pool, keys, configMap, before)
+	if err != nil {
+		// Статусы — только отчет, сама конфигурация уже записана
+		fmt.Printf("[Configurator] Warning: %v\n", err)
+	}
+
+	cs := &ChangeSet{ChangeMeta: meta, Settings: configMap, Previous: previous, Params: params}
+	if err := recordChange(ctx, pool, cs); err != nil {
+		// Конфигурация уже применена — не считаем это ошибкой применения
+		fmt.Printf("[Configurator] Warning: %v\n", err)
 	}
 
-	return nil
+	return cs, nil
+}
+
+// restoreSettings возвращает уже записанные параметры к прежним значениям
+// (ALTER SYSTEM нельзя выполнить в транзакции, поэтому откатываем вручную).
+// Параметры, которых нет в previous, сбрасываются через ALTER SYSTEM RESET.
+func restoreSettings(ctx context.Context, pool *pgxpool.Pool, keys []string, previous map[string]string) {
+	for _, key := range keys {
+		query := fmt.Sprintf("ALTER SYSTEM RESET %s", pgx.Identifier{key}.Sanitize())
+		if value, ok := previous[key]; ok {
+			query = alterSystemSQL(key, value)
+		}
+		if _, err := pool.Exec(ctx, query); err != nil {
+			fmt.Printf("[Configurator] Warning: failed to restore %s: %v\n", key, err)
+		}
+	}
 }
 
-// GetCurrentConfig получает текущие значения конфигурационных параметров из БД
+// GetCurrentConfig получает текущие значения всех параметров каталога из БД
 func GetCurrentConfig(pool *pgxpool.Pool) (map[string]string, error) {
 	ctx := context.Background()
-	config := make(map[string]string)
 
-	// Список параметров, которые мы хотим получить
-	params := []string{
-		"shared_buffers",
-		"work_mem",
-		"max_wal_size",
-		"checkpoint_timeout",
-		"synchronous_commit",
-		"max_parallel_workers_per_gather",
-		"deadlock_timeout",
-		"max_parallel_workers",
-		"autovacuum_naptime",
-		"temp_file_limit",
-		"min_wal_size",
-	}
-
-	for _, param := range params {
-		var value string
-		query := fmt.Sprintf("SHOW %s;", param)
-		err := pool.QueryRow(ctx, query).Scan(&value)
-		if err != nil {
-			// Если параметр не найден или недоступен, пропускаем его
-			continue
-		}
-		config[param] = value
-	}
-
-	return config, nil
+	// Параметров, которых нет в этой версии PostgreSQL, в pg_settings просто не будет
+	rows, err := pool.Query(ctx, `
+		SELECT name, current_setting(name)
+		FROM pg_settings
+		WHERE name = ANY($1)
+	`, catalogueNames())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pg_settings: %w", err)
+	}
+	defer rows.Close()
+
+	config := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan pg_settings: %w", err)
+		}
+		config[name] = value
+	}
+	return config, rows.Err()
 }
 
 // ApplyRecommendations применяет структуру TuningConfig, полученную от AI
-func ApplyRecommendations(pool *pgxpool.Pool, cfg models.TuningConfig) error {
-	settings := map[string]string{
-		"shared_buffers":                  cfg.SharedBuffers,
-		"work_mem":                        cfg.WorkMem,
-		"max_wal_size":                    cfg.MaxWalSize,
-		"checkpoint_timeout":              cfg.CheckpointTimeout,
-		"synchronous_commit":              cfg.SynchronousCommit,
-		"max_parallel_workers_per_gather": cfg.MaxParallelWorkers,
-		"deadlock_timeout":                cfg.DeadlockTimeout,
-	}
-	
+func ApplyRecommendations(pool *pgxpool.Pool, cfg models.TuningConfig, meta ChangeMeta) (*ChangeSet, error) {
+	meta.Source = SourceRecommendations
+	return applyInScope(pool, recommendationSettings(cfg), meta)
+}
+
+// recommendationSettings переводит TuningConfig в параметры postgresql.conf
+func recommendationSettings(cfg models.TuningConfig) map[string]string {
 	// Фильтруем пустые значения, если вдруг они есть
 	cleanSettings := make(map[string]string)
-	for k, v := range settings {
+	for k, v := range cfg {
 		if v != "" {
 			cleanSettings[k] = v
 		}
 	}
-
-	return ApplyCustomConfig(pool, cleanSettings)
+	return cleanSettings
 }
 
 // ApplyPreset применяет заранее заготовленный пресет (для демо)
-func ApplyPreset(pool *pgxpool.Pool, presetName string) error {
+func ApplyPreset(pool *pgxpool.Pool, presetName string, meta ChangeMeta) (*ChangeSet, error) {
 	settings := GetSettingsForPreset(presetName)
 	if settings == nil {
-		return fmt.Errorf("unknown preset: %s", presetName)
+		return nil, fmt.Errorf("unknown preset: %s", presetName)
 	}
-	return ApplyCustomConfig(pool, settings)
+	if meta.Source == "" {
+		meta.Source = SourcePreset
+	}
+	meta.Preset = presetName
+	return applyInScope(pool, settings, meta)
 }
 
-// isValidKey проверяет, разрешено ли менять этот параметр через API
+// isValidKey проверяет, разрешено ли менять этот параметр через API (есть ли он в каталоге)
 func isValidKey(key string) bool {
-	allowed := map[string]bool{
-		"shared_buffers":                  true,
-		"work_mem":                        true,
-		"max_wal_size":                    true,
-		"checkpoint_timeout":              true,
-		"synchronous_commit":              true,
-		"max_parallel_workers_per_gather": true,
-		"deadlock_timeout":                true,
-		"autovacuum_naptime":              true,
-		"effective_cache_size":            true,
-		"wal_compression":                 true,
-		"maintenance_work_mem":            true,
-		"max_connections":                 true,
-	}
-	return allowed[key]
+	_, ok := catalogueIndex[key]
+	return ok
+}
+
+// builtinSpec — встроенный пресет: значения считает SizePreset
+type builtinSpec struct {
+	name        string
+	description string
+	mlProfiles  []string // профили ML-сервиса, которые ведут на этот пресет
+}
+
+// builtinPresets — встроенные пресеты в порядке показа
+var builtinPresets = []builtinSpec{
+	{"oltp", "Банк, Магазин", []string{"oltp", "init"}},
+	{"olap", "BI, Отчеты", []string{"olap"}},
+	{"write_heavy", "IoT", []string{"iot"}},
+	{"high_concurrency", "Распродажа", []string{"locks"}},
+	{"reporting", "Read-Heavy (Catalog)", []string{"reporting"}},
+	{"mixed", "HTAP", []string{"mixed"}},
+	{"etl", "Bulk Load", []string{"etl"}},
+	{"cold", "Archive", []string{"cold"}},
 }
 
-// GetSettingsForPreset возвращает настройки для заданного профиля
+func builtinPreset(name string) (builtinSpec, bool) {
+	for _, b := range builtinPresets {
+		if b.name == name {
+			return b, true
+		}
+	}
+	return builtinSpec{}, false
+}
+
+// IsBuiltinPreset — есть ли встроенный пресет с таким именем
+func IsBuiltinPreset(name string) bool {
+	_, ok := builtinPreset(name)
+	return ok
+}
+
+// PresetNames возвращает имена всех пресетов: встроенные, затем пользовательские
+func PresetNames() []string {
+	names := make([]string, 0, len(builtinPresets))
+	seen := make(map[string]bool)
+	for _, b := range builtinPresets {
+		names = append(names, b.name)
+		seen[b.name] = true
+	}
+
+	userPresets.mu.RLock()
+	var custom []string
+	for name := range userPresets.presets {
+		if !seen[name] {
+			custom = append(custom, name)
+		}
+	}
+	userPresets.mu.RUnlock()
+	sort.Strings(custom)
+	return append(names, custom...)
+}
+
+// GetSettingsForPreset возвращает настройки для заданного профиля: пользовательский
+// пресет из profile_metrics.presets или встроенный, рассчитанный по текущим ресурсам
+// сервера (см. SetResources / DetectResources)
 func GetSettingsForPreset(name string) map[string]string {
+	if p, ok := userPreset(name); ok {
+		return copySettings(p.Settings)
+	}
+	return SizePreset(name, CurrentResources())
+}
+
+// SizePreset считает пресет по формулам от ресурсов: доли RAM, число ядер,
+// тип дисков и max_connections. Значения WAL и таймаутов от железа не зависят.
+func SizePreset(name string, r Resources) map[string]string {
+	ram := r.TotalMemory
+	sharedBuffers := clampBytes(ram/4, 32*mb, 32*gb)
+	effectiveCache := ram * 3 / 4
+	maxConn := int64(r.MaxConnections)
+
 	switch name {
 	case "oltp": // Банк, Магазин
 		return map[string]string{
-			"shared_buffers":                  "128MB",
-			"work_mem":                        "4MB",
+			"shared_buffers":                  formatBytes(sharedBuffers),
+			"effective_cache_size":            formatBytes(effectiveCache),
+			"work_mem":                        formatBytes(workMem(ram, sharedBuffers, maxConn, 1, 64*mb)),
 			"max_wal_size":                    "1GB",
 			"checkpoint_timeout":              "15min",
 			"synchronous_commit":              "on",
@@ -130,18 +256,22 @@ func GetSettingsForPreset(name string) map[string]string {
 			"deadlock_timeout":                "1s",
 		}
 	case "olap": // BI, Отчеты
-		return map[string]string{
-			"shared_buffers":                  "256MB",
-			"work_mem":                        "32MB",
+		workers := parallelWorkers(r.CPUCores, 2, 8)
+		settings := map[string]string{
+			"shared_buffers":                  formatBytes(sharedBuffers),
+			"effective_cache_size":            formatBytes(effectiveCache),
+			"work_mem":                        formatBytes(workMem(ram, sharedBuffers, min(maxConn, 20), workers, 2*gb)),
 			"max_wal_size":                    "4GB",
 			"checkpoint_timeout":              "30min",
-			"max_parallel_workers_per_gather": "2",
+			"max_parallel_workers_per_gather": strconv.Itoa(workers),
+			"max_parallel_workers":            strconv.Itoa(r.CPUCores),
 			"synchronous_commit":              "on",
 			"deadlock_timeout":                "1s",
 		}
+		return withStorage(settings, r)
 	case "write_heavy": // IoT
 		return map[string]string{
-			"shared_buffers":     "128MB",
+			"shared_buffers":     formatBytes(sharedBuffers),
 			"max_wal_size":       "8GB",
 			"checkpoint_timeout": "30min",
 			"synchronous_commit": "off",
@@ -149,43 +279,52 @@ func GetSettingsForPreset(name string) map[string]string {
 			"deadlock_timeout":   "1s",
 		}
 	case "high_concurrency": // Распродажа
+		connections := max(maxConn, 200)
 		return map[string]string{
-			"shared_buffers":   "128MB",
-			"work_mem":         "4MB",
+			"shared_buffers":   formatBytes(sharedBuffers),
+			"work_mem":         formatBytes(workMem(ram, sharedBuffers, connections, 1, 32*mb)),
 			"deadlock_timeout": "100ms",
-			"max_connections":  "200",
+			"max_connections":  strconv.FormatInt(connections, 10),
 		}
 	case "reporting": // Read-Heavy (Catalog)
-		return map[string]string{
-			"shared_buffers":       "350MB",
-			"work_mem":             "16MB",
-			"effective_cache_size": "1GB",
+		// Горячий каталог целиком в shared_buffers: отдаем кэшу больше обычного
+		readBuffers := clampBytes(ram*35/100, 32*mb, 32*gb)
+		settings := map[string]string{
+			"shared_buffers":       formatBytes(readBuffers),
+			"work_mem":             formatBytes(workMem(ram, readBuffers, min(maxConn, 40), 1, gb)),
+			"effective_cache_size": formatBytes(effectiveCache),
 			"synchronous_commit":   "on",
 			"deadlock_timeout":     "1s",
 		}
+		return withStorage(settings, r)
 	case "mixed": // HTAP
-		return map[string]string{
-			"shared_buffers":                  "200MB",
-			"work_mem":                        "16MB",
-			"max_parallel_workers_per_gather": "2",
+		workers := parallelWorkers(r.CPUCores, 4, 4)
+		settings := map[string]string{
+			"shared_buffers":                  formatBytes(sharedBuffers),
+			"effective_cache_size":            formatBytes(effectiveCache),
+			"work_mem":                        formatBytes(workMem(ram, sharedBuffers, min(maxConn, 50), workers, 512*mb)),
+			"max_parallel_workers_per_gather": strconv.Itoa(workers),
 			"synchronous_commit":              "on",
 			"deadlock_timeout":                "1s",
 		}
+		return withStorage(settings, r)
 	case "etl": // Bulk Load
 		return map[string]string{
-			"maintenance_work_mem": "256MB",
+			"maintenance_work_mem": formatBytes(clampBytes(ram/8, 64*mb, 2*gb)),
 			"max_wal_size":         "10GB",
 			"checkpoint_timeout":   "1h",
 			"wal_compression":      "on",
 			"deadlock_timeout":     "1s",
 		}
 	case "cold": // Archive
-		return map[string]string{
-			"work_mem":                        "64MB",
-			"max_parallel_workers_per_gather": "4",
+		workers := parallelWorkers(r.CPUCores, 2, 4)
+		settings := map[string]string{
+			"work_mem":                        formatBytes(workMem(ram, sharedBuffers, min(maxConn, 10), workers, gb)),
+			"max_parallel_workers_per_gather": strconv.Itoa(workers),
 			"synchronous_commit":              "on",
 			"deadlock_timeout":                "1s",
 		}
+		return withStorage(settings, r)
 	default:
 		return nil
 	}
@@ -0,0 +1,202 @@
+package configurator
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Откуда взято значение ресурса
+const (
+	ResourceFromEnv        = "env"
+	ResourceFromHost       = "host"
+	ResourceFromPgSettings = "pg_settings"
+	ResourceFromDefault    = "default"
+)
+
+// Resources — ресурсы сервера БД, от которых считаются пресеты
+type Resources struct {
+	TotalMemory    int64             `json:"total_memory_bytes"`
+	CPUCores       int               `json:"cpu_cores"`
+	Storage        string            `json:"storage"` // ssd / hdd
+	MaxConnections int               `json:"max_connections"`
+	Source         map[string]string `json:"source"` // поле -> env / host / pg_settings / default
+}
+
+// DefaultResources — небольшой сервер, с которым работают пресеты, пока ресурсы не определены
+func DefaultResources() Resources {
+	return Resources{
+		TotalMemory:    1 << 30,
+		CPUCores:       2,
+		Storage:        "ssd",
+		MaxConnections: 100,
+		Source: map[string]string{
+			"total_memory_bytes": ResourceFromDefault,
+			"cpu_cores":          ResourceFromDefault,
+			"storage":            ResourceFromDefault,
+			"max_connections":    ResourceFromDefault,
+		},
+	}
+}
+
+var (
+	resourcesMu sync.RWMutex
+	resources   = DefaultResources()
+)
+
+// SetResources задает ресурсы, по которым GetSettingsForPreset считает пресеты
+func SetResources(r Resources) {
+	resourcesMu.Lock()
+	resources = r
+	resourcesMu.Unlock()
+}
+
+// CurrentResources возвращает ресурсы, по которым сейчас считаются пресеты
+func CurrentResources() Resources {
+	resourcesMu.RLock()
+	defer resourcesMu.RUnlock()
+	return resources
+}
+
+// DetectResources определяет ресурсы хоста БД. Порядок для каждого значения:
+// переменная окружения (DB_TOTAL_MEMORY, DB_CPU_CORES, DB_STORAGE_TYPE, DB_MAX_CONNECTIONS),
+// затем сам хост БД (/proc и cgroup через pg_read_file — нужны права суперпользователя
+// или pg_read_server_files), затем pg_settings, затем DefaultResources.
+func DetectResources(pool *pgxpool.Pool) Resources {
+	ctx := context.Background()
+	r := DefaultResources()
+
+	// --- Память ---
+	if v := os.Getenv("DB_TOTAL_MEMORY"); v != "" {
+		if bytes, err := parseNumeric(v, "B"); err == nil && bytes > 0 {
+			r.TotalMemory, r.Source["total_memory_bytes"] = int64(bytes), ResourceFromEnv
+		} else {
+			fmt.Printf("[Configurator] Warning: invalid DB_TOTAL_MEMORY %q\n", v)
+		}
+	} else if bytes, err := hostMemory(ctx, pool); err == nil {
+		r.TotalMemory, r.Source["total_memory_bytes"] = bytes, ResourceFromHost
+	} else {
+		fmt.Printf("[Configurator] Warning: cannot detect DB host memory: %v\n", err)
+	}
+
+	// --- CPU ---
+	if v := os.Getenv("DB_CPU_CORES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			r.CPUCores, r.Source["cpu_cores"] = n, ResourceFromEnv
+		} else {
+			fmt.Printf("[Configurator] Warning: invalid DB_CPU_CORES %q\n", v)
+		}
+	} else if n, err := hostCPUCores(ctx, pool); err == nil {
+		r.CPUCores, r.Source["cpu_cores"] = n, ResourceFromHost
+	} else {
+		fmt.Printf("[Configurator] Warning: cannot detect DB host CPU cores: %v\n", err)
+	}
+
+	// --- Тип дисков (только подсказка из конфигурации) ---
+	if v := strings.ToLower(os.Getenv("DB_STORAGE_TYPE")); v != "" {
+		if v == "ssd" || v == "hdd" {
+			r.Storage, r.Source["storage"] = v, ResourceFromEnv
+		} else {
+			fmt.Printf("[Configurator] Warning: invalid DB_STORAGE_TYPE %q (use ssd or hdd)\n", v)
+		}
+	}
+
+	// --- max_connections ---
+	if v := os.Getenv("DB_MAX_CONNECTIONS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			r.MaxConnections, r.Source["max_connections"] = n, ResourceFromEnv
+		} else {
+			fmt.Printf("[Configurator] Warning: invalid DB_MAX_CONNECTIONS %q\n", v)
+		}
+	} else {
+		var n int
+		if err := pool.QueryRow(ctx, "SELECT current_setting('max_connections')::int").Scan(&n); err == nil {
+			r.MaxConnections, r.Source["max_connections"] = n, ResourceFromPgSettings
+		}
+	}
+
+	return r
+}
+
+// hostMemory — MemTotal из /proc/meminfo, ограниченный лимитом cgroup, если он есть
+func hostMemory(ctx context.Context, pool *pgxpool.Pool) (int64, error) {
+	meminfo, err := readHostFile(ctx, pool, "/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	scanner := bufio.NewScanner(strings.NewReader(meminfo))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid MemTotal: %w", err)
+			}
+			total = kb << 10
+			break
+		}
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("MemTotal not found in /proc/meminfo")
+	}
+
+	// В контейнере лимит памяти может быть меньше памяти хоста (cgroup v2)
+	if limit, err := readHostFile(ctx, pool, "/sys/fs/cgroup/memory.max"); err == nil {
+		if n, err := strconv.ParseInt(strings.TrimSpace(limit), 10, 64); err == nil && n > 0 && n < total {
+			total = n
+		}
+	}
+	return total, nil
+}
+
+// hostCPUCores — число процессоров из /proc/cpuinfo, ограниченное квотой cgroup, если она есть
+func hostCPUCores(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	cpuinfo, err := readHostFile(ctx, pool, "/proc/cpuinfo")
+	if err != nil {
+		return 0, err
+	}
+
+	cores := 0
+	scanner := bufio.NewScanner(strings.NewReader(cpuinfo))
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "processor") {
+			cores++
+		}
+	}
+	if cores == 0 {
+		return 0, fmt.Errorf("no processors found in /proc/cpuinfo")
+	}
+
+	// cpu.max: "<quota> <period>" или "max <period>"
+	if quota, err := readHostFile(ctx, pool, "/sys/fs/cgroup/cpu.max"); err == nil {
+		fields := strings.Fields(quota)
+		if len(fields) == 2 {
+			q, errQ := strconv.ParseFloat(fields[0], 64)
+			p, errP := strconv.ParseFloat(fields[1], 64)
+			if errQ == nil && errP == nil && p > 0 {
+				if limit := int(math.Ceil(q / p)); limit > 0 && limit < cores {
+					cores = limit
+				}
+			}
+		}
+	}
+	return cores, nil
+}
+
+// readHostFile читает файл на хосте БД (а не там, где запущен бэкенд)
+func readHostFile(ctx context.Context, pool *pgxpool.Pool, path string) (string, error) {
+	var content string
+	if err := pool.QueryRow(ctx, "SELECT pg_read_file($1)", path).Scan(&content); err != nil {
+		return "", fmt.Errorf("failed to read %s on DB host: %w", path, err)
+	}
+	return content, nil
+}
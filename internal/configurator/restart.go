@@ -0,0 +1,316 @@
+package configurator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Статусы параметра после применения
+const (
+	ParamApplied        = "applied"         // действует после pg_reload_conf()
+	ParamPendingRestart = "pending_restart" // записан в postgresql.auto.conf, заработает после рестарта
+	ParamUnchanged      = "unchanged"       // значение уже было таким
+	ParamNextSession    = "next_session"    // задан для базы/роли, действует в новых сессиях
+)
+
+// ParamResult — статус одного параметра после ApplyCustomConfig
+type ParamResult struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Previous string `json:"previous"`
+	Context  string `json:"context"`
+	Status   string `json:"status"`
+}
+
+// PendingRestart возвращает имена параметров изменения, которые ждут рестарта
+func (cs *ChangeSet) PendingRestart() []string {
+	var names []string
+	for _, p := range cs.Params {
+		if p.Status == ParamPendingRestart {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// paramResults определяет статус параметров после записи и pg_reload_conf().
+// pending_restart в pg_settings выставляется не мгновенно (reload асинхронный),
+// поэтому для postmaster-параметров измененное значение само по себе означает рестарт.
+func paramResults(ctx context.Context, pool *pgxpool.Pool, keys []string, configMap map[string]string, before map[string]pgSetting) ([]ParamResult, error) {
+	pending := make(map[string]bool)
+	rows, err := pool.Query(ctx, `
+		SELECT name FROM pg_settings WHERE name = ANY($1) AND pending_restart
+	`, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending_restart: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan pending_restart: %w", err)
+		}
+		pending[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pending_restart: %w", err)
+	}
+
+	results := make([]ParamResult, 0, len(keys))
+	for _, key := range keys {
+		s := before[key]
+		r := ParamResult{Name: key, Value: configMap[key], Previous: s.current, Context: s.context}
+
+		equal, err := sameValue(s, configMap[key])
+		switch {
+		case err == nil && equal && !pending[key]:
+			r.Status = ParamUnchanged
+		case pending[key] || s.context == "postmaster" && !equal:
+			r.Status = ParamPendingRestart
+		default:
+			r.Status = ParamApplied
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// PendingSetting — параметр, новое значение которого ждет рестарта
+type PendingSetting struct {
+	Name    string `json:"name"`
+	Current string `json:"current"` // значение, с которым работает сервер
+	Pending string `json:"pending"` // значение из конфигурационных файлов
+}
+
+// GetPendingRestart возвращает все параметры сервера с pending_restart = true
+func GetPendingRestart(pool *pgxpool.Pool) ([]PendingSetting, error) {
+	ctx := context.Background()
+
+	rows, err := pool.Query(ctx, `
+		SELECT s.name, current_setting(s.name), COALESCE(f.setting, '')
+		FROM pg_settings s
+		LEFT JOIN LATERAL (
+			SELECT setting FROM pg_file_settings f
+			WHERE f.name = s.name AND f.error IS NULL
+			ORDER BY f.seqno DESC
+			LIMIT 1
+		) f ON true
+		WHERE s.pending_restart
+		ORDER BY s.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending restart settings: %w", err)
+	}
+	defer rows.Close()
+
+	pending := []PendingSetting{}
+	for rows.Next() {
+		var p PendingSetting
+		if err := rows.Scan(&p.Name, &p.Current, &p.Pending); err != nil {
+			return nil, fmt.Errorf("failed to scan pending restart settings: %w", err)
+		}
+		pending = append(pending, p)
+	}
+	return pending, rows.Err()
+}
+
+const (
+	// restartTokenTTL — сколько действует токен подтверждения рестарта
+	restartTokenTTL = 2 * time.Minute
+	// restartCommandTimeout — сколько ждем завершения DB_RESTART_COMMAND
+	restartCommandTimeout = 2 * time.Minute
+	// restartWaitTimeout — сколько ждем, пока база снова начнет принимать подключения
+	restartWaitTimeout = time.Minute
+)
+
+// ErrRestartNotConfigured — DB_RESTART_COMMAND не задан, перезапуск только вручную
+var ErrRestartNotConfigured = errors.New("restart command is not configured (set DB_RESTART_COMMAND or restart PostgreSQL manually)")
+
+// RestartRequest — первый шаг перезапуска: что изменится и токен для подтверждения
+type RestartRequest struct {
+	Token     string           `json:"token"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	Command   string           `json:"command"`
+	Pending   []PendingSetting `json:"pending"`
+}
+
+// RestartResult — итог выполненного перезапуска
+type RestartResult struct {
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Command    string           `json:"command"`
+	Output     string           `json:"output"`
+	Error      string           `json:"error,omitempty"`
+	Pending    []PendingSetting `json:"pending"` // что все еще ждет рестарта после него
+}
+
+// RestartStatus — состояние для GET /config/restart
+type RestartStatus struct {
+	Configured bool             `json:"configured"`
+	Command    string           `json:"command,omitempty"`
+	Running    bool             `json:"running"`
+	Pending    []PendingSetting `json:"pending"`
+	Last       *RestartResult   `json:"last,omitempty"`
+}
+
+// Restarter — перезапуск PostgreSQL в два шага: Request выдает токен,
+// Confirm с этим токеном выполняет DB_RESTART_COMMAND и ждет, пока база поднимется.
+// Команда зависит от развертывания: pg_ctl для локальной установки,
+// docker restart <контейнер> для docker-compose.
+type Restarter struct {
+	pool    *pgxpool.Pool
+	command string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	running bool
+	last    *RestartResult
+}
+
+// NewRestarter создает Restarter; пустая команда — перезапуск недоступен через API
+func NewRestarter(pool *pgxpool.Pool, command string) *Restarter {
+	return &Restarter{pool: pool, command: command}
+}
+
+// RestartCommandFromEnv читает DB_RESTART_COMMAND
+func RestartCommandFromEnv() string {
+	return os.Getenv("DB_RESTART_COMMAND")
+}
+
+// Status возвращает, настроен ли перезапуск, и какие параметры его ждут
+func (r *Restarter) Status() (RestartStatus, error) {
+	pending, err := GetPendingRestart(r.pool)
+	if err != nil {
+		return RestartStatus{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RestartStatus{
+		Configured: r.command != "",
+		Command:    r.command,
+		Running:    r.running,
+		Pending:    pending,
+		Last:       r.last,
+	}, nil
+}
+
+// Request готовит перезапуск и возвращает токен, который нужно передать в Confirm
+func (r *Restarter) Request() (*RestartRequest, error) {
+	if r.command == "" {
+		return nil, ErrRestartNotConfigured
+	}
+	pending, err := GetPendingRestart(r.pool)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate restart token: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		return nil, fmt.Errorf("restart is already in progress")
+	}
+	r.token = hex.EncodeToString(buf)
+	r.expires = time.Now().Add(restartTokenTTL)
+
+	return &RestartRequest{Token: r.token, ExpiresAt: r.expires, Command: r.command, Pending: pending}, nil
+}
+
+// Confirm выполняет перезапуск, если токен совпадает и не истек. Токен одноразовый.
+func (r *Restarter) Confirm(ctx context.Context, token string) (*RestartResult, error) {
+	if r.command == "" {
+		return nil, ErrRestartNotConfigured
+	}
+
+	r.mu.Lock()
+	if r.running {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("restart is already in progress")
+	}
+	if r.token == "" || token != r.token || time.Now().After(r.expires) {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("invalid or expired restart token")
+	}
+	r.token = ""
+	r.running = true
+	r.mu.Unlock()
+
+	result := r.run(ctx)
+
+	r.mu.Lock()
+	r.running = false
+	r.last = result
+	r.mu.Unlock()
+
+	if result.Error != "" {
+		return result, errors.New(result.Error)
+	}
+	return result, nil
+}
+
+func (r *Restarter) run(ctx context.Context) *RestartResult {
+	result := &RestartResult{StartedAt: time.Now(), Command: r.command}
+	fmt.Printf("[Configurator] Restarting PostgreSQL: %s\n", r.command)
+
+	cmdCtx, cancel := context.WithTimeout(ctx, restartCommandTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(cmdCtx, "sh", "-c", r.command).CombinedOutput()
+	result.Output = string(output)
+	if err != nil {
+		result.Error = fmt.Sprintf("restart command failed: %v", err)
+		result.FinishedAt = time.Now()
+		return result
+	}
+
+	if err := r.waitReady(ctx); err != nil {
+		result.Error = err.Error()
+		result.FinishedAt = time.Now()
+		return result
+	}
+
+	pending, err := GetPendingRestart(r.pool)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Pending = pending
+	result.FinishedAt = time.Now()
+	return result
+}
+
+// waitReady ждет, пока база снова начнет отвечать (старые соединения пула отбрасываются при ошибках)
+func (r *Restarter) waitReady(ctx context.Context) error {
+	deadline := time.Now().Add(restartWaitTimeout)
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		var one int
+		err := r.pool.QueryRow(pingCtx, "SELECT 1").Scan(&one)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("database did not come back after restart: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
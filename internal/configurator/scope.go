@@ -0,0 +1,289 @@
+package configurator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// scopeDefault — значение, которое убирает параметр из области (RESET вместо SET)
+const scopeDefault = "DEFAULT"
+
+// Scope — на что действует изменение. Пустой Scope — весь кластер (ALTER SYSTEM),
+// только Database — ALTER DATABASE ... SET, только Role — ALTER ROLE ... SET,
+// оба поля — ALTER ROLE ... IN DATABASE ... SET.
+type Scope struct {
+	Database string `json:"database,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+// IsCluster — изменение для всего кластера
+func (s Scope) IsCluster() bool {
+	return s.Database == "" && s.Role == ""
+}
+
+func (s Scope) String() string {
+	switch {
+	case s.Role != "" && s.Database != "":
+		return fmt.Sprintf("role %s in database %s", s.Role, s.Database)
+	case s.Role != "":
+		return "role " + s.Role
+	case s.Database != "":
+		return "database " + s.Database
+	default:
+		return "cluster"
+	}
+}
+
+// target — объект ALTER для области
+func (s Scope) target() string {
+	switch {
+	case s.Role != "" && s.Database != "":
+		return fmt.Sprintf("ROLE %s IN DATABASE %s", pgx.Identifier{s.Role}.Sanitize(), pgx.Identifier{s.Database}.Sanitize())
+	case s.Role != "":
+		return "ROLE " + pgx.Identifier{s.Role}.Sanitize()
+	default:
+		return "DATABASE " + pgx.Identifier{s.Database}.Sanitize()
+	}
+}
+
+// ScopedSetting — параметр, заданный для базы и/или роли (pg_db_role_setting)
+type ScopedSetting struct {
+	Scope Scope  `json:"scope"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// isDefault — значение DEFAULT означает сброс параметра
+func isDefault(value string) bool {
+	return strings.EqualFold(strings.TrimSpace(value), scopeDefault)
+}
+
+// settableInScope — можно ли задать параметр через ALTER DATABASE / ALTER ROLE.
+// Такие параметры применяются при открытии сессии, поэтому подходят только
+// context = user и superuser (postmaster, sighup и прочие — только для всего кластера).
+func settableInScope(s pgSetting) bool {
+	return s.context == "user" || s.context == "superuser"
+}
+
+// alterScopeSQL собирает ALTER DATABASE / ALTER ROLE ... SET (или RESET для DEFAULT)
+func alterScopeSQL(scope Scope, key, value string) string {
+	if isDefault(value) {
+		return fmt.Sprintf("ALTER %s RESET %s", scope.target(), pgx.Identifier{key}.Sanitize())
+	}
+	return fmt.Sprintf("ALTER %s SET %s = %s", scope.target(), pgx.Identifier{key}.Sanitize(), quoteLiteral(value))
+}
+
+// validateScope проверяет, что база и роль существуют и все параметры можно задать в области
+func validateScope(ctx context.Context, pool *pgxpool.Pool, scope Scope, keys []string, settings map[string]pgSetting) error {
+	var errs []error
+	if scope.Database != "" {
+		var exists bool
+		if err := pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", scope.Database).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to check database: %w", err)
+		}
+		if !exists {
+			errs = append(errs, fmt.Errorf("database %q does not exist", scope.Database))
+		}
+	}
+	if scope.Role != "" {
+		var exists bool
+		if err := pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", scope.Role).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to check role: %w", err)
+		}
+		if !exists {
+			errs = append(errs, fmt.Errorf("role %q does not exist", scope.Role))
+		}
+	}
+	for _, key := range keys {
+		if s, ok := settings[key]; ok && !settableInScope(s) {
+			errs = append(errs, fmt.Errorf("%s: cannot be set per database or role (context = %s)", key, s.context))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// scopedValues читает значения параметров, заданные ровно в этой области.
+// Параметра нет в результате — в области он не задан и действует значение кластера.
+func scopedValues(ctx context.Context, pool *pgxpool.Pool, scope Scope, keys []string) (map[string]string, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT split_part(cfg, '=', 1), substr(cfg, strpos(cfg, '=') + 1)
+		FROM pg_db_role_setting s
+		CROSS JOIN LATERAL unnest(s.setconfig) AS cfg
+		WHERE s.setdatabase = COALESCE((SELECT oid FROM pg_database WHERE datname = $1), 0)
+		  AND s.setrole = COALESCE((SELECT oid FROM pg_roles WHERE rolname = $2), 0)
+		  AND split_part(cfg, '=', 1) = ANY($3)
+	`, scope.Database, scope.Role, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pg_db_role_setting: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[string]string, len(keys))
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan pg_db_role_setting: %w", err)
+		}
+		values[name] = value
+	}
+	return values, rows.Err()
+}
+
+// applyScoped записывает параметры в область через ALTER DATABASE / ALTER ROLE.
+// Значения начинают действовать в новых сессиях, pg_reload_conf() не нужен.
+// Previous хранит значения области ("DEFAULT" — параметр не был задан),
+// поэтому откат возвращает именно область, а не значения кластера.
+func applyScoped(ctx context.Context, pool *pgxpool.Pool, keys []string, configMap map[string]string, before map[string]pgSetting, meta ChangeMeta) (*ChangeSet, error) {
+	scoped, err := scopedValues(ctx, pool, meta.Scope, keys)
+	if err != nil {
+		return nil, err
+	}
+	previous := make(map[string]string, len(keys))
+	for _, key := range keys {
+		previous[key] = scopeDefault
+		if value, ok := scoped[key]; ok {
+			previous[key] = value
+		}
+	}
+
+	var applied []string
+	for _, key := range keys {
+		if _, err := pool.Exec(ctx, alterScopeSQL(meta.Scope, key, configMap[key])); err != nil {
+			for _, done := range applied {
+				if _, err := pool.Exec(ctx, alterScopeSQL(meta.Scope, done, previous[done])); err != nil {
+					fmt.Printf("[Configurator] Warning: failed to restore %s for %s: %v\n", done, meta.Scope, err)
+				}
+			}
+			return nil, fmt.Errorf("failed to set %s for %s (already written parameters restored): %w", key, meta.Scope, err)
+		}
+		applied = append(applied, key)
+	}
+
+	params := make([]ParamResult, 0, len(keys))
+	for _, key := range keys {
+		r := ParamResult{Name: key, Value: configMap[key], Previous: previous[key], Context: before[key].context, Status: ParamNextSession}
+		if strings.EqualFold(strings.TrimSpace(configMap[key]), previous[key]) {
+			r.Status = ParamUnchanged
+		}
+		params = append(params, r)
+	}
+
+	cs := &ChangeSet{ChangeMeta: meta, Settings: configMap, Previous: previous, Params: params}
+	if err := recordChange(ctx, pool, cs); err != nil {
+		fmt.Printf("[Configurator] Warning: %v\n", err)
+	}
+	return cs, nil
+}
+
+// ScopeSettings оставляет из набора только параметры, которые можно задать для базы
+// или роли, и возвращает имена отброшенных. Нужен для пресетов и рекомендаций:
+// в них есть и серверные параметры (shared_buffers, max_wal_size...).
+func ScopeSettings(pool *pgxpool.Pool, settings map[string]string) (map[string]string, []string, error) {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	info, err := readSettings(context.Background(), pool, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kept := make(map[string]string, len(settings))
+	var skipped []string
+	for key, value := range settings {
+		if s, ok := info[key]; ok && settableInScope(s) {
+			kept[key] = value
+		} else {
+			skipped = append(skipped, key)
+		}
+	}
+	sort.Strings(skipped)
+	return kept, skipped, nil
+}
+
+// applyInScope применяет набор параметров; для базы/роли серверные параметры
+// отбрасываются и попадают в ChangeSet.Skipped
+func applyInScope(pool *pgxpool.Pool, settings map[string]string, meta ChangeMeta) (*ChangeSet, error) {
+	if meta.Scope.IsCluster() {
+		return ApplyCustomConfig(pool, settings, meta)
+	}
+	kept, skipped, err := ScopeSettings(pool, settings)
+	if err != nil {
+		return nil, err
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("%w: none of the parameters can be set for %s", ErrInvalidConfig, meta.Scope)
+	}
+	cs, err := ApplyCustomConfig(pool, kept, meta)
+	if err != nil {
+		return nil, err
+	}
+	cs.Skipped = skipped
+	return cs, nil
+}
+
+// scopePlanItem пересчитывает пункт плана для области: сравнение идет со значением,
+// заданным в области (если его нет — со значением кластера, которое и действует в сессиях)
+func scopePlanItem(item PlanItem, s pgSetting, scoped map[string]string, scope Scope) PlanItem {
+	if item.Status == PlanRejected {
+		return item
+	}
+	if !settableInScope(s) {
+		item.Status, item.Reason = PlanRejected, fmt.Sprintf("cannot be set per database or role (context = %s)", s.context)
+		return item
+	}
+
+	value, set := scoped[item.Name]
+	item.Reason = ""
+	switch {
+	case isDefault(item.Requested) && !set:
+		item.Status = PlanEqual
+	case isDefault(item.Requested):
+		item.Current, item.Status = value, PlanChange
+	case set:
+		item.Current = value
+		s.setting = value
+		if equal, err := sameValue(s, item.Requested); err == nil && equal {
+			item.Status = PlanEqual
+		} else {
+			item.Status = PlanChange
+		}
+	}
+	if item.Status == PlanChange {
+		item.Reason = "applies to new sessions in " + scope.String()
+	}
+	return item
+}
+
+// GetScopedSettings возвращает все параметры, заданные для баз и ролей
+func GetScopedSettings(pool *pgxpool.Pool) ([]ScopedSetting, error) {
+	rows, err := pool.Query(context.Background(), `
+		SELECT COALESCE(d.datname, ''), COALESCE(r.rolname, ''),
+		       split_part(cfg, '=', 1), substr(cfg, strpos(cfg, '=') + 1)
+		FROM pg_db_role_setting s
+		LEFT JOIN pg_database d ON d.oid = s.setdatabase
+		LEFT JOIN pg_roles r ON r.oid = s.setrole
+		CROSS JOIN LATERAL unnest(s.setconfig) AS cfg
+		ORDER BY 1, 2, 3
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pg_db_role_setting: %w", err)
+	}
+	defer rows.Close()
+
+	settings := []ScopedSetting{}
+	for rows.Next() {
+		var ss ScopedSetting
+		if err := rows.Scan(&ss.Scope.Database, &ss.Scope.Role, &ss.Name, &ss.Value); err != nil {
+			return nil, fmt.Errorf("failed to scan pg_db_role_setting: %w", err)
+		}
+		settings = append(settings, ss)
+	}
+	return settings, rows.Err()
+}
@@ -0,0 +1,48 @@
+package configurator
+
+import "fmt"
+
+const (
+	mb int64 = 1 << 20
+	gb int64 = 1 << 30
+)
+
+// workMem делит память, оставшуюся после shared_buffers, между активными запросами:
+// каждый запрос может держать несколько сортировок/хешей (×3), а параллельный план —
+// еще по одной на воркер. Результат ограничен снизу 4MB и сверху limit.
+func workMem(ram, sharedBuffers, activeQueries int64, workers int, limit int64) int64 {
+	if activeQueries < 1 {
+		activeQueries = 1
+	}
+	perQuery := (ram - sharedBuffers) / (activeQueries * 3) / int64(workers+1)
+	return clampBytes(perQuery, 4*mb, limit)
+}
+
+// parallelWorkers — воркеров на Gather: cores/divisor, но не больше limit
+func parallelWorkers(cores, divisor, limit int) int {
+	return min(max(cores/divisor, 0), limit)
+}
+
+// withStorage добавляет параметры планировщика под тип дисков
+func withStorage(settings map[string]string, r Resources) map[string]string {
+	if r.Storage == "hdd" {
+		settings["random_page_cost"] = "4"
+		settings["effective_io_concurrency"] = "2"
+	} else {
+		settings["random_page_cost"] = "1.1"
+		settings["effective_io_concurrency"] = "200"
+	}
+	return settings
+}
+
+func clampBytes(v, lo, hi int64) int64 {
+	return min(max(v, lo), hi)
+}
+
+// formatBytes — значение для postgresql.conf: GB, если делится нацело, иначе MB
+func formatBytes(v int64) string {
+	if v >= gb && v%gb == 0 {
+		return fmt.Sprintf("%dGB", v/gb)
+	}
+	return fmt.Sprintf("%dMB", v/mb)
+}
@@ -0,0 +1,340 @@
+package configurator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	// ErrPresetNotFound — пользовательского пресета с таким именем нет
+	ErrPresetNotFound = errors.New("preset not found")
+	// ErrPresetExists — пользовательский пресет с таким именем уже есть
+	ErrPresetExists = errors.New("preset already exists")
+	// ErrPresetInUse — на пресет ссылаются (например, профили классификатора)
+	ErrPresetInUse = errors.New("preset is in use")
+)
+
+var presetNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// Preset — пресет для API: встроенный (считается от ресурсов) или пользовательский
+// из profile_metrics.presets. Пользовательский пресет с именем встроенного перекрывает его.
+type Preset struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Settings    map[string]string `json:"settings"`
+	MLProfiles  []string          `json:"ml_profiles"` // профили ML-сервиса, которые ведут на этот пресет
+	Builtin     bool              `json:"builtin"`
+	Version     int               `json:"version,omitempty"`
+	UpdatedAt   *time.Time        `json:"updated_at,omitempty"`
+	UpdatedBy   string            `json:"updated_by,omitempty"`
+}
+
+// userPresets — кэш profile_metrics.presets. GetSettingsForPreset вызывается
+// на каждом диагнозе, поэтому в БД ходим только при изменениях.
+var userPresets = struct {
+	mu      sync.RWMutex
+	presets map[string]Preset
+}{presets: map[string]Preset{}}
+
+// LoadPresets перечитывает пользовательские пресеты из БД в кэш
+func LoadPresets(pool *pgxpool.Pool) error {
+	rows, err := pool.Query(context.Background(), `
+		SELECT name, description, settings, ml_profiles, version, updated_at, updated_by
+		FROM profile_metrics.presets
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query presets: %w", err)
+	}
+	defer rows.Close()
+
+	presets := make(map[string]Preset)
+	for rows.Next() {
+		p, err := scanPreset(rows)
+		if err != nil {
+			return err
+		}
+		presets[p.Name] = p
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read presets: %w", err)
+	}
+
+	userPresets.mu.Lock()
+	userPresets.presets = presets
+	userPresets.mu.Unlock()
+	return nil
+}
+
+func userPreset(name string) (Preset, bool) {
+	userPresets.mu.RLock()
+	defer userPresets.mu.RUnlock()
+	p, ok := userPresets.presets[name]
+	return p, ok
+}
+
+// ListPresets возвращает все пресеты: встроенные (если не перекрыты) и пользовательские
+func ListPresets() []Preset {
+	names := PresetNames()
+	presets := make([]Preset, 0, len(names))
+	for _, name := range names {
+		if p, ok := GetPreset(name); ok {
+			presets = append(presets, p)
+		}
+	}
+	return presets
+}
+
+// GetPreset возвращает пресет с уже рассчитанными настройками
+func GetPreset(name string) (Preset, bool) {
+	if p, ok := userPreset(name); ok {
+		p.Settings = copySettings(p.Settings)
+		return p, true
+	}
+	b, ok := builtinPreset(name)
+	if !ok {
+		return Preset{}, false
+	}
+	return Preset{
+		Name:        b.name,
+		Description: b.description,
+		Settings:    SizePreset(name, CurrentResources()),
+		MLProfiles:  b.mlProfiles,
+		Builtin:     true,
+	}, true
+}
+
+// GetPresetVersion возвращает сохраненную версию пользовательского пресета
+func GetPresetVersion(pool *pgxpool.Pool, name string, version int) (*Preset, error) {
+	row := pool.QueryRow(context.Background(), `
+		SELECT name, description, settings, ml_profiles, version, updated_at, updated_by
+		FROM profile_metrics.preset_versions
+		WHERE name = $1 AND version = $2
+	`, name, version)
+	p, err := scanPreset(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s version %d", ErrPresetNotFound, name, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// PresetForMLProfile — в какой пресет переводится профиль ML-сервиса.
+// Пользовательские пресеты имеют приоритет над встроенным соответствием.
+func PresetForMLProfile(mlProfile string) (string, bool) {
+	userPresets.mu.RLock()
+	var matches []string
+	for name, p := range userPresets.presets {
+		for _, ml := range p.MLProfiles {
+			if ml == mlProfile {
+				matches = append(matches, name)
+			}
+		}
+	}
+	userPresets.mu.RUnlock()
+	if len(matches) > 0 {
+		sort.Strings(matches)
+		return matches[0], true
+	}
+
+	for _, b := range builtinPresets {
+		for _, ml := range b.mlProfiles {
+			if ml == mlProfile {
+				return b.name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// CreatePreset сохраняет новый пользовательский пресет (в том числе перекрывающий встроенный)
+func CreatePreset(pool *pgxpool.Pool, p Preset, actor string) (*Preset, error) {
+	if err := validatePreset(pool, p); err != nil {
+		return nil, err
+	}
+	if _, ok := userPreset(p.Name); ok {
+		return nil, fmt.Errorf("%w: %s", ErrPresetExists, p.Name)
+	}
+	return savePreset(pool, p, actor, false)
+}
+
+// UpdatePreset заменяет настройки пользовательского пресета и увеличивает его версию
+func UpdatePreset(pool *pgxpool.Pool, name string, p Preset, actor string) (*Preset, error) {
+	p.Name = name
+	if err := validatePreset(pool, p); err != nil {
+		return nil, err
+	}
+	if _, ok := userPreset(name); !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPresetNotFound, name)
+	}
+	return savePreset(pool, p, actor, true)
+}
+
+// presetUsers — кто ссылается на пресеты (см. SetPresetUsers)
+var presetUsers struct {
+	mu sync.RWMutex
+	fn func(name string) []string
+}
+
+// SetPresetUsers задает, как узнать, кто ссылается на пресет: fn возвращает
+// описания ссылок (например, профили правил классификатора). Пресет, на который
+// ссылаются, DeletePreset не удаляет. configurator не знает о классификаторе,
+// поэтому ссылки регистрирует сам analyzer.
+func SetPresetUsers(fn func(name string) []string) {
+	presetUsers.mu.Lock()
+	defer presetUsers.mu.Unlock()
+	presetUsers.fn = fn
+}
+
+// DeletePreset удаляет пользовательский пресет. История версий сохраняется;
+// если пресет перекрывал встроенный, снова действует встроенный. Пресет без
+// встроенного, на который ссылаются (см. SetPresetUsers), не удаляется — ErrPresetInUse.
+func DeletePreset(pool *pgxpool.Pool, name string) error {
+	if !IsBuiltinPreset(name) {
+		presetUsers.mu.RLock()
+		fn := presetUsers.fn
+		presetUsers.mu.RUnlock()
+		if fn != nil {
+			if users := fn(name); len(users) > 0 {
+				return fmt.Errorf("%w: %s is used by %s", ErrPresetInUse, name, strings.Join(users, ", "))
+			}
+		}
+	}
+
+	tag, err := pool.Exec(context.Background(), `DELETE FROM profile_metrics.presets WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete preset: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrPresetNotFound, name)
+	}
+
+	userPresets.mu.Lock()
+	delete(userPresets.presets, name)
+	userPresets.mu.Unlock()
+	return nil
+}
+
+func savePreset(pool *pgxpool.Pool, p Preset, actor string, update bool) (*Preset, error) {
+	ctx := context.Background()
+	if p.MLProfiles == nil {
+		p.MLProfiles = []string{}
+	}
+	settings, err := json.Marshal(p.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal settings: %w", err)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Новый пресет: номер версии продолжается и после удаления. Две одновременные
+	// вставки разводит ON CONFLICT: вторая дождется первой и ничего не вставит.
+	// Обновление берет номер из самой строки: UPDATE блокирует ее, так что
+	// параллельное обновление дождется коммита и получит следующий номер.
+	query := `
+		INSERT INTO profile_metrics.presets (name, description, settings, ml_profiles, version, updated_by)
+		SELECT $1::text, $2::text, $3::jsonb, $4::text[], COALESCE(MAX(version), 0) + 1, $5::text
+		FROM profile_metrics.preset_versions WHERE name = $1
+		ON CONFLICT (name) DO NOTHING
+		RETURNING name, description, settings, ml_profiles, version, updated_at, updated_by`
+	if update {
+		query = `
+		UPDATE profile_metrics.presets
+		SET description = $2, settings = $3, ml_profiles = $4, version = presets.version + 1, updated_by = $5, updated_at = NOW()
+		WHERE name = $1
+		RETURNING name, description, settings, ml_profiles, version, updated_at, updated_by`
+	}
+	saved, err := scanPreset(tx.QueryRow(ctx, query, p.Name, p.Description, settings, p.MLProfiles, actor))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if update {
+			return nil, fmt.Errorf("%w: %s", ErrPresetNotFound, p.Name)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrPresetExists, p.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO profile_metrics.preset_versions (name, version, description, settings, ml_profiles, updated_at, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, saved.Name, saved.Version, saved.Description, settings, saved.MLProfiles, saved.UpdatedAt, saved.UpdatedBy); err != nil {
+		return nil, fmt.Errorf("failed to save preset version: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit preset: %w", err)
+	}
+
+	userPresets.mu.Lock()
+	userPresets.presets[saved.Name] = saved
+	userPresets.mu.Unlock()
+	return &saved, nil
+}
+
+// validatePreset проверяет имя и настройки пресета по pg_settings так же, как ApplyCustomConfig
+func validatePreset(pool *pgxpool.Pool, p Preset) error {
+	if !presetNameRe.MatchString(p.Name) {
+		return fmt.Errorf("%w: preset name must match %s", ErrInvalidConfig, presetNameRe)
+	}
+	if len(p.Settings) == 0 {
+		return fmt.Errorf("%w: preset %s has no settings", ErrInvalidConfig, p.Name)
+	}
+
+	keys := make([]string, 0, len(p.Settings))
+	for key := range p.Settings {
+		if !isValidKey(key) {
+			return fmt.Errorf("%w: invalid or forbidden parameter: %s", ErrInvalidConfig, key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	settings, err := readSettings(context.Background(), pool, keys)
+	if err != nil {
+		return err
+	}
+	if err := validateConfig(keys, p.Settings, settings); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	return nil
+}
+
+func scanPreset(row pgx.Row) (Preset, error) {
+	var p Preset
+	var settings []byte
+	var updatedAt time.Time
+	if err := row.Scan(&p.Name, &p.Description, &settings, &p.MLProfiles, &p.Version, &updatedAt, &p.UpdatedBy); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return p, err
+		}
+		return p, fmt.Errorf("failed to scan preset: %w", err)
+	}
+	if err := json.Unmarshal(settings, &p.Settings); err != nil {
+		return p, fmt.Errorf("failed to decode settings of preset %s: %w", p.Name, err)
+	}
+	p.UpdatedAt = &updatedAt
+	return p, nil
+}
+
+func copySettings(settings map[string]string) map[string]string {
+	out := make(map[string]string, len(settings))
+	for k, v := range settings {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package configurator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrInvalidConfig — набор параметров не прошел проверку, ничего не записано
+var ErrInvalidConfig = errors.New("invalid configuration, nothing applied")
+
+// validateValue проверяет значение по описанию параметра в pg_settings
+// (vartype, unit, min_val, max_val, enumvals) до того, как оно попадет в ALTER SYSTEM
+func validateValue(s pgSetting, value string) error {
+	if s.context == "internal" {
+		return fmt.Errorf("parameter cannot be changed (context = internal)")
+	}
+	if isDefault(value) {
+		return nil
+	}
+	value = strings.TrimSpace(value)
+
+	switch s.vartype {
+	case "integer", "real":
+		v, err := parseNumeric(value, s.unit)
+		if err != nil {
+			return err
+		}
+		if s.minVal != "" {
+			if min, err := parseNumeric(s.minVal, s.unit); err == nil && v < min {
+				return fmt.Errorf("value %q is below minimum %s%s", value, s.minVal, s.unit)
+			}
+		}
+		if s.maxVal != "" {
+			if max, err := parseNumeric(s.maxVal, s.unit); err == nil && v > max {
+				return fmt.Errorf("value %q is above maximum %s%s", value, s.maxVal, s.unit)
+			}
+		}
+	case "bool":
+		if _, ok := parseBool(value); !ok {
+			return fmt.Errorf("invalid boolean value %q", value)
+		}
+	case "enum":
+		for _, allowed := range s.enumVals {
+			if strings.EqualFold(value, allowed) {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid value %q (allowed: %s)", value, strings.Join(s.enumVals, ", "))
+	}
+	return nil
+}
+
+// validateConfig проверяет весь набор параметров целиком и возвращает все ошибки сразу
+func validateConfig(keys []string, configMap map[string]string, settings map[string]pgSetting) error {
+	var errs []error
+	for _, key := range keys {
+		s, ok := settings[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown parameter", key))
+			continue
+		}
+		if err := validateValue(s, configMap[key]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		if err := catalogueIndex[key].checkSafeRange(s, configMap[key]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// alterSystemSQL собирает ALTER SYSTEM SET с экранированным именем и литералом
+// (DEFAULT — ALTER SYSTEM RESET)
+func alterSystemSQL(key, value string) string {
+	if isDefault(value) {
+		return fmt.Sprintf("ALTER SYSTEM RESET %s", pgx.Identifier{key}.Sanitize())
+	}
+	return fmt.Sprintf("ALTER SYSTEM SET %s = %s", pgx.Identifier{key}.Sanitize(), quoteLiteral(value))
+}
+
+// quoteLiteral экранирует строковый литерал SQL (standard_conforming_strings = on)
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// autoConfValues читает значения параметров из postgresql.auto.conf — именно их
+// нужно вернуть при откате частично записанного набора. Параметра нет в результате —
+// значит, до изменения его не было в auto.conf и откат делается через RESET.
+func autoConfValues(ctx context.Context, pool *pgxpool.Pool, keys []string) (map[string]string, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT name, setting
+		FROM pg_file_settings
+		WHERE name = ANY($1) AND sourcefile LIKE '%postgresql.auto.conf'
+		ORDER BY seqno
+	`, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pg_file_settings: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[string]string, len(keys))
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan pg_file_settings: %w", err)
+		}
+		values[name] = value // при повторах побеждает последняя строка, как и в самом PostgreSQL
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,84 @@
+package configurator
+
+import "testing"
+
+func TestValidateValue(t *testing.T) {
+	// Описания параметров в том виде, в каком их отдает pg_settings (PostgreSQL 16)
+	sharedBuffers := pgSetting{vartype: "integer", unit: "8kB", context: "postmaster", minVal: "16", maxVal: "1073741823"}
+	workMem := pgSetting{vartype: "integer", unit: "kB", context: "user", minVal: "64", maxVal: "2147483647"}
+	tempFileLimit := pgSetting{vartype: "integer", unit: "kB", context: "superuser", minVal: "-1", maxVal: "2147483647"}
+	deadlockTimeout := pgSetting{vartype: "integer", unit: "ms", context: "superuser", minVal: "1", maxVal: "2147483647"}
+	vacuumCostDelay := pgSetting{vartype: "real", unit: "ms", context: "sighup", minVal: "-1", maxVal: "100"}
+	checkpointTimeout := pgSetting{vartype: "integer", unit: "s", context: "sighup", minVal: "30", maxVal: "86400"}
+	logRotationAge := pgSetting{vartype: "integer", unit: "min", context: "sighup", minVal: "0", maxVal: "35791394"}
+	pageCost := pgSetting{vartype: "real", context: "user", minVal: "0", maxVal: "1.79769e+308"}
+	jit := pgSetting{vartype: "bool", context: "user"}
+	syncCommit := pgSetting{vartype: "enum", context: "user", enumVals: []string{"local", "remote_write", "remote_apply", "on", "off"}}
+	blockSize := pgSetting{vartype: "integer", context: "internal", minVal: "8192", maxVal: "8192"}
+
+	tests := []struct {
+		name    string
+		s       pgSetting
+		value   string
+		wantErr bool
+	}{
+		// Пределы в 8kB-страницах: 16 страниц = 128kB
+		{"shared_buffers 128MB", sharedBuffers, "128MB", false},
+		{"shared_buffers raw pages", sharedBuffers, "16384", false},
+		{"shared_buffers at minimum", sharedBuffers, "128kB", false},
+		{"shared_buffers below minimum", sharedBuffers, "64kB", true},
+		{"shared_buffers below minimum in pages", sharedBuffers, "8", true},
+		{"shared_buffers above maximum", sharedBuffers, "9TB", true},
+		{"shared_buffers wrong unit", sharedBuffers, "10ms", true},
+
+		{"work_mem 4MB", workMem, "4MB", false},
+		{"work_mem below 64kB", workMem, "32kB", true},
+		{"work_mem -1 is not a sentinel", workMem, "-1", true},
+
+		// -1 — «без ограничения» / «как у vacuum_cost_delay»
+		{"temp_file_limit -1", tempFileLimit, "-1", false},
+		{"temp_file_limit -2", tempFileLimit, "-2", true},
+		{"temp_file_limit 10GB", tempFileLimit, "10GB", false},
+		{"autovacuum cost delay -1", vacuumCostDelay, "-1", false},
+		{"autovacuum cost delay 2ms", vacuumCostDelay, "2ms", false},
+		{"autovacuum cost delay 0.5", vacuumCostDelay, "0.5", false},
+		{"autovacuum cost delay above 100ms", vacuumCostDelay, "1s", true},
+
+		// Время: пределы в единицах параметра
+		{"deadlock_timeout 1s", deadlockTimeout, "1s", false},
+		{"deadlock_timeout 0", deadlockTimeout, "0", true},
+		{"checkpoint_timeout 30min", checkpointTimeout, "30min", false},
+		{"checkpoint_timeout 29s", checkpointTimeout, "29s", true},
+		{"checkpoint_timeout 2d", checkpointTimeout, "2d", true},
+		{"checkpoint_timeout in minutes", checkpointTimeout, "1440min", false},
+		{"log_rotation_age 1d", logRotationAge, "1d", false},
+		{"log_rotation_age in seconds", logRotationAge, "30s", false},
+		{"log_rotation_age negative", logRotationAge, "-1", true},
+
+		{"random_page_cost 1.1", pageCost, "1.1", false},
+		{"random_page_cost negative", pageCost, "-1", true},
+		{"random_page_cost with unit", pageCost, "1MB", true},
+
+		{"bool on", jit, "on", false},
+		{"bool FALSE", jit, "FALSE", false},
+		{"bool 1", jit, "1", false},
+		{"bool garbage", jit, "maybe", true},
+
+		{"enum", syncCommit, "remote_apply", false},
+		{"enum case-insensitive", syncCommit, "OFF", false},
+		{"enum unknown", syncCommit, "sometimes", true},
+
+		{"DEFAULT resets", workMem, "DEFAULT", false},
+		{"default with spaces", sharedBuffers, " default ", false},
+		{"internal parameter", blockSize, "8192", true},
+		{"internal parameter default", blockSize, "DEFAULT", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateValue(tt.s, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateValue(%q) = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package dataset
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/lypolix/pg_load_profile/internal/analyzer"
+	"github.com/lypolix/pg_load_profile/internal/client"
+	"github.com/lypolix/pg_load_profile/internal/storage"
+)
+
+// Форматы выгрузки
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// ErrInvalidFormat — формат не csv и не jsonl
+var ErrInvalidFormat = errors.New("unsupported dataset format (use csv or jsonl)")
+
+// Columns — колонки CSV: время, признаки модели в порядке feature_columns
+// (ml/model_info.json), затем разметка. load_scenario — целевая переменная.
+var Columns = []string{
+	"timestamp",
+	"db_time_total", "db_time_committed", "cpu_time", "io_time", "lock_time",
+	"cpu_percent", "io_percent", "lock_percent",
+	"tps", "qps", "avg_query_latency_ms", "rollback_rate",
+	"total_commits", "total_rollbacks", "total_calls", "active_config",
+	"load_scenario", "expected_profile", "phase", "run_id",
+}
+
+// Row — размеченная строка датасета: признаки ровно в схеме MLMetrics, которую
+// принимает /predict, плюс ground truth на момент диагноза
+type Row struct {
+	Timestamp time.Time `json:"timestamp"`
+	client.MLMetrics
+	LoadScenario    string `json:"load_scenario"`
+	ExpectedProfile string `json:"expected_profile"`
+	Phase           string `json:"phase"`
+	RunID           int64  `json:"run_id"` // прогон нагрузки: строки одного прогона не стоит делить между train и test
+}
+
+// Filter — какие записи истории диагнозов выгружать
+type Filter struct {
+	From      time.Time
+	To        time.Time
+	Scenarios []string // пусто — все сценарии
+}
+
+// Export пишет в w размеченные строки из истории диагнозов в хронологическом
+// порядке. Выгружаются только диагнозы, снятые во время прогона нагрузки (с run_id):
+// без нагрузки и после ее окончания метрики не соответствуют метке сценария.
+// Первое окно диагноза после начала прогона и каждой фазы тоже пропускается:
+// оно посчитано в основном по прошлой нагрузке или простою.
+// Возвращает число выгруженных строк.
+func Export(ctx context.Context, pool *pgxpool.Pool, f Filter, format string, w io.Writer) (int, error) {
+	var write func(Row) error
+	var flush func() error
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(Columns); err != nil {
+			return 0, fmt.Errorf("failed to write csv header: %w", err)
+		}
+		write = func(row Row) error { return cw.Write(csvRecord(row)) }
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		write = func(row Row) error { return enc.Encode(row) }
+		flush = func() error { return nil }
+	default:
+		return 0, ErrInvalidFormat
+	}
+
+	n := 0
+	err := storage.ScanHistory(ctx, pool, storage.HistoryFilter{From: f.From, To: f.To, Scenarios: f.Scenarios, RunsOnly: true},
+		func(rec storage.DiagnosisRecord) error {
+			if rec.GroundTruth == nil || rec.GroundTruth.LoadScenario == "" || warmingUp(rec) {
+				return nil
+			}
+			row := Row{
+				Timestamp:       rec.RecordedAt,
+				MLMetrics:       client.NewMLMetrics(rec.Diagnosis.Metrics, rec.GroundTruth.ActiveConfig),
+				LoadScenario:    rec.GroundTruth.LoadScenario,
+				ExpectedProfile: rec.GroundTruth.ExpectedProfile,
+				Phase:           rec.GroundTruth.Phase,
+				RunID:           rec.GroundTruth.RunID,
+			}
+			if err := write(row); err != nil {
+				return fmt.Errorf("failed to write dataset row: %w", err)
+			}
+			n++
+			return nil
+		})
+	if err != nil {
+		return n, err
+	}
+	if err := flush(); err != nil {
+		return n, fmt.Errorf("failed to write dataset: %w", err)
+	}
+	return n, nil
+}
+
+// warmingUp — диагноз снят раньше, чем через DiagnosisWindow после начала прогона
+// или фазы, и его окно захватывает то, что было до них
+func warmingUp(rec storage.DiagnosisRecord) bool {
+	start := rec.GroundTruth.StartTime
+	if ps := rec.GroundTruth.PhaseStart; ps != nil && ps.After(start) {
+		start = *ps
+	}
+	return rec.RecordedAt.Sub(start) < analyzer.DiagnosisWindow
+}
+
+func csvRecord(r Row) []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	i := func(v int64) string { return strconv.FormatInt(v, 10) }
+	m := r.MLMetrics
+	return []string{
+		r.Timestamp.UTC().Format(time.RFC3339),
+		f(m.DBTimeTotal), f(m.DBTimeCommitted), f(m.CPUTime), f(m.IOTime), f(m.LockTime),
+		f(m.CPUPercent), f(m.IOPercent), f(m.LockPercent),
+		f(m.TPS), f(m.QPS), f(m.AvgQueryLatencyMS), f(m.RollbackRate),
+		i(m.TotalCommits), i(m.TotalRollbacks), i(m.TotalCalls), m.ActiveConfig,
+		r.LoadScenario, r.ExpectedProfile, r.Phase, i(r.RunID),
+	}
+}
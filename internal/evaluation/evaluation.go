@@ -0,0 +1,453 @@
+package evaluation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/lypolix/pg_load_profile/internal/analyzer"
+	"github.com/lypolix/pg_load_profile/internal/client"
+	"github.com/lypolix/pg_load_profile/internal/configurator"
+	"github.com/lypolix/pg_load_profile/internal/generator"
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// Статусы оценки
+const (
+	StatusRunning   = "running"
+	StatusFinished  = "finished"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+var (
+	// ErrEvaluationRunning — оценка уже идет
+	ErrEvaluationRunning = errors.New("evaluation is already running")
+	// ErrNotRunning — останавливать нечего
+	ErrNotRunning = errors.New("evaluation is not running")
+	// ErrInvalidConfig — неизвестный сценарий или пресет, недопустимые интервалы
+	ErrInvalidConfig = errors.New("invalid evaluation config")
+)
+
+// Config — что и как оценивать. Пустые поля — значения по умолчанию.
+type Config struct {
+	Scenarios []string      `json:"scenarios"` // по умолчанию — все сценарии с ожидаемым профилем
+	Presets   []string      `json:"presets"`   // по умолчанию — все пресеты
+	Duration  time.Duration `json:"duration"`  // длительность каждого прогона; 0 — из сценария
+	Interval  time.Duration `json:"interval"`  // как часто спрашивать классификаторы
+	Warmup    time.Duration `json:"warmup"`    // начало сегмента, которое не идет в матрицу ошибок
+	OutputDir string        `json:"output_dir"`
+}
+
+// OutputDirFromEnv — куда сохранять отчеты (EVALUATION_DIR, по умолчанию ./evaluations)
+func OutputDirFromEnv() string {
+	if dir := os.Getenv("EVALUATION_DIR"); dir != "" {
+		return dir
+	}
+	return "./evaluations"
+}
+
+// Sample — один опрос обоих классификаторов во время прогона
+type Sample struct {
+	Time            time.Time `json:"time"`
+	RunID           int64     `json:"run_id"`
+	Scenario        string    `json:"scenario"`
+	Preset          string    `json:"preset"`
+	Phase           string    `json:"phase,omitempty"`
+	Expected        string    `json:"expected"`
+	Elapsed         float64   `json:"elapsed_s"` // от начала сегмента (прогона или фазы)
+	Warmup          bool      `json:"warmup"`
+	Rules           string    `json:"rules"`
+	RulesConfidence float64   `json:"rules_confidence"`
+	ML              string    `json:"ml,omitempty"`
+	MLConfidence    float64   `json:"ml_confidence,omitempty"`
+	MLError         string    `json:"ml_error,omitempty"`
+}
+
+// Status — ход и итог оценки
+type Status struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Config     Config     `json:"config"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Total      int        `json:"total"`     // прогонов всего (сценарии × пресеты)
+	Completed  int        `json:"completed"` // прогонов завершено
+	Current    string     `json:"current,omitempty"`
+	Errors     []string   `json:"errors,omitempty"` // упавшие прогоны; оценка продолжается без них
+	Error      string     `json:"error,omitempty"`
+	Files      []string   `json:"files,omitempty"`
+	Report     *Report    `json:"report,omitempty"`
+	Samples    []Sample   `json:"samples,omitempty"`
+}
+
+// Evaluator прогоняет каждый сценарий под каждым пресетом и сравнивает ответы
+// классификатора на правилах и ML-сервиса с ожидаемым профилем сценария.
+// Одновременно идет одна оценка; нагрузку она запускает через тот же Manager,
+// поэтому ручной /load/start во время оценки получит 409. После оценки конфиг
+// откатывается к тому, что был до нее.
+type Evaluator struct {
+	mu    sync.Mutex
+	pool  *pgxpool.Pool
+	calc  *analyzer.Calculator
+	ml    *client.MLClient
+	loads *generator.Manager
+
+	// onConfig — уведомление о смене активного конфига: label для разметки и имя
+	// пресета (пустое после отката к конфигу, который был до оценки)
+	onConfig func(label, preset string)
+	onLoad   func(run generator.LoadRun, m *generator.Manifest) // уведомление о запуске нагрузки
+	// hold вызывается до запуска оценки (например, ставит автопилот на паузу, чтобы
+	// он не переключал пресеты посреди прогонов); release — после отката конфига
+	hold func() (release func())
+
+	status *Status
+	cancel context.CancelFunc
+}
+
+func New(pool *pgxpool.Pool, calc *analyzer.Calculator, ml *client.MLClient, loads *generator.Manager,
+	onConfig func(label, preset string), onLoad func(run generator.LoadRun, m *generator.Manifest), hold func() (release func())) *Evaluator {
+	return &Evaluator{pool: pool, calc: calc, ml: ml, loads: loads, onConfig: onConfig, onLoad: onLoad, hold: hold}
+}
+
+// Start проверяет конфиг и запускает оценку в фоне
+func (e *Evaluator) Start(cfg Config) (Status, error) {
+	cfg, params, err := resolveConfig(cfg)
+	if err != nil {
+		return Status{}, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.status != nil && e.status.Status == StatusRunning {
+		return Status{}, ErrEvaluationRunning
+	}
+
+	now := time.Now()
+	e.status = &Status{
+		ID:        now.Format("20060102_150405"),
+		Status:    StatusRunning,
+		Config:    cfg,
+		StartedAt: now,
+		Total:     len(cfg.Scenarios) * len(cfg.Presets),
+	}
+	release := func() {}
+	if e.hold != nil {
+		release = e.hold()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	go func() {
+		defer release()
+		e.run(ctx, cfg, params)
+	}()
+	return e.snapshot(), nil
+}
+
+// Stop прерывает оценку; собранные сэмплы попадут в отчет
+func (e *Evaluator) Stop() (Status, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.status == nil || e.status.Status != StatusRunning {
+		return Status{}, ErrNotRunning
+	}
+	e.cancel()
+	return e.snapshot(), nil
+}
+
+// Status возвращает текущую или последнюю оценку (nil — оценок еще не было)
+func (e *Evaluator) Status() *Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.status == nil {
+		return nil
+	}
+	st := e.snapshot()
+	return &st
+}
+
+func (e *Evaluator) snapshot() Status {
+	st := *e.status
+	st.Errors = append([]string(nil), st.Errors...)
+	st.Samples = append([]Sample(nil), st.Samples...)
+	return st
+}
+
+// resolveConfig подставляет значения по умолчанию и заранее проверяет параметры
+// нагрузки каждого сценария, чтобы оценка не упала через час на середине
+func resolveConfig(cfg Config) (Config, map[string]models.LoadParams, error) {
+	if len(cfg.Scenarios) == 0 {
+		for _, m := range generator.Scenarios() {
+			if hasExpectedProfile(&m) {
+				cfg.Scenarios = append(cfg.Scenarios, m.Name)
+			}
+		}
+	}
+	if len(cfg.Presets) == 0 {
+		cfg.Presets = configurator.PresetNames()
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = analyzer.SampleInterval
+	}
+	if cfg.Warmup == 0 {
+		cfg.Warmup = analyzer.DiagnosisWindow
+	}
+	if cfg.OutputDir == "" {
+		cfg.OutputDir = OutputDirFromEnv()
+	}
+	switch {
+	case len(cfg.Scenarios) == 0:
+		return cfg, nil, fmt.Errorf("%w: no scenarios with an expected profile", ErrInvalidConfig)
+	case cfg.Interval < time.Second:
+		return cfg, nil, fmt.Errorf("%w: interval must be at least 1s", ErrInvalidConfig)
+	case cfg.Warmup < 0 || cfg.Duration < 0:
+		return cfg, nil, fmt.Errorf("%w: warmup and duration must not be negative", ErrInvalidConfig)
+	}
+
+	for _, preset := range cfg.Presets {
+		if configurator.GetSettingsForPreset(preset) == nil {
+			return cfg, nil, fmt.Errorf("%w: unknown preset %s", ErrInvalidConfig, preset)
+		}
+	}
+	params := make(map[string]models.LoadParams, len(cfg.Scenarios))
+	for _, name := range cfg.Scenarios {
+		m, ok := generator.LookupScenario(name)
+		if !ok {
+			return cfg, nil, fmt.Errorf("%w: unknown scenario %s", ErrInvalidConfig, name)
+		}
+		if !hasExpectedProfile(m) {
+			return cfg, nil, fmt.Errorf("%w: scenario %s has no expected_profile to compare with", ErrInvalidConfig, name)
+		}
+		var p models.LoadParams
+		if !m.OneOff() && !m.Composite() {
+			// Разовые и составные сценарии идут столько, сколько идут
+			p.DurationSeconds = int(cfg.Duration / time.Second)
+		}
+		resolved, err := generator.ResolveParams(context.Background(), name, p)
+		if err != nil {
+			return cfg, nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+		params[name] = resolved
+	}
+	return cfg, params, nil
+}
+
+func hasExpectedProfile(m *generator.Manifest) bool {
+	if m.ExpectedProfile != "" {
+		return true
+	}
+	for _, ph := range m.Phases {
+		if ph.ExpectedProfile != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Evaluator) run(ctx context.Context, cfg Config, params map[string]models.LoadParams) {
+	log.Printf("[EVALUATION] Started: %d scenarios x %d presets", len(cfg.Scenarios), len(cfg.Presets))
+
+	var runErr error
+	// Первое изменение конфига: по нему после оценки (в том числе прерванной
+	// или упавшей) возвращаемся к конфигу, который был до нее
+	var firstChange int64
+	// Пресет меняется реже сценария: рестарт-параметры и прогрев кэша не на каждый прогон
+loop:
+	for _, preset := range cfg.Presets {
+		id, err := e.applyPreset(preset)
+		if err != nil {
+			runErr = err
+			break
+		}
+		if firstChange == 0 {
+			firstChange = id
+		}
+		for _, scenario := range cfg.Scenarios {
+			if ctx.Err() != nil {
+				break loop
+			}
+			e.update(func(st *Status) { st.Current = scenario + " / " + preset })
+			if err := e.runCell(ctx, cfg, scenario, preset, params[scenario]); err != nil {
+				if errors.Is(err, generator.ErrRunInProgress) || ctx.Err() != nil {
+					runErr = err
+					break loop
+				}
+				e.update(func(st *Status) {
+					st.Errors = append(st.Errors, fmt.Sprintf("%s / %s: %v", scenario, preset, err))
+				})
+			}
+			e.update(func(st *Status) { st.Completed++ })
+		}
+	}
+	restoreErr := e.restoreConfig(firstChange)
+
+	e.mu.Lock()
+	st := e.status
+	finished := time.Now()
+	st.FinishedAt = &finished
+	st.Current = ""
+	switch {
+	case ctx.Err() != nil:
+		st.Status = StatusCancelled
+	case runErr != nil:
+		st.Status = StatusFailed
+		st.Error = runErr.Error()
+	default:
+		st.Status = StatusFinished
+	}
+	if restoreErr != nil {
+		st.Errors = append(st.Errors, restoreErr.Error())
+	}
+	report := BuildReport(st.Samples)
+	st.Report = &report
+	saved := e.snapshot()
+	e.mu.Unlock()
+
+	files, err := Save(filepath.Join(cfg.OutputDir, saved.ID), saved)
+	e.update(func(st *Status) {
+		st.Files = files
+		if err != nil {
+			st.Errors = append(st.Errors, fmt.Sprintf("failed to save report: %v", err))
+		}
+	})
+	e.cancel()
+	log.Printf("[EVALUATION] %s: %d samples, saved to %v", saved.Status, len(saved.Samples), files)
+}
+
+// applyPreset применяет пресет и возвращает id записанного изменения
+// (0 — изменение применилось, но в историю не попало)
+func (e *Evaluator) applyPreset(preset string) (int64, error) {
+	cs, err := configurator.ApplyPreset(e.pool, preset, configurator.ChangeMeta{
+		Actor:  "evaluation",
+		Reason: "classifier accuracy evaluation",
+		Source: configurator.SourceEvaluation,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to apply preset %s: %w", preset, err)
+	}
+	if e.onConfig != nil {
+		e.onConfig(preset, preset)
+	}
+	return cs.ID, nil
+}
+
+// restoreConfig откатывает все изменения оценки, начиная с id (0 — откатывать нечего)
+func (e *Evaluator) restoreConfig(id int64) error {
+	if id == 0 {
+		return nil
+	}
+	if _, err := configurator.Rollback(e.pool, id, configurator.ChangeMeta{
+		Actor:  "evaluation",
+		Reason: fmt.Sprintf("restore config after evaluation (rollback to state before change #%d)", id),
+	}); err != nil {
+		log.Printf("[EVALUATION] Failed to restore config: %v", err)
+		return fmt.Errorf("failed to restore config before change #%d: %w", id, err)
+	}
+	if e.onConfig != nil {
+		e.onConfig(fmt.Sprintf("ROLLBACK (#%d)", id), "")
+	}
+	return nil
+}
+
+// runCell запускает сценарий и, пока он идет, раз в Interval опрашивает классификаторы
+func (e *Evaluator) runCell(ctx context.Context, cfg Config, scenario, preset string, params models.LoadParams) error {
+	m, ok := generator.LookupScenario(scenario)
+	if !ok {
+		return fmt.Errorf("scenario %s not found", scenario)
+	}
+	run, err := e.loads.Start(scenario, params)
+	if err != nil {
+		return err
+	}
+	if e.onLoad != nil {
+		e.onLoad(run, m)
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	phase, segmentStart := "", time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			e.loads.Stop(run.ID)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		cur, err := e.loads.Get(run.ID)
+		if err != nil {
+			return err
+		}
+		if !cur.Active() {
+			if cur.Status == generator.RunFailed {
+				return fmt.Errorf("load run %d failed: %s", cur.ID, cur.Error)
+			}
+			return nil
+		}
+		if cur.StartedAt == nil {
+			continue
+		}
+		if segmentStart.Before(*cur.StartedAt) {
+			segmentStart = *cur.StartedAt
+		}
+		if cur.Phase != phase {
+			phase, segmentStart = cur.Phase, time.Now()
+		}
+
+		s, ok := e.sample(ctx, m, preset, cur, segmentStart)
+		if !ok || s.Expected == "" {
+			continue // нет метрик или у фазы нет ожидаемого профиля
+		}
+		s.Warmup = time.Since(segmentStart) < cfg.Warmup
+		e.update(func(st *Status) { st.Samples = append(st.Samples, s) })
+	}
+}
+
+func (e *Evaluator) sample(ctx context.Context, m *generator.Manifest, preset string, run generator.LoadRun, segmentStart time.Time) (Sample, bool) {
+	now := time.Now()
+	s := Sample{
+		Time:     now,
+		RunID:    run.ID,
+		Scenario: run.Scenario,
+		Preset:   preset,
+		Phase:    run.Phase,
+		Expected: m.ExpectedProfile,
+		Elapsed:  now.Sub(segmentStart).Seconds(),
+	}
+	for _, ph := range m.Phases {
+		if ph.Label == run.Phase {
+			s.Expected = ph.ExpectedProfile
+		}
+	}
+
+	metrics, err := e.calc.CalculateMetrics(ctx, analyzer.DiagnosisWindow)
+	if err != nil {
+		log.Printf("[EVALUATION] Failed to calculate metrics: %v", err)
+		return s, false
+	}
+	d := analyzer.ClassifyWorkload(metrics)
+	s.Rules, s.RulesConfidence = d.ProfileKey, d.ConfidenceScore
+
+	pred, err := e.ml.Predict(ctx, metrics, preset)
+	if err != nil {
+		s.MLError = err.Error()
+	} else {
+		// ML-сервис обучен на именах сценариев (oltp), правила — на ключах профилей (OLTP)
+		s.ML, s.MLConfidence = strings.ToUpper(pred.PredictedScenario), pred.Confidence
+	}
+	return s, true
+}
+
+func (e *Evaluator) update(fn func(st *Status)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fn(e.status)
+}
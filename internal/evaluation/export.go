@@ -0,0 +1,147 @@
+package evaluation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Save пишет оценку в dir: report.json (статус, отчет и сэмплы целиком) и CSV
+// для таблиц и ноутбуков — samples.csv, confusion.csv, classes.csv, segments.csv.
+// Возвращает пути записанных файлов.
+func Save(dir string, st Status) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create report dir: %w", err)
+	}
+	report := st.Report
+	if report == nil {
+		built := BuildReport(st.Samples)
+		report = &built
+	}
+	classifiers := make([]string, 0, len(report.Classifiers))
+	for name := range report.Classifiers {
+		classifiers = append(classifiers, name)
+	}
+	sort.Strings(classifiers)
+
+	var files []string
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal report: %w", err)
+	}
+	file := filepath.Join(dir, "report.json")
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write report: %w", err)
+	}
+	files = append(files, file)
+
+	tables := []struct {
+		name   string
+		header []string
+		rows   [][]string
+	}{
+		{"samples.csv", []string{"time", "run_id", "scenario", "preset", "phase", "expected", "elapsed_s", "warmup",
+			"rules", "rules_confidence", "ml", "ml_confidence", "ml_error"}, sampleRows(st.Samples)},
+		{"confusion.csv", []string{"classifier", "expected", "predicted", "count"}, confusionRows(report, classifiers)},
+		{"classes.csv", []string{"classifier", "label", "support", "predicted", "correct", "precision", "recall", "f1"},
+			classRows(report, classifiers)},
+		{"segments.csv", append([]string{"run_id", "scenario", "preset", "phase", "expected", "started_at", "samples"},
+			prefixed("time_to_correct_s_", classifiers)...), segmentRows(report, classifiers)},
+	}
+	for _, t := range tables {
+		file := filepath.Join(dir, t.name)
+		if err := writeCSV(file, t.header, t.rows); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func writeCSV(file string, header []string, rows [][]string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", file, err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write(header)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	return nil
+}
+
+func sampleRows(samples []Sample) [][]string {
+	rows := make([][]string, 0, len(samples))
+	for _, s := range samples {
+		rows = append(rows, []string{
+			s.Time.Format(time.RFC3339), strconv.FormatInt(s.RunID, 10), s.Scenario, s.Preset, s.Phase, s.Expected,
+			formatFloat(s.Elapsed), strconv.FormatBool(s.Warmup),
+			s.Rules, formatFloat(s.RulesConfidence), s.ML, formatFloat(s.MLConfidence), s.MLError,
+		})
+	}
+	return rows
+}
+
+func confusionRows(r *Report, classifiers []string) [][]string {
+	var rows [][]string
+	for _, name := range classifiers {
+		cr := r.Classifiers[name]
+		for _, expected := range cr.Labels {
+			for _, predicted := range cr.Labels {
+				if n := cr.Confusion[expected][predicted]; n > 0 {
+					rows = append(rows, []string{name, expected, predicted, strconv.Itoa(n)})
+				}
+			}
+		}
+	}
+	return rows
+}
+
+func classRows(r *Report, classifiers []string) [][]string {
+	var rows [][]string
+	for _, name := range classifiers {
+		for _, c := range r.Classifiers[name].Classes {
+			rows = append(rows, []string{name, c.Label, strconv.Itoa(c.Support), strconv.Itoa(c.Predicted),
+				strconv.Itoa(c.Correct), formatFloat(c.Precision), formatFloat(c.Recall), formatFloat(c.F1)})
+		}
+	}
+	return rows
+}
+
+func segmentRows(r *Report, classifiers []string) [][]string {
+	rows := make([][]string, 0, len(r.Segments))
+	for _, seg := range r.Segments {
+		row := []string{strconv.FormatInt(seg.RunID, 10), seg.Scenario, seg.Preset, seg.Phase, seg.Expected,
+			seg.StartedAt.Format(time.RFC3339), strconv.Itoa(seg.Samples)}
+		for _, name := range classifiers {
+			value := ""
+			if t := seg.TimeToCorrect[name]; t != nil {
+				value = formatFloat(*t)
+			}
+			row = append(row, value)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func prefixed(prefix string, names []string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = prefix + name
+	}
+	return out
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,165 @@
+package evaluation
+
+import (
+	"sort"
+	"time"
+)
+
+// Классификаторы, которые сравниваются
+const (
+	ClassifierRules = "rules"
+	ClassifierML    = "ml"
+)
+
+// Report — точность классификаторов по сэмплам оценки
+type Report struct {
+	Classifiers map[string]ClassifierReport `json:"classifiers"`
+	Segments    []Segment                   `json:"segments"`
+}
+
+// ClassifierReport — матрица ошибок, precision/recall по классам и время до
+// правильного ответа для одного классификатора. В матрицу идут только сэмплы
+// после прогрева (Sample.Warmup == false), во время до правильного ответа — все.
+type ClassifierReport struct {
+	Evaluated int                       `json:"evaluated"` // сэмплов в матрице
+	Failed    int                       `json:"failed"`    // сэмплов без ответа (ML-сервис недоступен)
+	Accuracy  float64                   `json:"accuracy"`
+	Labels    []string                  `json:"labels"`
+	Confusion map[string]map[string]int `json:"confusion"` // ожидаемый -> предсказанный -> число
+	Classes   []ClassMetrics            `json:"classes"`
+	// Среднее и медиана времени до первого правильного ответа по сегментам, где он был
+	MeanTimeToCorrect   *float64 `json:"mean_time_to_correct_s,omitempty"`
+	MedianTimeToCorrect *float64 `json:"median_time_to_correct_s,omitempty"`
+	NeverCorrect        int      `json:"never_correct"` // сегментов без единого правильного ответа
+}
+
+// ClassMetrics — precision/recall/F1 одного профиля
+type ClassMetrics struct {
+	Label     string  `json:"label"`
+	Support   int     `json:"support"`   // сэмплов с этим ожидаемым профилем
+	Predicted int     `json:"predicted"` // сэмплов, где классификатор ответил этим профилем
+	Correct   int     `json:"correct"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+}
+
+// Segment — отрезок с одним ожидаемым профилем: прогон или фаза составного сценария
+type Segment struct {
+	RunID     int64     `json:"run_id"`
+	Scenario  string    `json:"scenario"`
+	Preset    string    `json:"preset"`
+	Phase     string    `json:"phase,omitempty"`
+	Expected  string    `json:"expected"`
+	StartedAt time.Time `json:"started_at"` // время первого сэмпла
+	Samples   int       `json:"samples"`
+	// Секунд от начала сегмента до первого правильного ответа; nil — не было
+	TimeToCorrect map[string]*float64 `json:"time_to_correct_s"`
+}
+
+// BuildReport считает отчет по сэмплам в порядке их сбора
+func BuildReport(samples []Sample) Report {
+	report := Report{Classifiers: make(map[string]ClassifierReport), Segments: []Segment{}}
+	predictions := map[string]func(Sample) string{
+		ClassifierRules: func(s Sample) string { return s.Rules },
+		ClassifierML:    func(s Sample) string { return s.ML },
+	}
+
+	// Сегменты: подряд идущие сэмплы одного прогона и фазы
+	for _, s := range samples {
+		n := len(report.Segments)
+		if n == 0 || report.Segments[n-1].RunID != s.RunID || report.Segments[n-1].Phase != s.Phase {
+			report.Segments = append(report.Segments, Segment{
+				RunID:         s.RunID,
+				Scenario:      s.Scenario,
+				Preset:        s.Preset,
+				Phase:         s.Phase,
+				Expected:      s.Expected,
+				StartedAt:     s.Time,
+				TimeToCorrect: make(map[string]*float64),
+			})
+			n++
+		}
+		seg := &report.Segments[n-1]
+		seg.Samples++
+		for name, predict := range predictions {
+			if seg.TimeToCorrect[name] == nil && predict(s) == s.Expected {
+				elapsed := s.Elapsed
+				seg.TimeToCorrect[name] = &elapsed
+			}
+		}
+	}
+
+	for name, predict := range predictions {
+		cr := ClassifierReport{Confusion: make(map[string]map[string]int), Labels: []string{}, Classes: []ClassMetrics{}}
+		labels := make(map[string]bool)
+		for _, s := range samples {
+			predicted := predict(s)
+			if predicted == "" {
+				cr.Failed++
+				continue
+			}
+			if s.Warmup {
+				continue
+			}
+			if cr.Confusion[s.Expected] == nil {
+				cr.Confusion[s.Expected] = make(map[string]int)
+			}
+			cr.Confusion[s.Expected][predicted]++
+			labels[s.Expected], labels[predicted] = true, true
+			cr.Evaluated++
+		}
+		for label := range labels {
+			cr.Labels = append(cr.Labels, label)
+		}
+		sort.Strings(cr.Labels)
+
+		correct := 0
+		for _, label := range cr.Labels {
+			c := ClassMetrics{Label: label, Correct: cr.Confusion[label][label]}
+			for _, row := range cr.Confusion[label] {
+				c.Support += row
+			}
+			for _, row := range cr.Confusion {
+				c.Predicted += row[label]
+			}
+			if c.Predicted > 0 {
+				c.Precision = float64(c.Correct) / float64(c.Predicted)
+			}
+			if c.Support > 0 {
+				c.Recall = float64(c.Correct) / float64(c.Support)
+			}
+			if c.Precision+c.Recall > 0 {
+				c.F1 = 2 * c.Precision * c.Recall / (c.Precision + c.Recall)
+			}
+			correct += c.Correct
+			cr.Classes = append(cr.Classes, c)
+		}
+		if cr.Evaluated > 0 {
+			cr.Accuracy = float64(correct) / float64(cr.Evaluated)
+		}
+
+		var times []float64
+		for _, seg := range report.Segments {
+			if t := seg.TimeToCorrect[name]; t != nil {
+				times = append(times, *t)
+			} else {
+				cr.NeverCorrect++
+			}
+		}
+		if len(times) > 0 {
+			sort.Float64s(times)
+			sum := 0.0
+			for _, t := range times {
+				sum += t
+			}
+			mean, median := sum/float64(len(times)), times[len(times)/2]
+			if len(times)%2 == 0 {
+				median = (times[len(times)/2-1] + median) / 2
+			}
+			cr.MeanTimeToCorrect, cr.MedianTimeToCorrect = &mean, &median
+		}
+		report.Classifiers[name] = cr
+	}
+	return report
+}
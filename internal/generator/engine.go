@@ -0,0 +1,512 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// WeightedScript — скрипт и его доля в смеси транзакций (как -f script@weight у pgbench)
+type WeightedScript struct {
+	Script *Script
+	Weight int
+}
+
+// Workload — параметры прогона встроенного генератора нагрузки
+type Workload struct {
+	Scripts      []WeightedScript
+	Clients      int           // одновременных соединений
+	Duration     time.Duration // сколько длится прогон (если Transactions = 0)
+	Transactions int           // транзакций на клиента (как -t у pgbench); 0 — до конца Duration
+	ThinkTime    time.Duration // пауза клиента между транзакциями
+	Rate         float64       // целевой TPS на весь прогон (как -R у pgbench); 0 — без ограничения
+	Scale        int           // значение :scale в скриптах
+	Ramp         Ramp          // плавный рост и спад числа клиентов (только с Duration)
+}
+
+// Ramp — за RampUp клиенты подключаются по одному через равные промежутки,
+// за последние RampDown прогона так же по одному отключаются
+type Ramp struct {
+	Up   time.Duration
+	Down time.Duration
+}
+
+// window возвращает, когда клиент i из n начинает и заканчивает работу
+// (смещения от начала прогона длительностью d)
+func (r Ramp) window(i, n int, d time.Duration) (start, stop time.Duration) {
+	start = r.Up * time.Duration(i) / time.Duration(n)
+	stop = d - r.Down*time.Duration(n-1-i)/time.Duration(n)
+	return start, stop
+}
+
+// Result — итог прогона: пропускная способность, ошибки и задержки
+type Result struct {
+	Scenario        string            `json:"scenario"`
+	Driver          string            `json:"driver"`
+	StartedAt       time.Time         `json:"started_at"`
+	FinishedAt      time.Time         `json:"finished_at"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	Clients         int               `json:"clients"`
+	Params          models.LoadParams `json:"params"`
+	Transactions    int64             `json:"transactions"`
+	Errors          int64             `json:"errors"`
+	TPS             float64           `json:"tps"`
+	Latency         LatencyStats      `json:"latency"`
+	Scripts         []ScriptResult    `json:"scripts"`
+	Progress        []ProgressPoint   `json:"progress"`                // кривые пропускной способности и задержек
+	ErrorSamples    []string          `json:"error_samples,omitempty"` // первые уникальные ошибки
+	Phases          []PhaseResult     `json:"phases,omitempty"`        // фазы составного сценария
+}
+
+// ProgressPoint — пропускная способность и задержки за один интервал прогона
+// (как строка pgbench --progress). Min/Max известны не для всех источников.
+type ProgressPoint struct {
+	Elapsed       float64 `json:"elapsed_s"` // конец интервала, секунд от начала прогона
+	TPS           float64 `json:"tps"`
+	LatencyAvg    float64 `json:"latency_avg_ms"`
+	LatencyStdDev float64 `json:"latency_stddev_ms"`
+	LatencyMin    float64 `json:"latency_min_ms,omitempty"`
+	LatencyMax    float64 `json:"latency_max_ms,omitempty"`
+	Failed        int64   `json:"failed"`
+	Lag           float64 `json:"lag_ms,omitempty"` // отставание от расписания при заданном rate
+}
+
+// ProgressInterval — шаг кривых Result.Progress
+const ProgressInterval = 5 * time.Second
+
+// ScriptResult — статистика по одному скрипту смеси
+type ScriptResult struct {
+	Name         string       `json:"name"`
+	Weight       int          `json:"weight"`
+	Transactions int64        `json:"transactions"`
+	Errors       int64        `json:"errors"`
+	TPS          float64      `json:"tps"`
+	Latency      LatencyStats `json:"latency"`
+}
+
+// maxErrorSamples — сколько разных текстов ошибок сохранять в Result
+const maxErrorSamples = 10
+
+// Run выполняет нагрузку w против базы connString. Каждый клиент держит свое
+// соединение и выполняет скрипты, выбирая их по весам. Транзакция с ошибкой
+// откатывается и считается в Errors, прогон продолжается. Отмена ctx
+// останавливает клиентов; уже собранная статистика возвращается вместе с ctx.Err().
+func Run(ctx context.Context, connString string, w Workload) (*Result, error) {
+	if len(w.Scripts) == 0 {
+		return nil, fmt.Errorf("workload has no scripts")
+	}
+	if w.Clients < 1 {
+		return nil, fmt.Errorf("clients must be positive")
+	}
+	if w.Duration <= 0 && w.Transactions <= 0 {
+		return nil, fmt.Errorf("either duration or transactions must be set")
+	}
+	if w.Ramp.Up < 0 || w.Ramp.Down < 0 || w.Ramp.Up+w.Ramp.Down > w.Duration {
+		return nil, fmt.Errorf("ramp-up and ramp-down must fit into the duration")
+	}
+	totalWeight := 0
+	for _, ws := range w.Scripts {
+		if ws.Weight < 0 {
+			return nil, fmt.Errorf("script %s has negative weight", ws.Script.Name)
+		}
+		totalWeight += ws.Weight
+	}
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("total script weight must be positive")
+	}
+
+	cfg, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection string: %w", err)
+	}
+	cfg.MaxConns = int32(w.Clients)
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer pool.Close()
+
+	runCtx := ctx
+	if w.Transactions <= 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, w.Duration)
+		defer cancel()
+	}
+
+	stats := newRunStats(w.Scripts)
+	started := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.Clients; i++ {
+		wg.Add(1)
+		go func(clientID int) {
+			defer wg.Done()
+			clientCtx := runCtx
+			if w.Ramp != (Ramp{}) {
+				start, stop := w.Ramp.window(clientID, w.Clients, w.Duration)
+				select {
+				case <-runCtx.Done():
+					return
+				case <-time.After(start):
+				}
+				var cancel context.CancelFunc
+				clientCtx, cancel = context.WithDeadline(runCtx, started.Add(stop))
+				defer cancel()
+			}
+			runClient(clientCtx, pool, w, totalWeight, clientID, stats)
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(ProgressInterval)
+	defer ticker.Stop()
+	for waiting := true; waiting; {
+		select {
+		case <-ticker.C:
+			stats.tick(time.Since(started))
+		case <-done:
+			waiting = false
+		}
+	}
+	stats.tick(time.Since(started))
+
+	result := stats.result(started, time.Now())
+	result.Clients = w.Clients
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func runClient(ctx context.Context, pool *pgxpool.Pool, w Workload, totalWeight, clientID int, stats *runStats) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			stats.clientError(err)
+		}
+		return
+	}
+	defer conn.Release()
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(clientID)))
+	vars := map[string]int64{"scale": int64(max(w.Scale, 1)), "client_id": int64(clientID)}
+
+	// При заданном Rate каждый клиент дает Rate/Clients транзакций в секунду;
+	// моменты старта — пуассоновский поток, как у pgbench -R. Если клиент
+	// не успевает, следующая транзакция стартует сразу.
+	var perClient float64
+	if w.Rate > 0 {
+		perClient = w.Rate / float64(w.Clients)
+	}
+	next := time.Now()
+
+	for done := 0; w.Transactions <= 0 || done < w.Transactions; done++ {
+		if perClient > 0 {
+			next = next.Add(time.Duration(rnd.ExpFloat64() / perClient * float64(time.Second)))
+			if wait := time.Until(next); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		idx := pickScript(w.Scripts, totalWeight, rnd)
+		start := time.Now()
+		err := execScript(ctx, conn, w.Scripts[idx].Script, vars, rnd)
+		if ctx.Err() != nil {
+			// Транзакция прервана окончанием прогона — не считаем ее ни успехом, ни ошибкой
+			return
+		}
+		stats.record(idx, time.Since(start), err)
+
+		if w.ThinkTime > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(w.ThinkTime):
+			}
+		}
+	}
+}
+
+func pickScript(scripts []WeightedScript, totalWeight int, rnd *rand.Rand) int {
+	n := rnd.Intn(totalWeight)
+	for i, ws := range scripts {
+		if n < ws.Weight {
+			return i
+		}
+		n -= ws.Weight
+	}
+	return len(scripts) - 1
+}
+
+// execScript выполняет одну транзакцию скрипта. При ошибке внутри BEGIN ... COMMIT
+// соединение возвращается в исходное состояние через ROLLBACK.
+func execScript(ctx context.Context, conn *pgxpool.Conn, s *Script, vars map[string]int64, rnd *rand.Rand) error {
+	for _, cmd := range s.Commands {
+		switch {
+		case cmd.set != "":
+			v, err := cmd.expr.eval(vars, rnd)
+			if err != nil {
+				return fmt.Errorf("%s: \\set %s: %w", s.Name, cmd.set, err)
+			}
+			vars[cmd.set] = v
+		case cmd.sleep != nil:
+			n, err := cmd.sleep.eval(vars, rnd)
+			if err != nil {
+				return fmt.Errorf("%s: \\sleep: %w", s.Name, err)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(n) * cmd.unit):
+			}
+		default:
+			args := make([]any, len(cmd.args))
+			for i, name := range cmd.args {
+				v, ok := vars[name]
+				if !ok {
+					return fmt.Errorf("%s: undefined variable :%s", s.Name, name)
+				}
+				args[i] = v
+			}
+			if _, err := conn.Exec(ctx, cmd.sql, args...); err != nil {
+				if conn.Conn().PgConn().TxStatus() != 'I' {
+					_, _ = conn.Exec(context.Background(), "ROLLBACK")
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// --- Сбор статистики ---
+
+type runStats struct {
+	mu      sync.Mutex
+	scripts []WeightedScript
+	total   *histogram
+	per     []*histogram
+	errors  []int64
+	clients int64 // ошибки подключения клиентов
+	samples []string
+
+	// Текущий интервал кривой прогресса
+	interval *histogram
+	failed   int64
+	lastTick time.Duration
+	progress []ProgressPoint
+}
+
+func newRunStats(scripts []WeightedScript) *runStats {
+	s := &runStats{scripts: scripts, total: newHistogram(), errors: make([]int64, len(scripts)),
+		interval: newHistogram(), progress: []ProgressPoint{}}
+	for range scripts {
+		s.per = append(s.per, newHistogram())
+	}
+	return s
+}
+
+func (s *runStats) record(idx int, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.errors[idx]++
+		s.failed++
+		s.sample(err)
+		return
+	}
+	s.total.add(latency)
+	s.per[idx].add(latency)
+	s.interval.add(latency)
+}
+
+// tick закрывает интервал кривой прогресса, закончившийся в elapsed от начала прогона.
+// Пустой хвост короче секунды (прогон закончился сразу после тика) пропускается.
+func (s *runStats) tick(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	length := elapsed - s.lastTick
+	if length <= 0 || length < time.Second && s.interval.count == 0 && s.failed == 0 {
+		return
+	}
+	st := s.interval.stats()
+	s.progress = append(s.progress, ProgressPoint{
+		Elapsed:       elapsed.Seconds(),
+		TPS:           float64(s.interval.count) / length.Seconds(),
+		LatencyAvg:    st.Avg,
+		LatencyStdDev: st.StdDev,
+		LatencyMin:    st.Min,
+		LatencyMax:    st.Max,
+		Failed:        s.failed,
+	})
+	s.interval = newHistogram()
+	s.failed = 0
+	s.lastTick = elapsed
+}
+
+func (s *runStats) clientError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients++
+	s.sample(err)
+}
+
+func (s *runStats) sample(err error) {
+	if len(s.samples) >= maxErrorSamples {
+		return
+	}
+	msg := err.Error()
+	for _, m := range s.samples {
+		if m == msg {
+			return
+		}
+	}
+	s.samples = append(s.samples, msg)
+}
+
+func (s *runStats) result(started, finished time.Time) *Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seconds := finished.Sub(started).Seconds()
+	r := &Result{
+		Driver:          DriverNative,
+		StartedAt:       started,
+		FinishedAt:      finished,
+		DurationSeconds: seconds,
+		Transactions:    s.total.count,
+		Errors:          s.clients,
+		Latency:         s.total.stats(),
+		Progress:        s.progress,
+		ErrorSamples:    s.samples,
+	}
+	for i, ws := range s.scripts {
+		sr := ScriptResult{
+			Name:         ws.Script.Name,
+			Weight:       ws.Weight,
+			Transactions: s.per[i].count,
+			Errors:       s.errors[i],
+			Latency:      s.per[i].stats(),
+		}
+		if seconds > 0 {
+			sr.TPS = float64(sr.Transactions) / seconds
+		}
+		r.Errors += sr.Errors
+		r.Scripts = append(r.Scripts, sr)
+	}
+	if seconds > 0 {
+		r.TPS = float64(r.Transactions) / seconds
+	}
+	return r
+}
+
+// --- Гистограмма задержек ---
+
+// LatencyStats — задержки успешных транзакций в миллисекундах. Перцентили
+// оцениваются по гистограмме (точность — ширина корзины, ~19%).
+type LatencyStats struct {
+	Avg       float64  `json:"avg_ms"`
+	StdDev    float64  `json:"stddev_ms"`
+	Min       float64  `json:"min_ms"`
+	Max       float64  `json:"max_ms"`
+	P50       float64  `json:"p50_ms"`
+	P95       float64  `json:"p95_ms"`
+	P99       float64  `json:"p99_ms"`
+	Histogram []Bucket `json:"histogram"`
+}
+
+// Bucket — число транзакций с задержкой не больше UpperMs (и больше предыдущей границы)
+type Bucket struct {
+	UpperMs float64 `json:"le_ms"`
+	Count   int64   `json:"count"`
+}
+
+// Границы корзин: от 50us, каждая следующая в 2^(1/4) раза больше (до ~2 минут)
+var bucketBounds = func() []float64 {
+	var bounds []float64
+	for v := 0.05; v < 120000; v *= math.Pow(2, 0.25) {
+		bounds = append(bounds, v)
+	}
+	return bounds
+}()
+
+type histogram struct {
+	counts     []int64 // последняя корзина — все, что больше последней границы
+	count      int64
+	sum, sumSq float64
+	min, max   float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, len(bucketBounds)+1)}
+}
+
+func (h *histogram) add(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	h.counts[sort.SearchFloat64s(bucketBounds, ms)]++
+	if h.count == 0 || ms < h.min {
+		h.min = ms
+	}
+	if ms > h.max {
+		h.max = ms
+	}
+	h.count++
+	h.sum += ms
+	h.sumSq += ms * ms
+}
+
+func (h *histogram) stats() LatencyStats {
+	st := LatencyStats{Histogram: []Bucket{}}
+	if h.count == 0 {
+		return st
+	}
+	n := float64(h.count)
+	st.Avg = h.sum / n
+	st.StdDev = math.Sqrt(math.Max(h.sumSq/n-st.Avg*st.Avg, 0))
+	st.Min, st.Max = h.min, h.max
+	st.P50, st.P95, st.P99 = h.quantile(0.5), h.quantile(0.95), h.quantile(0.99)
+	for i, c := range h.counts {
+		if c > 0 {
+			st.Histogram = append(st.Histogram, Bucket{UpperMs: h.upper(i), Count: c})
+		}
+	}
+	return st
+}
+
+// quantile — верхняя граница корзины, в которую попадает q-я доля транзакций
+func (h *histogram) quantile(q float64) float64 {
+	target := int64(math.Ceil(q * float64(h.count)))
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			return math.Min(h.upper(i), h.max)
+		}
+	}
+	return h.max
+}
+
+func (h *histogram) upper(i int) float64 {
+	if i < len(bucketBounds) {
+		return bucketBounds[i]
+	}
+	return h.max
+}
@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	ms := func(v float64) time.Duration { return time.Duration(v * float64(time.Millisecond)) }
+	// Корзина шириной в 2^(1/4): квантиль не меньше точного значения и не больше его на ~19%
+	step := math.Pow(2, 0.25)
+
+	tests := []struct {
+		name   string
+		values []float64 // мс
+		q      float64
+		exact  float64
+	}{
+		{"single value", []float64{1}, 0.5, 1},
+		{"equal values", []float64{3, 3, 3, 3}, 0.99, 3},
+		{"median of 1..100", seq(1, 100), 0.5, 50},
+		{"p95 of 1..100", seq(1, 100), 0.95, 95},
+		{"p99 of 1..100", seq(1, 100), 0.99, 99},
+		{"max is the cap", seq(1, 100), 1, 100},
+		{"tail outlier", append(repeat(2, 99), 5000), 0.99, 2},
+		{"tail outlier p100", append(repeat(2, 99), 5000), 1, 5000},
+		{"beyond last bucket", []float64{200000, 300000}, 0.5, 200000},
+		{"below first bucket", []float64{0.01, 0.02}, 0.5, 0.01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHistogram()
+			for _, v := range tt.values {
+				h.add(ms(v))
+			}
+			got := h.quantile(tt.q)
+			if got > h.max+1e-9 {
+				t.Fatalf("quantile %.2f = %v exceeds max %v", tt.q, got, h.max)
+			}
+			lo, hi := tt.exact, tt.exact*step
+			if tt.exact < bucketBounds[0] {
+				lo, hi = tt.exact, bucketBounds[0]
+			}
+			if tt.exact > bucketBounds[len(bucketBounds)-1] {
+				hi = h.max
+			}
+			if got < lo-1e-9 || got > hi+1e-9 {
+				t.Fatalf("quantile %.2f = %v, want within [%v, %v]", tt.q, got, lo, hi)
+			}
+		})
+	}
+}
+
+func TestHistogramStats(t *testing.T) {
+	h := newHistogram()
+	if st := h.stats(); st.P50 != 0 || len(st.Histogram) != 0 {
+		t.Fatalf("empty histogram stats = %+v", st)
+	}
+	for _, v := range []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond} {
+		h.add(v)
+	}
+	st := h.stats()
+	if st.Min != 1 || st.Max != 3 || math.Abs(st.Avg-2) > 1e-9 || math.Abs(st.StdDev-math.Sqrt(2.0/3)) > 1e-9 {
+		t.Fatalf("stats = %+v", st)
+	}
+	var total int64
+	for _, b := range st.Histogram {
+		total += b.Count
+	}
+	if total != 3 {
+		t.Fatalf("histogram holds %d values, want 3", total)
+	}
+	if !(st.P50 <= st.P95 && st.P95 <= st.P99 && st.P99 <= st.Max) {
+		t.Fatalf("quantiles are not ordered: %+v", st)
+	}
+}
+
+func TestRampWindow(t *testing.T) {
+	type window struct{ start, stop time.Duration }
+	s := time.Second
+	tests := []struct {
+		name string
+		ramp Ramp
+		n    int
+		d    time.Duration
+		want []window
+	}{
+		{"no ramp", Ramp{}, 3, 10 * s, []window{{0, 10 * s}, {0, 10 * s}, {0, 10 * s}}},
+		{"ramp up", Ramp{Up: 4 * s}, 4, 10 * s, []window{{0, 10 * s}, {1 * s, 10 * s}, {2 * s, 10 * s}, {3 * s, 10 * s}}},
+		{"ramp down", Ramp{Down: 4 * s}, 4, 10 * s, []window{{0, 7 * s}, {0, 8 * s}, {0, 9 * s}, {0, 10 * s}}},
+		{"both", Ramp{Up: 2 * s, Down: 2 * s}, 2, 6 * s, []window{{0, 5 * s}, {1 * s, 6 * s}}},
+		{"single client", Ramp{Up: 5 * s, Down: 5 * s}, 1, 10 * s, []window{{0, 10 * s}}},
+		{"ramps take the whole run", Ramp{Up: 5 * s, Down: 5 * s}, 5, 10 * s,
+			[]window{{0, 6 * s}, {1 * s, 7 * s}, {2 * s, 8 * s}, {3 * s, 9 * s}, {4 * s, 10 * s}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, w := range tt.want {
+				start, stop := tt.ramp.window(i, tt.n, tt.d)
+				if start != w.start || stop != w.stop {
+					t.Errorf("client %d: window = [%s, %s], want [%s, %s]", i, start, stop, w.start, w.stop)
+				}
+				if start > stop {
+					t.Errorf("client %d starts after it stops", i)
+				}
+			}
+		})
+	}
+}
+
+func seq(from, to int) []float64 {
+	var out []float64
+	for i := from; i <= to; i++ {
+		out = append(out, float64(i))
+	}
+	return out
+}
+
+func repeat(v float64, n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,394 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Manifest — описание сценария нагрузки в файле scenarios/<имя>.json:
+// смесь скриптов, интенсивность по умолчанию, ожидаемый профиль и подготовка схемы.
+// Составной сценарий вместо скриптов задает фазы (phases) из других сценариев.
+type Manifest struct {
+	Name            string           `json:"name"`
+	Title           string           `json:"title"`
+	Description     string           `json:"description"`
+	Scripts         []ManifestScript `json:"scripts"`
+	Clients         int              `json:"clients"`
+	Jobs            int              `json:"jobs"`
+	MaxClients      int              `json:"max_clients"`
+	Duration        string           `json:"duration,omitempty"`     // например "1m"; не задается для разовых сценариев
+	Transactions    int              `json:"transactions,omitempty"` // транзакций на клиента; > 0 — разовый сценарий
+	Scale           int              `json:"scale,omitempty"`        // масштаб по умолчанию; 0 — по существующей схеме
+	Rate            float64          `json:"rate,omitempty"`         // целевой TPS по умолчанию; 0 — без ограничения
+	ThinkTime       string           `json:"think_time,omitempty"`   // пауза клиента между транзакциями, например "50ms"
+	ExpectedProfile string           `json:"expected_profile,omitempty"`
+	Requires        []string         `json:"requires,omitempty"` // таблицы, без которых сценарий не запустить
+	Setup           string           `json:"setup,omitempty"`    // сценарий, создающий эти таблицы
+	Target          string           `json:"target,omitempty"`   // база для нагрузки (см. TargetDSN); пусто — DATABASE_URL
+	Phases          []Phase          `json:"phases,omitempty"`
+
+	duration  time.Duration
+	thinkTime time.Duration
+	file      string
+}
+
+// Phase — этап составного сценария: несколько сценариев одновременно в течение duration.
+// Метка и ожидаемый профиль этапа попадают в ground truth, пока он идет.
+type Phase struct {
+	Label           string          `json:"label"`
+	Duration        string          `json:"duration"`
+	ExpectedProfile string          `json:"expected_profile,omitempty"`
+	Workloads       []PhaseWorkload `json:"workloads"`
+
+	duration time.Duration
+}
+
+// PhaseWorkload — сценарий внутри этапа, его клиенты, rate и плавный рост/спад клиентов
+type PhaseWorkload struct {
+	Scenario string  `json:"scenario"`
+	Clients  int     `json:"clients,omitempty"` // 0 — клиенты сценария по умолчанию
+	Rate     float64 `json:"rate,omitempty"`
+	RampUp   string  `json:"ramp_up,omitempty"`
+	RampDown string  `json:"ramp_down,omitempty"`
+
+	ramp Ramp
+}
+
+// ManifestScript — скрипт из каталога сценариев и его вес в смеси
+type ManifestScript struct {
+	File   string `json:"file"`
+	Weight int    `json:"weight"`
+}
+
+// OneOff — сценарий выполняется одним клиентом фиксированное число транзакций
+func (m *Manifest) OneOff() bool {
+	return m.Transactions > 0
+}
+
+// Composite — сценарий состоит из фаз
+func (m *Manifest) Composite() bool {
+	return len(m.Phases) > 0
+}
+
+// HasRamps — в сценарии есть плавный рост или спад клиентов
+func (m *Manifest) HasRamps() bool {
+	for _, ph := range m.Phases {
+		for _, wl := range ph.Workloads {
+			if wl.ramp != (Ramp{}) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var (
+	libraryMu sync.RWMutex
+	library   = map[string]*Manifest{}
+)
+
+// LoadScenarios находит манифесты *.json в dir, проверяет их и заменяет библиотеку
+// сценариев. Некорректные манифесты пропускаются; их ошибки возвращаются вместе,
+// а корректные сценарии остаются доступны.
+func LoadScenarios(dir string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list scenarios: %w", err)
+	}
+
+	loaded := make(map[string]*Manifest)
+	var errs []error
+	for _, file := range files {
+		m, err := loadManifest(dir, file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if prev, ok := loaded[m.Name]; ok {
+			errs = append(errs, fmt.Errorf("%s: scenario %s is already defined in %s", file, m.Name, prev.file))
+			continue
+		}
+		loaded[m.Name] = m
+	}
+
+	// Фазы ссылаются на другие сценарии, поэтому проверяются после загрузки всех
+	for name, m := range loaded {
+		if err := m.validatePhases(loaded); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.file, err))
+			delete(loaded, name)
+		}
+	}
+
+	// setup тоже ссылается на другой сценарий
+	for name, m := range loaded {
+		if m.Setup == "" {
+			continue
+		}
+		setup, ok := loaded[m.Setup]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s: setup scenario %s not found", m.file, m.Setup))
+		case setup.Setup != "":
+			errs = append(errs, fmt.Errorf("%s: setup scenario %s has its own setup", m.file, m.Setup))
+		default:
+			continue
+		}
+		delete(loaded, name)
+	}
+
+	libraryMu.Lock()
+	library = loaded
+	libraryMu.Unlock()
+	return len(loaded), errors.Join(errs...)
+}
+
+func loadManifest(dir, file string) (*Manifest, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	m := &Manifest{file: filepath.Base(file)}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	if err := m.validate(dir); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return m, nil
+}
+
+func (m *Manifest) validate(dir string) error {
+	if m.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if m.Title == "" {
+		m.Title = m.Name
+	}
+	if m.Target != "" && !targetNameRe.MatchString(m.Target) {
+		return fmt.Errorf("scenario %s: target must match %s", m.Name, targetNameRe)
+	}
+	if m.Composite() {
+		return m.validateComposite()
+	}
+	if len(m.Scripts) == 0 {
+		return fmt.Errorf("scenario %s has no scripts", m.Name)
+	}
+	for _, s := range m.Scripts {
+		if s.Weight <= 0 {
+			return fmt.Errorf("script %s must have a positive weight", s.File)
+		}
+		if filepath.Base(s.File) != s.File {
+			return fmt.Errorf("script %s must be a file in the scenarios directory", s.File)
+		}
+		if _, err := LoadScript(filepath.Join(dir, s.File)); err != nil {
+			return err
+		}
+	}
+
+	if m.OneOff() {
+		if m.Duration != "" {
+			return fmt.Errorf("scenario %s runs %d transactions, duration is not allowed", m.Name, m.Transactions)
+		}
+		m.Clients, m.Jobs, m.MaxClients = 1, 1, 1
+	} else {
+		d, err := time.ParseDuration(m.Duration)
+		if err != nil || d < time.Second || d > MaxDuration {
+			return fmt.Errorf("scenario %s: duration must be between 1s and %s", m.Name, MaxDuration)
+		}
+		m.duration = d
+		if m.MaxClients == 0 {
+			m.MaxClients = m.Clients
+		}
+		if m.Clients < 1 || m.Clients > m.MaxClients {
+			return fmt.Errorf("scenario %s: clients must be between 1 and max_clients", m.Name)
+		}
+		if m.Jobs == 0 {
+			m.Jobs = 1
+		}
+		if m.Jobs < 1 || m.Jobs > m.Clients {
+			return fmt.Errorf("scenario %s: jobs must be between 1 and clients", m.Name)
+		}
+	}
+	if m.Rate < 0 || m.Rate > MaxRate || m.OneOff() && m.Rate != 0 {
+		return fmt.Errorf("scenario %s: rate must be between 0 and %d (and 0 for one-off scenarios)", m.Name, MaxRate)
+	}
+	if m.Scale < 0 || m.Scale > MaxScale {
+		return fmt.Errorf("scenario %s: scale must be between 0 and %d", m.Name, MaxScale)
+	}
+	if m.ThinkTime != "" {
+		d, err := time.ParseDuration(m.ThinkTime)
+		if err != nil || d < 0 || d > MaxThinkTime || d%time.Millisecond != 0 {
+			return fmt.Errorf("scenario %s: think_time must be a whole number of milliseconds between 0 and %s", m.Name, MaxThinkTime)
+		}
+		m.thinkTime = d
+	}
+	m.ExpectedProfile = strings.ToUpper(m.ExpectedProfile)
+	if m.Setup == m.Name {
+		return fmt.Errorf("scenario %s cannot be its own setup", m.Name)
+	}
+	return nil
+}
+
+// validateComposite проверяет то, что не зависит от других сценариев:
+// длительности фаз, метки и рампы
+func (m *Manifest) validateComposite() error {
+	if len(m.Scripts) > 0 || m.Transactions > 0 || m.Duration != "" || m.Clients > 0 || m.Rate > 0 || m.ThinkTime != "" {
+		return fmt.Errorf("composite scenario %s cannot set scripts, duration, transactions, clients, rate or think_time", m.Name)
+	}
+	labels := make(map[string]bool)
+	var total time.Duration
+	for i := range m.Phases {
+		ph := &m.Phases[i]
+		if ph.Label == "" || labels[ph.Label] {
+			return fmt.Errorf("scenario %s: phase %d needs a unique label", m.Name, i+1)
+		}
+		labels[ph.Label] = true
+		d, err := time.ParseDuration(ph.Duration)
+		if err != nil || d < time.Second || d%time.Second != 0 {
+			return fmt.Errorf("scenario %s: phase %s: duration must be a whole number of seconds, at least 1s", m.Name, ph.Label)
+		}
+		ph.duration = d
+		total += d
+		ph.ExpectedProfile = strings.ToUpper(ph.ExpectedProfile)
+		if len(ph.Workloads) == 0 {
+			return fmt.Errorf("scenario %s: phase %s has no workloads", m.Name, ph.Label)
+		}
+		for j := range ph.Workloads {
+			wl := &ph.Workloads[j]
+			if wl.Clients < 0 || wl.Rate < 0 || wl.Rate > MaxRate {
+				return fmt.Errorf("scenario %s: phase %s: invalid clients or rate for %s", m.Name, ph.Label, wl.Scenario)
+			}
+			for _, r := range []struct {
+				text string
+				dst  *time.Duration
+			}{{wl.RampUp, &wl.ramp.Up}, {wl.RampDown, &wl.ramp.Down}} {
+				if r.text == "" {
+					continue
+				}
+				if *r.dst, err = time.ParseDuration(r.text); err != nil || *r.dst < 0 {
+					return fmt.Errorf("scenario %s: phase %s: invalid ramp %q", m.Name, ph.Label, r.text)
+				}
+			}
+			if wl.ramp.Up+wl.ramp.Down > d {
+				return fmt.Errorf("scenario %s: phase %s: ramps of %s are longer than the phase", m.Name, ph.Label, wl.Scenario)
+			}
+		}
+	}
+	if total > MaxDuration {
+		return fmt.Errorf("scenario %s: phases must not exceed %s in total", m.Name, MaxDuration)
+	}
+	m.duration = total
+	m.Clients, m.Jobs, m.MaxClients = m.peakClients(nil), 1, 0
+	return nil
+}
+
+// validatePhases проверяет ссылки фаз на другие сценарии и досчитывает клиентов
+func (m *Manifest) validatePhases(loaded map[string]*Manifest) error {
+	for _, ph := range m.Phases {
+		for _, wl := range ph.Workloads {
+			sub, ok := loaded[wl.Scenario]
+			switch {
+			case !ok:
+				return fmt.Errorf("phase %s: scenario %s not found", ph.Label, wl.Scenario)
+			case sub.Composite() || sub.OneOff():
+				return fmt.Errorf("phase %s: scenario %s must be a plain duration-based scenario", ph.Label, wl.Scenario)
+			case wl.Clients > sub.MaxClients:
+				return fmt.Errorf("phase %s: %s allows at most %d clients", ph.Label, wl.Scenario, sub.MaxClients)
+			}
+		}
+	}
+	if m.Composite() {
+		m.Clients = m.peakClients(loaded)
+		m.MaxClients = m.Clients
+	}
+	return nil
+}
+
+// peakClients — наибольшее суммарное число клиентов среди фаз
+func (m *Manifest) peakClients(loaded map[string]*Manifest) int {
+	peak := 0
+	for _, ph := range m.Phases {
+		sum := 0
+		for _, wl := range ph.Workloads {
+			clients := wl.Clients
+			if clients == 0 && loaded != nil {
+				clients = loaded[wl.Scenario].Clients
+			}
+			sum += max(clients, 1)
+		}
+		peak = max(peak, sum)
+	}
+	return peak
+}
+
+// hasThinkTime — в фазах есть сценарий с паузой между транзакциями
+func (m *Manifest) hasThinkTime() bool {
+	for _, ph := range m.Phases {
+		for _, wl := range ph.Workloads {
+			if sub, ok := LookupScenario(wl.Scenario); ok && sub.thinkTime > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Scenarios возвращает сценарии библиотеки, отсортированные по имени
+func Scenarios() []Manifest {
+	libraryMu.RLock()
+	defer libraryMu.RUnlock()
+
+	list := make([]Manifest, 0, len(library))
+	for _, m := range library {
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// LookupScenario возвращает сценарий по имени
+func LookupScenario(name string) (*Manifest, bool) {
+	libraryMu.RLock()
+	defer libraryMu.RUnlock()
+
+	m, ok := library[name]
+	if !ok {
+		return nil, false
+	}
+	copied := *m
+	return &copied, true
+}
+
+// missingTables — какие из требуемых сценарием таблиц отсутствуют в базе
+func missingTables(ctx context.Context, dbUrl string, tables []string) ([]string, error) {
+	if len(tables) == 0 {
+		return nil, nil
+	}
+	conn, err := pgx.Connect(ctx, dbUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var missing []string
+	for _, table := range tables {
+		var exists bool
+		if err := conn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("failed to check table %s: %w", table, err)
+		}
+		if !exists {
+			missing = append(missing, table)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,222 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// Статусы прогона нагрузки
+const (
+	RunQueued    = "queued"
+	RunRunning   = "running"
+	RunFinished  = "finished"
+	RunFailed    = "failed"
+	RunCancelled = "cancelled"
+)
+
+// maxRuns — сколько последних прогонов хранится в памяти
+const maxRuns = 100
+
+var (
+	// ErrRunInProgress — нагрузка уже идет, второй прогон исказил бы разметку
+	ErrRunInProgress = errors.New("another load run is in progress")
+	// ErrRunNotFound — прогона с таким ID нет (или он вытеснен из истории)
+	ErrRunNotFound = errors.New("load run not found")
+	// ErrRunFinished — прогон уже завершился, останавливать нечего
+	ErrRunFinished = errors.New("load run has already finished")
+)
+
+// LoadRun — один прогон нагрузки: статус, время и итог
+type LoadRun struct {
+	ID         int64             `json:"id"`
+	Scenario   string            `json:"scenario"`
+	Driver     string            `json:"driver"`
+	Params     models.LoadParams `json:"params"`
+	Status     string            `json:"status"`
+	QueuedAt   time.Time         `json:"queued_at"`
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	ExitCode   *int              `json:"exit_code,omitempty"` // код выхода pgbench/psql (LOAD_DRIVER=pgbench)
+	Result     *Result           `json:"result,omitempty"`
+	Phase      string            `json:"phase,omitempty"` // текущая фаза составного сценария
+}
+
+// Active — прогон еще не завершился
+func (r *LoadRun) Active() bool {
+	return r.Status == RunQueued || r.Status == RunRunning
+}
+
+// Manager ведет прогоны нагрузки: выдает ID, не дает запустить второй прогон,
+// пока идет первый, и останавливает прогон отменой контекста.
+type Manager struct {
+	mu       sync.Mutex
+	nextID   int64
+	runs     []*LoadRun // от старых к новым
+	cancels  map[int64]context.CancelFunc
+	onPhase  func(LoadRun, Phase)
+	onFinish func(LoadRun)
+}
+
+// NewManager создает пустой менеджер прогонов. onPhase (может быть nil) вызывается,
+// когда составной сценарий переходит к следующей фазе, — например, чтобы обновить разметку.
+// onFinish (может быть nil) вызывается, когда прогон завершился, упал или остановлен, —
+// например, чтобы снять разметку.
+func NewManager(onPhase func(LoadRun, Phase), onFinish func(LoadRun)) *Manager {
+	return &Manager{nextID: 1, cancels: make(map[int64]context.CancelFunc), onPhase: onPhase, onFinish: onFinish}
+}
+
+// Start ставит прогон в очередь и запускает его в фоне. Параметры должны быть
+// уже проверены ResolveParams. Возвращает копию прогона в статусе queued.
+func (m *Manager) Start(scenario string, params models.LoadParams) (LoadRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.runs {
+		if r.Active() {
+			return LoadRun{}, fmt.Errorf("%w: run %d (%s) is %s", ErrRunInProgress, r.ID, r.Scenario, r.Status)
+		}
+	}
+
+	run := &LoadRun{
+		ID:       m.nextID,
+		Scenario: scenario,
+		Driver:   DriverFromEnv(),
+		Params:   params,
+		Status:   RunQueued,
+		QueuedAt: time.Now(),
+	}
+	m.nextID++
+	m.runs = append(m.runs, run)
+	if len(m.runs) > maxRuns {
+		m.runs = m.runs[len(m.runs)-maxRuns:]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancels[run.ID] = cancel
+	go m.execute(ctx, run)
+
+	return *run, nil
+}
+
+func (m *Manager) execute(ctx context.Context, run *LoadRun) {
+	m.mu.Lock()
+	started := time.Now()
+	run.StartedAt = &started
+	run.Status = RunRunning
+	m.mu.Unlock()
+
+	result, err := RunBusinessScenario(ctx, run.Scenario, run.Params, func(ph Phase) {
+		m.mu.Lock()
+		run.Phase = ph.Label
+		copied := *run
+		m.mu.Unlock()
+		log.Printf("[GENERATOR] Run %d (%s): phase %s", run.ID, run.Scenario, ph.Label)
+		if m.onPhase != nil {
+			m.onPhase(copied, ph)
+		}
+	})
+
+	m.mu.Lock()
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Result = result
+	switch {
+	case ctx.Err() != nil:
+		run.Status = RunCancelled
+	case err != nil:
+		run.Status = RunFailed
+	default:
+		run.Status = RunFinished
+	}
+	if err != nil {
+		run.Error = err.Error()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code := exitErr.ExitCode()
+			run.ExitCode = &code
+		}
+	}
+	if cancel, ok := m.cancels[run.ID]; ok {
+		cancel()
+		delete(m.cancels, run.ID)
+	}
+	copied := *run
+	m.mu.Unlock()
+
+	log.Printf("[GENERATOR] Run %d (%s) %s", run.ID, run.Scenario, copied.Status)
+	if m.onFinish != nil {
+		m.onFinish(copied)
+	}
+}
+
+// Stop отменяет прогон; статус станет cancelled, когда нагрузка остановится
+func (m *Manager) Stop(id int64) (LoadRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	run := m.find(id)
+	if run == nil {
+		return LoadRun{}, ErrRunNotFound
+	}
+	cancel, ok := m.cancels[id]
+	if !ok || !run.Active() {
+		return *run, ErrRunFinished
+	}
+	cancel()
+	return *run, nil
+}
+
+// Get возвращает копию прогона по ID
+func (m *Manager) Get(id int64) (LoadRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	run := m.find(id)
+	if run == nil {
+		return LoadRun{}, ErrRunNotFound
+	}
+	return *run, nil
+}
+
+// List возвращает прогоны, новые первыми
+func (m *Manager) List() []LoadRun {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	runs := make([]LoadRun, 0, len(m.runs))
+	for i := len(m.runs) - 1; i >= 0; i-- {
+		runs = append(runs, *m.runs[i])
+	}
+	return runs
+}
+
+// LatestResult — результат последнего завершенного прогона (в том числе
+// остановленного или упавшего, если нагрузка успела пойти)
+func (m *Manager) LatestResult() *Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.runs) - 1; i >= 0; i-- {
+		if r := m.runs[i]; !r.Active() && r.Result != nil {
+			return r.Result
+		}
+	}
+	return nil
+}
+
+func (m *Manager) find(id int64) *LoadRun {
+	for _, r := range m.runs {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
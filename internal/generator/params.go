@@ -0,0 +1,112 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// ErrInvalidParams — параметры нагрузки вне допустимых пределов
+var ErrInvalidParams = errors.New("invalid load parameters")
+
+// Пределы, общие для всех сценариев
+const (
+	MaxDuration  = 24 * time.Hour
+	MaxRate      = 100000
+	MaxScale     = 10000
+	MaxThinkTime = time.Minute
+)
+
+// ResolveParams подставляет значения по умолчанию сценария вместо нулевых полей и
+// проверяет пределы. Если масштаб не задан, он определяется по существующей схеме
+// pgbench (для init — масштаб создаваемой схемы). База по умолчанию — target сценария.
+func ResolveParams(ctx context.Context, scenario string, p models.LoadParams) (models.LoadParams, error) {
+	m, ok := LookupScenario(scenario)
+	if !ok {
+		return p, fmt.Errorf("unknown business scenario: %s", scenario)
+	}
+
+	if m.Composite() {
+		// Длительность, клиенты и rate заданы в фазах; общим остается только масштаб.
+		// Значения, совпадающие с выведенными из фаз, допустимы: так параметры можно
+		// разрешить повторно (Manager запускает уже разрешенные параметры).
+		duration := int(m.duration / time.Second)
+		if p.DurationSeconds != 0 && p.DurationSeconds != duration ||
+			p.Clients != 0 && p.Clients != m.Clients ||
+			p.Jobs > 1 || p.Rate != 0 || p.ThinkTimeMS != 0 {
+			return p, fmt.Errorf("%w: scenario %s is composite, only scale can be set", ErrInvalidParams, scenario)
+		}
+		if m.HasRamps() && DriverFromEnv() == DriverPgbench {
+			return p, fmt.Errorf("%w: scenario %s ramps clients, which the pgbench driver does not support", ErrInvalidParams, scenario)
+		}
+		if m.hasThinkTime() && DriverFromEnv() == DriverPgbench {
+			return p, fmt.Errorf("%w: scenario %s pauses clients between transactions, which the pgbench driver does not support", ErrInvalidParams, scenario)
+		}
+		p.DurationSeconds = duration
+		p.Clients, p.Jobs = m.Clients, 1
+	} else if m.OneOff() {
+		// Разовые сценарии (init, cold) выполняются одним клиентом до конца
+		if p.DurationSeconds != 0 || p.Rate != 0 {
+			return p, fmt.Errorf("%w: scenario %s runs once, duration and rate are not supported", ErrInvalidParams, scenario)
+		}
+		if p.Clients > 1 || p.Jobs > 1 {
+			return p, fmt.Errorf("%w: scenario %s runs in a single client", ErrInvalidParams, scenario)
+		}
+		p.Clients, p.Jobs = 1, 1
+		if p.ThinkTimeMS == 0 {
+			p.ThinkTimeMS = int(m.thinkTime / time.Millisecond)
+		}
+	} else {
+		if p.DurationSeconds == 0 {
+			p.DurationSeconds = int(m.duration / time.Second)
+		}
+		if p.Clients == 0 {
+			p.Clients = m.Clients
+		}
+		if p.Jobs == 0 {
+			p.Jobs = min(m.Jobs, p.Clients)
+		}
+		if p.Rate == 0 {
+			p.Rate = m.Rate
+		}
+		if p.ThinkTimeMS == 0 {
+			p.ThinkTimeMS = int(m.thinkTime / time.Millisecond)
+		}
+	}
+
+	switch {
+	case p.DurationSeconds < 0 || time.Duration(p.DurationSeconds)*time.Second > MaxDuration:
+		return p, fmt.Errorf("%w: duration must be between 1s and %s", ErrInvalidParams, MaxDuration)
+	case p.Clients < 1 || p.Clients > m.MaxClients:
+		return p, fmt.Errorf("%w: clients must be between 1 and %d for %s", ErrInvalidParams, m.MaxClients, scenario)
+	case p.Jobs < 1 || p.Jobs > p.Clients:
+		return p, fmt.Errorf("%w: jobs must be between 1 and clients (%d)", ErrInvalidParams, p.Clients)
+	case p.Rate < 0 || p.Rate > MaxRate:
+		return p, fmt.Errorf("%w: rate must be between 0 (unlimited) and %d TPS", ErrInvalidParams, MaxRate)
+	case p.Scale < 0 || p.Scale > MaxScale:
+		return p, fmt.Errorf("%w: scale must be between 1 and %d", ErrInvalidParams, MaxScale)
+	case p.ThinkTimeMS < 0 || time.Duration(p.ThinkTimeMS)*time.Millisecond > MaxThinkTime:
+		return p, fmt.Errorf("%w: think time must be between 0 and %s", ErrInvalidParams, MaxThinkTime)
+	case p.ThinkTimeMS > 0 && DriverFromEnv() == DriverPgbench:
+		return p, fmt.Errorf("%w: the pgbench driver does not support think time", ErrInvalidParams)
+	}
+
+	if p.Target == "" {
+		p.Target = m.Target
+	}
+	dbUrl, err := TargetDSN(p.Target)
+	if err != nil {
+		return p, err
+	}
+
+	if p.Scale == 0 {
+		p.Scale = m.Scale
+	}
+	if p.Scale == 0 {
+		p.Scale = detectScale(ctx, dbUrl)
+	}
+	return p, nil
+}
@@ -0,0 +1,130 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// loadTestLibrary кладет в библиотеку сценарии из манифестов name -> JSON
+// (скрипт select.sql создается рядом)
+func loadTestLibrary(t *testing.T, manifests map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "select.sql"), []byte("SELECT 1;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range manifests {
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := LoadScenarios(dir); err != nil {
+		t.Fatalf("LoadScenarios: %v", err)
+	}
+}
+
+var testManifests = map[string]string{
+	"plain": `{"name": "plain", "scripts": [{"file": "select.sql", "weight": 1}],
+		"clients": 10, "jobs": 4, "max_clients": 50, "duration": "1m", "rate": 100, "scale": 2}`,
+	"once": `{"name": "once", "scripts": [{"file": "select.sql", "weight": 1}], "transactions": 5, "scale": 3}`,
+	"other": `{"name": "other", "scripts": [{"file": "select.sql", "weight": 1}],
+		"clients": 4, "max_clients": 8, "duration": "30s", "scale": 2, "think_time": "20ms"}`,
+	"phased": `{"name": "phased", "phases": [
+		{"label": "a", "duration": "1m", "workloads": [{"scenario": "plain"}]},
+		{"label": "b", "duration": "30s", "workloads": [{"scenario": "plain", "clients": 20}, {"scenario": "other"}]}]}`,
+}
+
+func TestResolveParams(t *testing.T) {
+	loadTestLibrary(t, testManifests)
+
+	tests := []struct {
+		name     string
+		scenario string
+		in       models.LoadParams
+		want     models.LoadParams
+		wantErr  bool
+	}{
+		{"plain defaults", "plain", models.LoadParams{}, models.LoadParams{DurationSeconds: 60, Clients: 10, Jobs: 4, Rate: 100, Scale: 2}, false},
+		{"plain overrides", "plain", models.LoadParams{DurationSeconds: 5, Clients: 2, Rate: 1, Scale: 7},
+			models.LoadParams{DurationSeconds: 5, Clients: 2, Jobs: 2, Rate: 1, Scale: 7}, false},
+		{"plain too many clients", "plain", models.LoadParams{Clients: 51}, models.LoadParams{}, true},
+		{"plain jobs above clients", "plain", models.LoadParams{Clients: 2, Jobs: 3}, models.LoadParams{}, true},
+		{"plain rate above max", "plain", models.LoadParams{Rate: MaxRate + 1}, models.LoadParams{}, true},
+		{"plain scale above max", "plain", models.LoadParams{Scale: MaxScale + 1}, models.LoadParams{}, true},
+		{"think time from manifest", "other", models.LoadParams{}, models.LoadParams{DurationSeconds: 30, Clients: 4, Jobs: 1, Scale: 2, ThinkTimeMS: 20}, false},
+		{"think time override", "other", models.LoadParams{ThinkTimeMS: 500}, models.LoadParams{DurationSeconds: 30, Clients: 4, Jobs: 1, Scale: 2, ThinkTimeMS: 500}, false},
+		{"think time above max", "plain", models.LoadParams{ThinkTimeMS: 60001}, models.LoadParams{}, true},
+		{"negative think time", "plain", models.LoadParams{ThinkTimeMS: -1}, models.LoadParams{}, true},
+		{"one-off defaults", "once", models.LoadParams{}, models.LoadParams{Clients: 1, Jobs: 1, Scale: 3}, false},
+		{"one-off duration", "once", models.LoadParams{DurationSeconds: 10}, models.LoadParams{}, true},
+		{"one-off clients", "once", models.LoadParams{Clients: 2}, models.LoadParams{}, true},
+		{"composite defaults", "phased", models.LoadParams{Scale: 1}, models.LoadParams{DurationSeconds: 90, Clients: 24, Jobs: 1, Scale: 1}, false},
+		{"composite duration", "phased", models.LoadParams{DurationSeconds: 10, Scale: 1}, models.LoadParams{}, true},
+		{"composite clients", "phased", models.LoadParams{Clients: 5, Scale: 1}, models.LoadParams{}, true},
+		{"composite rate", "phased", models.LoadParams{Rate: 5, Scale: 1}, models.LoadParams{}, true},
+		{"composite think time", "phased", models.LoadParams{ThinkTimeMS: 5, Scale: 1}, models.LoadParams{}, true},
+		{"unknown scenario", "missing", models.LoadParams{}, models.LoadParams{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveParams(context.Background(), tt.scenario, tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+
+			// Manager передает в RunBusinessScenario уже разрешенные параметры
+			again, err := ResolveParams(context.Background(), tt.scenario, got)
+			if err != nil {
+				t.Fatalf("second resolve failed: %v", err)
+			}
+			if again != got {
+				t.Fatalf("second resolve changed params: %+v -> %+v", got, again)
+			}
+		})
+	}
+}
+
+func TestResolveParamsPgbenchThinkTime(t *testing.T) {
+	loadTestLibrary(t, testManifests)
+	t.Setenv("LOAD_DRIVER", DriverPgbench)
+
+	tests := []struct {
+		scenario string
+		in       models.LoadParams
+		wantErr  bool
+	}{
+		{"plain", models.LoadParams{}, false},
+		{"plain", models.LoadParams{ThinkTimeMS: 10}, true},
+		{"other", models.LoadParams{}, true},
+		{"phased", models.LoadParams{Scale: 1}, true}, // фаза b запускает other
+	}
+	for _, tt := range tests {
+		_, err := ResolveParams(context.Background(), tt.scenario, tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %+v: err = %v, wantErr %v", tt.scenario, tt.in, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveParamsErrorKind(t *testing.T) {
+	loadTestLibrary(t, testManifests)
+
+	_, err := ResolveParams(context.Background(), "plain", models.LoadParams{Clients: 1000})
+	if !errors.Is(err, ErrInvalidParams) {
+		t.Fatalf("expected ErrInvalidParams, got %v", err)
+	}
+}
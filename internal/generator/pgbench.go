@@ -0,0 +1,314 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// runPgbench — запуск через внешний pgbench (LOAD_DRIVER=pgbench): скрипты
+// передаются как -f файл@вес, :scale — через -D. Вывод по-прежнему идет в лог
+// сервера, но параллельно разбирается в Result: итоговая сводка, строки
+// --progress и агрегированный --log (min/max задержек по интервалам).
+func runPgbench(ctx context.Context, dbUrl string, m *Manifest, params models.LoadParams) (*Result, error) {
+	var args []string
+	if m.OneOff() {
+		// -n: разовые сценарии сами создают или обслуживают таблицы pgbench
+		args = []string{"-n", "-t", strconv.Itoa(m.Transactions), "-c", "1", "-j", "1"}
+	} else {
+		args = []string{
+			"-T", strconv.Itoa(params.DurationSeconds),
+			"-c", strconv.Itoa(params.Clients),
+			"-j", strconv.Itoa(params.Jobs),
+		}
+		if params.Rate > 0 {
+			args = append(args, "-R", strconv.FormatFloat(params.Rate, 'f', -1, 64))
+		}
+	}
+	interval := strconv.Itoa(int(ProgressInterval / time.Second))
+	args = append(args, "--progress="+interval)
+
+	logDir, err := os.MkdirTemp("", "pgbench")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pgbench log dir: %w", err)
+	}
+	defer os.RemoveAll(logDir)
+	args = append(args, "--log", "--aggregate-interval="+interval, "--log-prefix="+filepath.Join(logDir, "pgbench_log"))
+
+	args = append(args, "-D", "scale="+strconv.Itoa(params.Scale))
+	for _, ms := range m.Scripts {
+		args = append(args, "-f", fmt.Sprintf("%s@%d", filepath.Join(ScenariosDir(), ms.File), ms.Weight))
+	}
+	cmd := exec.CommandContext(ctx, "pgbench", append(args, dbUrl)...)
+
+	// Настройка вывода: в лог и в буферы для разбора
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = io.MultiWriter(os.Stdout, &stdout)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+
+	started := time.Now()
+	runErr := cmd.Run()
+	finished := time.Now()
+
+	result := ParsePgbenchOutput(stdout.String(), stderr.String())
+	result.StartedAt, result.FinishedAt = started, finished
+	result.DurationSeconds = finished.Sub(started).Seconds()
+	result.Clients = params.Clients
+
+	logs, _ := filepath.Glob(filepath.Join(logDir, "pgbench_log.*"))
+	if intervals, err := ParsePgbenchLogs(logs); err == nil {
+		result.Progress = mergeLogIntervals(result.Progress, intervals, ProgressInterval)
+	}
+	return result, runErr
+}
+
+// ParsePgbenchOutput разбирает вывод pgbench: сводку из stdout (TPS, задержки,
+// ошибки, статистику по скриптам) и строки --progress и сообщения об ошибках из stderr.
+// Поддерживается формат pgbench 13-17; незнакомые строки пропускаются.
+func ParsePgbenchOutput(stdout, stderr string) *Result {
+	r := &Result{Driver: DriverPgbench, Progress: []ProgressPoint{}}
+
+	var script *ScriptResult
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "SQL script "):
+			r.Scripts = append(r.Scripts, ScriptResult{Latency: LatencyStats{Histogram: []Bucket{}}})
+			script = &r.Scripts[len(r.Scripts)-1]
+			if _, name, ok := strings.Cut(line, ": "); ok {
+				script.Name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+			}
+		case script != nil && strings.HasPrefix(line, "- "):
+			parseScriptLine(script, strings.TrimPrefix(line, "- "))
+		default:
+			script = nil
+			parseSummaryLine(r, line)
+		}
+	}
+	if len(r.Scripts) == 0 && r.Transactions > 0 {
+		// С одним скриптом pgbench печатает только общую сводку
+		r.Scripts = []ScriptResult{{Weight: 1, Transactions: r.Transactions, Errors: r.Errors, TPS: r.TPS, Latency: r.Latency}}
+	}
+	if r.Latency.Histogram == nil {
+		r.Latency.Histogram = []Bucket{}
+	}
+
+	scanner = bufio.NewScanner(strings.NewReader(stderr))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if p, ok := ParseProgressLine(line); ok {
+			r.Progress = append(r.Progress, p)
+			continue
+		}
+		if strings.Contains(line, "error") || strings.Contains(line, "FATAL") {
+			if len(r.ErrorSamples) < maxErrorSamples {
+				r.ErrorSamples = append(r.ErrorSamples, line)
+			}
+		}
+	}
+	return r
+}
+
+func parseSummaryLine(r *Result, line string) {
+	switch {
+	case strings.HasPrefix(line, "number of transactions actually processed:"):
+		// "123456" или "10/10" для -t
+		value := strings.TrimSpace(strings.TrimPrefix(line, "number of transactions actually processed:"))
+		value, _, _ = strings.Cut(value, "/")
+		r.Transactions, _ = strconv.ParseInt(value, 10, 64)
+	case strings.HasPrefix(line, "number of failed transactions:"):
+		r.Errors = firstInt(strings.TrimPrefix(line, "number of failed transactions:"))
+	case strings.HasPrefix(line, "latency average"):
+		r.Latency.Avg = msValue(line)
+	case strings.HasPrefix(line, "latency stddev"):
+		r.Latency.StdDev = msValue(line)
+	case strings.HasPrefix(line, "tps = "):
+		// pgbench 13 печатает две строки (including/excluding connections establishing),
+		// нужна та, что без времени подключения
+		if strings.Contains(line, "including") {
+			return
+		}
+		r.TPS = firstFloat(strings.TrimPrefix(line, "tps = "))
+	}
+}
+
+func parseScriptLine(s *ScriptResult, line string) {
+	switch {
+	case strings.HasPrefix(line, "weight:"):
+		s.Weight = int(firstInt(strings.TrimPrefix(line, "weight:")))
+	case strings.Contains(line, " transactions ("):
+		// "600 transactions (49.6% of total, tps = 9.9)"
+		s.Transactions = firstInt(line)
+		if _, tps, ok := strings.Cut(line, "tps = "); ok {
+			s.TPS = firstFloat(tps)
+		}
+	case strings.HasPrefix(line, "number of failed transactions:"):
+		s.Errors = firstInt(strings.TrimPrefix(line, "number of failed transactions:"))
+	case strings.HasPrefix(line, "latency average"):
+		s.Latency.Avg = msValue(line)
+	case strings.HasPrefix(line, "latency stddev"):
+		s.Latency.StdDev = msValue(line)
+	}
+}
+
+// ParseProgressLine разбирает строку pgbench --progress:
+// "progress: 5.0 s, 1587.2 tps, lat 31.403 ms stddev 12.345, 0 failed, lag 0.120 ms"
+// (failed — с pgbench 15, lag — только при -R)
+func ParseProgressLine(line string) (ProgressPoint, bool) {
+	if !strings.HasPrefix(line, "progress: ") {
+		return ProgressPoint{}, false
+	}
+	var p ProgressPoint
+	parsed := false
+	for _, part := range strings.Split(strings.TrimPrefix(line, "progress: "), ", ") {
+		fields := strings.Fields(part)
+		switch {
+		case len(fields) == 2 && fields[1] == "s":
+			p.Elapsed, parsed = firstFloat(fields[0]), true
+		case len(fields) == 2 && fields[1] == "tps":
+			p.TPS = firstFloat(fields[0])
+		case len(fields) >= 3 && fields[0] == "lat":
+			p.LatencyAvg = firstFloat(fields[1])
+			if len(fields) >= 5 && fields[3] == "stddev" {
+				p.LatencyStdDev = firstFloat(fields[4])
+			}
+		case len(fields) == 2 && fields[1] == "failed":
+			p.Failed = firstInt(fields[0])
+		case len(fields) >= 2 && fields[0] == "lag":
+			p.Lag = firstFloat(fields[1])
+		}
+	}
+	return p, parsed
+}
+
+// LogInterval — строка агрегированного лога pgbench (--log --aggregate-interval),
+// сведенная по всем потокам. Задержки в миллисекундах.
+type LogInterval struct {
+	Start        time.Time
+	Transactions int64
+	SumLatency   float64
+	SumLatency2  float64
+	MinLatency   float64
+	MaxLatency   float64
+}
+
+// ParsePgbenchLogs читает файлы агрегированного лога pgbench (по одному на поток)
+// и сводит интервалы с одинаковым началом. Формат строки:
+// interval_start num_transactions sum_latency sum_latency_2 min_latency max_latency ...
+// (задержки в микросекундах, остальные поля зависят от версии и опций и не читаются).
+func ParsePgbenchLogs(files []string) ([]LogInterval, error) {
+	byStart := make(map[int64]*LogInterval)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pgbench log: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 6 {
+				continue
+			}
+			var values [6]float64
+			ok := true
+			for i := range values {
+				if values[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+					ok = false
+					break
+				}
+			}
+			if !ok || values[1] == 0 {
+				continue
+			}
+			start := int64(values[0])
+			iv, exists := byStart[start]
+			if !exists {
+				iv = &LogInterval{Start: time.Unix(start, 0), MinLatency: math.Inf(1)}
+				byStart[start] = iv
+			}
+			iv.Transactions += int64(values[1])
+			iv.SumLatency += values[2] / 1000
+			iv.SumLatency2 += values[3] / 1e6
+			iv.MinLatency = math.Min(iv.MinLatency, values[4]/1000)
+			iv.MaxLatency = math.Max(iv.MaxLatency, values[5]/1000)
+		}
+	}
+
+	intervals := make([]LogInterval, 0, len(byStart))
+	for _, iv := range byStart {
+		intervals = append(intervals, *iv)
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].Start.Before(intervals[j].Start) })
+	return intervals, nil
+}
+
+// mergeLogIntervals дополняет точки --progress задержками min/max из агрегированного лога.
+// Интервалы лога отсчитываются от первой транзакции, поэтому сопоставляются по номеру.
+// Если строк --progress нет (например, pgbench прерван), точки строятся по логу.
+func mergeLogIntervals(progress []ProgressPoint, intervals []LogInterval, step time.Duration) []ProgressPoint {
+	if len(intervals) == 0 {
+		return progress
+	}
+	if len(progress) == 0 {
+		first := intervals[0].Start
+		for _, iv := range intervals {
+			n := float64(iv.Transactions)
+			avg := iv.SumLatency / n
+			progress = append(progress, ProgressPoint{
+				Elapsed:       iv.Start.Sub(first).Seconds() + step.Seconds(),
+				TPS:           n / step.Seconds(),
+				LatencyAvg:    avg,
+				LatencyStdDev: math.Sqrt(math.Max(iv.SumLatency2/n-avg*avg, 0)),
+				LatencyMin:    iv.MinLatency,
+				LatencyMax:    iv.MaxLatency,
+			})
+		}
+		return progress
+	}
+	first := intervals[0].Start
+	for _, iv := range intervals {
+		i := int(iv.Start.Sub(first) / step)
+		if i < len(progress) {
+			progress[i].LatencyMin, progress[i].LatencyMax = iv.MinLatency, iv.MaxLatency
+		}
+	}
+	return progress
+}
+
+// msValue — значение "latency average = 24.3 ms"
+func msValue(line string) float64 {
+	_, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return 0
+	}
+	return firstFloat(value)
+}
+
+// firstFloat — первое число в строке. NaN (pgbench печатает "lat NaN ms" и
+// "stddev -nan" за интервалы без транзакций) и бесконечности пропускаются:
+// JSON их не кодирует.
+func firstFloat(s string) float64 {
+	for _, f := range strings.Fields(s) {
+		v, err := strconv.ParseFloat(strings.Trim(f, "(),%"), 64)
+		if err == nil && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			return v
+		}
+	}
+	return 0
+}
+
+func firstInt(s string) int64 {
+	return int64(firstFloat(s))
+}
@@ -0,0 +1,242 @@
+package generator
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Вывод pgbench 16 с двумя скриптами и -R
+const pgbench16Stdout = `pgbench (16.2)
+transaction type: multiple scripts
+scaling factor: 10
+query mode: simple
+number of clients: 10
+number of threads: 2
+maximum number of tries: 1
+duration: 60 s
+number of transactions actually processed: 1210
+number of failed transactions: 3 (0.247%)
+latency average = 24.310 ms
+latency stddev = 8.125 ms
+rate limit schedule lag: avg 0.120 (max 3.500) ms
+initial connection time = 12.345 ms
+tps = 20.163874 (without initial connection time)
+SQL script 1: /app/scenarios/tpcb.sql
+ - weight: 3 (targets 75.0% of total)
+ - 900 transactions (74.4% of total, tps = 14.997)
+ - number of failed transactions: 2 (0.222%)
+ - latency average = 25.100 ms
+ - latency stddev = 8.000 ms
+SQL script 2: /app/scenarios/olap.sql
+ - weight: 1 (targets 25.0% of total)
+ - 310 transactions (25.6% of total, tps = 5.166)
+ - number of failed transactions: 1 (0.322%)
+ - latency average = 22.000 ms
+ - latency stddev = 7.500 ms
+`
+
+// Вывод pgbench 13 с одним скриптом: две строки tps и без failed
+const pgbench13Stdout = `transaction type: <builtin: TPC-B (sort of)>
+scaling factor: 1
+number of transactions actually processed: 10/10
+latency average = 3.500 ms
+tps = 280.100000 (including connections establishing)
+tps = 285.714286 (excluding connections establishing)
+`
+
+func TestParsePgbenchOutput(t *testing.T) {
+	tests := []struct {
+		name         string
+		stdout       string
+		stderr       string
+		transactions int64
+		errors       int64
+		tps          float64
+		avg, stddev  float64
+		scripts      []ScriptResult
+		progress     int
+		errorSamples int
+	}{
+		{
+			name: "pgbench 16, two scripts", stdout: pgbench16Stdout,
+			stderr: "progress: 5.0 s, 20.2 tps, lat 24.1 ms stddev 8.0, 0 failed, lag 0.1 ms\n" +
+				"pgbench: error: client 3 script 0 aborted in command 4 query 0: ERROR:  deadlock detected\n" +
+				"progress: 10.0 s, 19.8 tps, lat 24.5 ms stddev 8.2, 3 failed, lag 0.2 ms\n",
+			transactions: 1210, errors: 3, tps: 20.163874, avg: 24.31, stddev: 8.125,
+			scripts: []ScriptResult{
+				{Name: "tpcb", Weight: 3, Transactions: 900, Errors: 2, TPS: 14.997, Latency: LatencyStats{Avg: 25.1, StdDev: 8}},
+				{Name: "olap", Weight: 1, Transactions: 310, Errors: 1, TPS: 5.166, Latency: LatencyStats{Avg: 22, StdDev: 7.5}},
+			},
+			progress: 2, errorSamples: 1,
+		},
+		{
+			name: "pgbench 13, one script", stdout: pgbench13Stdout,
+			transactions: 10, tps: 285.714286, avg: 3.5,
+			scripts: []ScriptResult{{Weight: 1, Transactions: 10, TPS: 285.714286, Latency: LatencyStats{Avg: 3.5}}},
+		},
+		{
+			name: "aborted before summary", stdout: "pgbench (16.2)\n",
+			stderr:       "pgbench: error: connection to server failed: FATAL:  database \"x\" does not exist\n",
+			errorSamples: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ParsePgbenchOutput(tt.stdout, tt.stderr)
+			if r.Driver != DriverPgbench {
+				t.Errorf("driver = %q", r.Driver)
+			}
+			if r.Transactions != tt.transactions || r.Errors != tt.errors {
+				t.Errorf("transactions/errors = %d/%d, want %d/%d", r.Transactions, r.Errors, tt.transactions, tt.errors)
+			}
+			if !near(r.TPS, tt.tps) || !near(r.Latency.Avg, tt.avg) || !near(r.Latency.StdDev, tt.stddev) {
+				t.Errorf("tps %v, latency %v/%v, want %v, %v/%v", r.TPS, r.Latency.Avg, r.Latency.StdDev, tt.tps, tt.avg, tt.stddev)
+			}
+			if len(r.Scripts) != len(tt.scripts) {
+				t.Fatalf("got %d scripts, want %d: %+v", len(r.Scripts), len(tt.scripts), r.Scripts)
+			}
+			for i, want := range tt.scripts {
+				got := r.Scripts[i]
+				if got.Name != want.Name || got.Weight != want.Weight || got.Transactions != want.Transactions ||
+					got.Errors != want.Errors || !near(got.TPS, want.TPS) ||
+					!near(got.Latency.Avg, want.Latency.Avg) || !near(got.Latency.StdDev, want.Latency.StdDev) {
+					t.Errorf("script %d = %+v, want %+v", i, got, want)
+				}
+			}
+			if len(r.Progress) != tt.progress {
+				t.Errorf("got %d progress points, want %d", len(r.Progress), tt.progress)
+			}
+			if len(r.ErrorSamples) != tt.errorSamples {
+				t.Errorf("got error samples %q, want %d", r.ErrorSamples, tt.errorSamples)
+			}
+			if r.Latency.Histogram == nil {
+				t.Error("latency histogram must not be nil")
+			}
+		})
+	}
+}
+
+func TestParseProgressLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want ProgressPoint
+		ok   bool
+	}{
+		{"progress: 5.0 s, 1587.2 tps, lat 31.403 ms stddev 12.345, 0 failed, lag 0.120 ms",
+			ProgressPoint{Elapsed: 5, TPS: 1587.2, LatencyAvg: 31.403, LatencyStdDev: 12.345, Lag: 0.12}, true},
+		{"progress: 10.0 s, 99.8 tps, lat 10.020 ms stddev 1.500, 7 failed",
+			ProgressPoint{Elapsed: 10, TPS: 99.8, LatencyAvg: 10.02, LatencyStdDev: 1.5, Failed: 7}, true},
+		{"progress: 15.0 s, 42.0 tps, lat 23.800 ms stddev 4.100", // pgbench 13-14: без failed
+			ProgressPoint{Elapsed: 15, TPS: 42, LatencyAvg: 23.8, LatencyStdDev: 4.1}, true},
+		{"progress: 20.0 s, 0.0 tps, lat NaN ms stddev NaN, 0 failed",
+			ProgressPoint{Elapsed: 20}, true},
+		{"progress: 25.0 s, 0.0 tps, lat 0.000 ms stddev -nan, 0 failed",
+			ProgressPoint{Elapsed: 25}, true},
+		{"progress: bogus", ProgressPoint{}, false},
+		{"latency average = 3.5 ms", ProgressPoint{}, false},
+		{"", ProgressPoint{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := ParseProgressLine(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			if !near(got.Elapsed, tt.want.Elapsed) || !near(got.TPS, tt.want.TPS) ||
+				!near(got.LatencyAvg, tt.want.LatencyAvg) || !near(got.LatencyStdDev, tt.want.LatencyStdDev) ||
+				got.Failed != tt.want.Failed || !near(got.Lag, tt.want.Lag) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePgbenchLogs(t *testing.T) {
+	dir := t.TempDir()
+	// Два потока; задержки в микросекундах
+	files := map[string]string{
+		"pgbench_log.100":   "1700000000 10 50000 300000000 2000 9000 0 0\n1700000005 4 20000 110000000 3000 7000 0 0\n",
+		"pgbench_log.100.1": "1700000000 5 30000 200000000 1000 12000 0 0\n1700000005 0 0 0 0 0 0 0\ngarbage line\n",
+	}
+	var paths []string
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	intervals, err := ParsePgbenchLogs(paths)
+	if err != nil {
+		t.Fatalf("ParsePgbenchLogs: %v", err)
+	}
+	want := []LogInterval{
+		{Start: time.Unix(1700000000, 0), Transactions: 15, SumLatency: 80, SumLatency2: 500, MinLatency: 1, MaxLatency: 12},
+		{Start: time.Unix(1700000005, 0), Transactions: 4, SumLatency: 20, SumLatency2: 110, MinLatency: 3, MaxLatency: 7},
+	}
+	if len(intervals) != len(want) {
+		t.Fatalf("got %d intervals, want %d: %+v", len(intervals), len(want), intervals)
+	}
+	for i, w := range want {
+		got := intervals[i]
+		if !got.Start.Equal(w.Start) || got.Transactions != w.Transactions || !near(got.SumLatency, w.SumLatency) ||
+			!near(got.SumLatency2, w.SumLatency2) || !near(got.MinLatency, w.MinLatency) || !near(got.MaxLatency, w.MaxLatency) {
+			t.Errorf("interval %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	if _, err := ParsePgbenchLogs([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Error("expected error for a missing log file")
+	}
+}
+
+func TestMergeLogIntervals(t *testing.T) {
+	step := 5 * time.Second
+	intervals := []LogInterval{
+		{Start: time.Unix(100, 0), Transactions: 10, SumLatency: 50, SumLatency2: 260, MinLatency: 1, MaxLatency: 9},
+		{Start: time.Unix(110, 0), Transactions: 5, SumLatency: 10, SumLatency2: 20, MinLatency: 2, MaxLatency: 3},
+	}
+
+	tests := []struct {
+		name      string
+		intervals []LogInterval
+		progress  []ProgressPoint
+		want      []ProgressPoint
+	}{
+		{"no log", nil, []ProgressPoint{{Elapsed: 5}}, []ProgressPoint{{Elapsed: 5}}},
+		{"fills min and max by interval number", intervals,
+			[]ProgressPoint{{Elapsed: 5, TPS: 2}, {Elapsed: 10, TPS: 0}, {Elapsed: 15, TPS: 1}},
+			[]ProgressPoint{{Elapsed: 5, TPS: 2, LatencyMin: 1, LatencyMax: 9}, {Elapsed: 10}, {Elapsed: 15, TPS: 1, LatencyMin: 2, LatencyMax: 3}}},
+		{"builds points without progress", intervals, nil,
+			[]ProgressPoint{
+				{Elapsed: 5, TPS: 2, LatencyAvg: 5, LatencyStdDev: 1, LatencyMin: 1, LatencyMax: 9},
+				{Elapsed: 15, TPS: 1, LatencyAvg: 2, LatencyStdDev: 0, LatencyMin: 2, LatencyMax: 3},
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeLogIntervals(tt.progress, tt.intervals, step)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d points, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, w := range tt.want {
+				g := got[i]
+				if !near(g.Elapsed, w.Elapsed) || !near(g.TPS, w.TPS) || !near(g.LatencyAvg, w.LatencyAvg) ||
+					!near(g.LatencyStdDev, w.LatencyStdDev) || !near(g.LatencyMin, w.LatencyMin) || !near(g.LatencyMax, w.LatencyMax) {
+					t.Errorf("point %d = %+v, want %+v", i, g, w)
+				}
+			}
+		})
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
@@ -0,0 +1,227 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// PhaseResult — итог одной фазы составного сценария: результаты ее сценариев по отдельности
+type PhaseResult struct {
+	Label           string    `json:"label"`
+	ExpectedProfile string    `json:"expected_profile,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	Workloads       []*Result `json:"workloads"`
+}
+
+// runPhases выполняет фазы составного сценария одну за другой; сценарии внутри
+// фазы идут одновременно. Итоговый Result сводит все фазы: транзакции и ошибки
+// суммируются, задержки объединяются по гистограммам, кривые — по интервалам
+// от начала всего прогона.
+func runPhases(ctx context.Context, dbUrl string, m *Manifest, params models.LoadParams, onPhase func(Phase)) (*Result, error) {
+	// Схемы всех сценариев готовятся заранее, чтобы setup не съедал время фаз
+	prepared := make(map[string]bool)
+	for _, ph := range m.Phases {
+		for _, wl := range ph.Workloads {
+			if prepared[wl.Scenario] {
+				continue
+			}
+			sub, ok := LookupScenario(wl.Scenario)
+			if !ok {
+				return nil, fmt.Errorf("phase %s: scenario %s not found", ph.Label, wl.Scenario)
+			}
+			if err := ensureSetup(ctx, dbUrl, sub); err != nil {
+				return nil, err
+			}
+			prepared[wl.Scenario] = true
+		}
+	}
+
+	result := &Result{Driver: DriverFromEnv(), StartedAt: time.Now(), Clients: params.Clients}
+	var runErr error
+	for _, ph := range m.Phases {
+		if ctx.Err() != nil {
+			break
+		}
+		if onPhase != nil {
+			onPhase(ph)
+		}
+		pr, err := runPhase(ctx, dbUrl, ph, params.Scale)
+		result.Phases = append(result.Phases, pr)
+		if err != nil {
+			runErr = fmt.Errorf("phase %s: %w", ph.Label, err)
+			break
+		}
+	}
+	result.FinishedAt = time.Now()
+	result.DurationSeconds = result.FinishedAt.Sub(result.StartedAt).Seconds()
+	mergePhaseResults(result)
+	if runErr == nil {
+		runErr = ctx.Err()
+	}
+	return result, runErr
+}
+
+func runPhase(ctx context.Context, dbUrl string, ph Phase, scale int) (PhaseResult, error) {
+	pr := PhaseResult{Label: ph.Label, ExpectedProfile: ph.ExpectedProfile, StartedAt: time.Now()}
+	results := make([]*Result, len(ph.Workloads))
+	errs := make([]error, len(ph.Workloads))
+
+	var wg sync.WaitGroup
+	for i, wl := range ph.Workloads {
+		params, err := ResolveParams(ctx, wl.Scenario, models.LoadParams{
+			DurationSeconds: int(ph.duration / time.Second),
+			Clients:         wl.Clients,
+			Rate:            wl.Rate,
+			Scale:           scale,
+		})
+		if err != nil {
+			errs[i] = fmt.Errorf("%s: %w", wl.Scenario, err)
+			continue
+		}
+		sub, ok := LookupScenario(wl.Scenario)
+		if !ok {
+			errs[i] = fmt.Errorf("scenario %s not found", wl.Scenario)
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, wl PhaseWorkload) {
+			defer wg.Done()
+			log.Printf("[GENERATOR] Phase %s: %s with %d clients, rate %.0f", ph.Label, wl.Scenario, params.Clients, params.Rate)
+			res, err := runManifest(ctx, dbUrl, sub, params, wl.ramp)
+			if res != nil {
+				res.Scenario, res.Params = wl.Scenario, params
+			}
+			if err != nil {
+				err = fmt.Errorf("%s: %w", wl.Scenario, err)
+			}
+			results[i], errs[i] = res, err
+		}(i, wl)
+	}
+	wg.Wait()
+
+	pr.FinishedAt = time.Now()
+	for _, res := range results {
+		if res != nil {
+			pr.Workloads = append(pr.Workloads, res)
+		}
+	}
+	return pr, errors.Join(errs...)
+}
+
+// mergePhaseResults сводит результаты сценариев всех фаз в result
+func mergePhaseResults(result *Result) {
+	var latencies []LatencyStats
+	var counts []int64
+	progress := make(map[int]*progressSum)
+	for _, pr := range result.Phases {
+		offset := pr.StartedAt.Sub(result.StartedAt).Seconds()
+		for _, res := range pr.Workloads {
+			result.Transactions += res.Transactions
+			result.Errors += res.Errors
+			latencies = append(latencies, res.Latency)
+			counts = append(counts, res.Transactions)
+			for _, s := range res.Scripts {
+				s.Name = res.Scenario + "/" + s.Name
+				result.Scripts = append(result.Scripts, s)
+			}
+			for _, sample := range res.ErrorSamples {
+				if len(result.ErrorSamples) < maxErrorSamples {
+					result.ErrorSamples = append(result.ErrorSamples, sample)
+				}
+			}
+			for _, p := range res.Progress {
+				// Точки сценариев одной фазы идут с одинаковым шагом и сводятся по номеру интервала
+				i := int(math.Round((offset + p.Elapsed) / ProgressInterval.Seconds()))
+				if progress[i] == nil {
+					progress[i] = &progressSum{}
+				}
+				progress[i].add(p)
+			}
+		}
+	}
+	if result.DurationSeconds > 0 {
+		result.TPS = float64(result.Transactions) / result.DurationSeconds
+	}
+	result.Latency = mergeLatency(latencies, counts)
+
+	steps := make([]int, 0, len(progress))
+	for i := range progress {
+		steps = append(steps, i)
+	}
+	sort.Ints(steps)
+	result.Progress = make([]ProgressPoint, 0, len(steps))
+	for _, i := range steps {
+		result.Progress = append(result.Progress, progress[i].point(float64(i)*ProgressInterval.Seconds()))
+	}
+}
+
+// progressSum накапливает точки нескольких сценариев за один интервал;
+// задержки взвешиваются по TPS
+type progressSum struct {
+	tps, sumAvg, sumSq, lag float64
+	min, max                float64
+	failed                  int64
+}
+
+func (s *progressSum) add(p ProgressPoint) {
+	s.tps += p.TPS
+	s.sumAvg += p.TPS * p.LatencyAvg
+	s.sumSq += p.TPS * (p.LatencyStdDev*p.LatencyStdDev + p.LatencyAvg*p.LatencyAvg)
+	if p.LatencyMin > 0 && (s.min == 0 || p.LatencyMin < s.min) {
+		s.min = p.LatencyMin
+	}
+	s.max = math.Max(s.max, p.LatencyMax)
+	s.lag = math.Max(s.lag, p.Lag)
+	s.failed += p.Failed
+}
+
+func (s *progressSum) point(elapsed float64) ProgressPoint {
+	p := ProgressPoint{Elapsed: elapsed, TPS: s.tps, LatencyMin: s.min, LatencyMax: s.max, Failed: s.failed, Lag: s.lag}
+	if s.tps > 0 {
+		p.LatencyAvg = s.sumAvg / s.tps
+		p.LatencyStdDev = math.Sqrt(math.Max(s.sumSq/s.tps-p.LatencyAvg*p.LatencyAvg, 0))
+	}
+	return p
+}
+
+// mergeLatency объединяет задержки нескольких прогонов (counts — число
+// транзакций каждого). Перцентили пересчитываются по гистограммам; если хотя бы
+// у одного прогона гистограммы нет (pgbench), они не заполняются.
+func mergeLatency(parts []LatencyStats, counts []int64) LatencyStats {
+	h := newHistogram()
+	withHistograms := true
+	for i, st := range parts {
+		n := float64(counts[i])
+		if counts[i] <= 0 {
+			continue
+		}
+		if len(st.Histogram) == 0 {
+			withHistograms = false
+		}
+		for _, b := range st.Histogram {
+			h.counts[min(sort.SearchFloat64s(bucketBounds, b.UpperMs), len(bucketBounds))] += b.Count
+		}
+		if h.count == 0 || st.Min < h.min {
+			h.min = st.Min
+		}
+		h.max = math.Max(h.max, st.Max)
+		h.count += counts[i]
+		h.sum += st.Avg * n
+		h.sumSq += (st.StdDev*st.StdDev + st.Avg*st.Avg) * n
+	}
+	st := h.stats()
+	if !withHistograms {
+		st.P50, st.P95, st.P99, st.Histogram = 0, 0, 0, []Bucket{}
+	}
+	return st
+}
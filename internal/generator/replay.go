@@ -0,0 +1,439 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Пределы захвата нагрузки
+const (
+	MaxCaptureWindow     = 10 * time.Minute
+	MaxCaptureStatements = 100
+	defaultCaptureTop    = 20
+	replayPrefix         = "replay_"
+)
+
+// ErrInvalidCapture — захват нельзя сохранить как сценарий (нет генераторов, плохое имя...)
+var ErrInvalidCapture = errors.New("invalid capture")
+
+// CaptureOptions — что и как долго захватывать
+type CaptureOptions struct {
+	Name     string        // имя будущего сценария (replay_<name>)
+	Window   time.Duration // 0 — частоты по накопленным счетчикам с последнего сброса
+	Top      int           // сколько самых частых запросов взять
+	Database string        // только запросы этой базы; пусто — все базы
+}
+
+// Capture — снимок реальной нагрузки: нормализованные запросы pg_stat_statements,
+// их частоты и генераторы параметров. Сохраняется как сценарий через SaveReplay.
+type Capture struct {
+	Name          string              `json:"name"`
+	CapturedAt    time.Time           `json:"captured_at"`
+	WindowSeconds float64             `json:"window_seconds"` // за какой период посчитаны частоты
+	Database      string              `json:"database,omitempty"`
+	CallsPerSec   float64             `json:"calls_per_sec"`    // суммарная частота захваченных запросов
+	Target        string              `json:"target,omitempty"` // база для воспроизведения (см. TargetDSN)
+	Statements    []CapturedStatement `json:"statements"`
+}
+
+// CapturedStatement — один нормализованный запрос и генераторы для $1..$n
+type CapturedStatement struct {
+	QueryID     int64            `json:"query_id"`
+	Query       string           `json:"query"`
+	Calls       int64            `json:"calls"`
+	CallsPerSec float64          `json:"calls_per_sec"`
+	MeanTimeMs  float64          `json:"mean_time_ms"`
+	ASHSamples  int64            `json:"ash_samples"`       // сколько раз запрос попал в ash_samples
+	Example     string           `json:"example,omitempty"` // текст из ash_samples с реальными значениями
+	Params      []ParamGenerator `json:"params"`
+}
+
+// ParamGenerator — чем заполнять параметр при воспроизведении: целочисленное
+// выражение \set (random(1, 100000 * :scale)) или константа. Значение по
+// умолчанию берется из примера в ash_samples, если его удалось сопоставить.
+type ParamGenerator struct {
+	Expr  string  `json:"expr,omitempty"`
+	Value *string `json:"value,omitempty"`
+}
+
+var (
+	placeholderRe = regexp.MustCompile(`\$(\d+)`)
+	replayNameRe  = regexp.MustCompile(`^[a-z0-9_]{1,40}$`)
+	// Те же команды, что отбирает readStatements
+	replayQueryRe = regexp.MustCompile(`(?i)^\s*(select|insert|update|delete|with)\b`)
+)
+
+// CaptureWorkload снимает нагрузку из pg_stat_statements (вызовы за Window или с
+// последнего сброса) и дополняет запросы примерами из profile_metrics.ash_samples.
+// Служебные запросы (каталог, статистика, сама схема profile_metrics) и команды
+// кроме SELECT/INSERT/UPDATE/DELETE/WITH не захватываются.
+func CaptureWorkload(ctx context.Context, pool *pgxpool.Pool, opts CaptureOptions) (*Capture, error) {
+	if !replayNameRe.MatchString(opts.Name) {
+		return nil, fmt.Errorf("%w: name must match %s", ErrInvalidCapture, replayNameRe)
+	}
+	if opts.Window < 0 || opts.Window > MaxCaptureWindow {
+		return nil, fmt.Errorf("%w: window must be between 0 and %s", ErrInvalidCapture, MaxCaptureWindow)
+	}
+	if opts.Top == 0 {
+		opts.Top = defaultCaptureTop
+	}
+	if opts.Top < 1 || opts.Top > MaxCaptureStatements {
+		return nil, fmt.Errorf("%w: top must be between 1 and %d", ErrInvalidCapture, MaxCaptureStatements)
+	}
+
+	before, err := readStatements(ctx, pool, opts.Database)
+	if err != nil {
+		return nil, err
+	}
+	window := 0.0
+	current := before
+	if opts.Window > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(opts.Window):
+		}
+		if current, err = readStatements(ctx, pool, opts.Database); err != nil {
+			return nil, err
+		}
+		window = opts.Window.Seconds()
+	} else if err := pool.QueryRow(ctx, `
+		SELECT COALESCE(extract(epoch FROM now() - stats_reset), 0)::float8 FROM pg_stat_statements_info
+	`).Scan(&window); err != nil {
+		return nil, fmt.Errorf("failed to read pg_stat_statements reset time: %w", err)
+	}
+
+	c := &Capture{Name: opts.Name, CapturedAt: time.Now(), WindowSeconds: window, Database: opts.Database}
+	for id, cur := range current {
+		calls := cur.Calls
+		if prev, ok := before[id]; ok && opts.Window > 0 && cur.Calls >= prev.Calls {
+			calls = cur.Calls - prev.Calls
+		}
+		if calls == 0 {
+			continue
+		}
+		cur.Calls = calls
+		if window > 0 {
+			cur.CallsPerSec = float64(calls) / window
+		}
+		c.Statements = append(c.Statements, cur)
+	}
+	sort.Slice(c.Statements, func(i, j int) bool { return c.Statements[i].Calls > c.Statements[j].Calls })
+	if len(c.Statements) > opts.Top {
+		c.Statements = c.Statements[:opts.Top]
+	}
+	if len(c.Statements) == 0 {
+		return nil, fmt.Errorf("%w: no statements were executed in the capture window", ErrInvalidCapture)
+	}
+
+	if err := attachExamples(ctx, pool, c, opts.Window); err != nil {
+		return nil, err
+	}
+	for i := range c.Statements {
+		s := &c.Statements[i]
+		s.Params = inferParams(s.Query, s.Example)
+		c.CallsPerSec += s.CallsPerSec
+	}
+	return c, nil
+}
+
+func readStatements(ctx context.Context, pool *pgxpool.Pool, database string) (map[int64]CapturedStatement, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT s.queryid, min(s.query), sum(s.calls)::bigint,
+			COALESCE(sum(s.total_exec_time) / NULLIF(sum(s.calls), 0), 0)::float8
+		FROM pg_stat_statements s
+		JOIN pg_database d ON d.oid = s.dbid
+		WHERE ($1 = '' OR d.datname = $1)
+		  AND s.queryid IS NOT NULL
+		  AND s.query ~* '^\s*(select|insert|update|delete|with)\M'
+		  AND s.query !~* '(profile_metrics|pg_stat|pg_catalog|pg_settings|information_schema)'
+		GROUP BY s.queryid
+	`, database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pg_stat_statements: %w", err)
+	}
+	defer rows.Close()
+
+	statements := make(map[int64]CapturedStatement)
+	for rows.Next() {
+		var s CapturedStatement
+		if err := rows.Scan(&s.QueryID, &s.Query, &s.Calls, &s.MeanTimeMs); err != nil {
+			return nil, fmt.Errorf("failed to scan pg_stat_statements: %w", err)
+		}
+		statements[s.QueryID] = s
+	}
+	return statements, rows.Err()
+}
+
+// attachExamples берет из ash_samples число семплов и самый свежий текст каждого запроса
+// (за окно захвата, но не меньше часа: ASH видит только долгие запросы)
+func attachExamples(ctx context.Context, pool *pgxpool.Pool, c *Capture, window time.Duration) error {
+	ids := make([]int64, len(c.Statements))
+	for i, s := range c.Statements {
+		ids[i] = s.QueryID
+	}
+	since := time.Now().Add(-max(window, time.Hour))
+
+	rows, err := pool.Query(ctx, `
+		SELECT query_id, count(*), (array_agg(query ORDER BY sample_time DESC))[1]
+		FROM profile_metrics.ash_samples
+		WHERE query_id = ANY($1) AND sample_time >= $2
+		GROUP BY query_id
+	`, ids, since)
+	if err != nil {
+		return fmt.Errorf("failed to read ash samples: %w", err)
+	}
+	defer rows.Close()
+
+	index := make(map[int64]int, len(c.Statements))
+	for i, s := range c.Statements {
+		index[s.QueryID] = i
+	}
+	for rows.Next() {
+		var id, samples int64
+		var example *string
+		if err := rows.Scan(&id, &samples, &example); err != nil {
+			return fmt.Errorf("failed to scan ash samples: %w", err)
+		}
+		s := &c.Statements[index[id]]
+		s.ASHSamples = samples
+		if example != nil {
+			s.Example = *example
+		}
+	}
+	return rows.Err()
+}
+
+// inferParams сопоставляет нормализованный текст (с $n) с примером из ASH и
+// достает литералы на местах параметров. Пример обрезан до 200 символов, поэтому
+// для параметров в хвосте длинного запроса генератор остается пустым.
+func inferParams(query, example string) []ParamGenerator {
+	count := 0
+	for _, m := range placeholderRe.FindAllStringSubmatch(query, -1) {
+		n, _ := strconv.Atoi(m[1])
+		count = max(count, n)
+	}
+	params := make([]ParamGenerator, count)
+	if example == "" {
+		return params
+	}
+
+	locs := placeholderRe.FindAllStringSubmatchIndex(query, -1)
+	pos, prevEnd := 0, 0
+	for i, loc := range locs {
+		segment := query[prevEnd:loc[0]]
+		if !strings.HasPrefix(example[pos:], segment) {
+			return params
+		}
+		pos += len(segment)
+
+		// Литерал продолжается до начала следующего фиксированного куска запроса
+		next := query[loc[1]:]
+		if i+1 < len(locs) {
+			next = query[loc[1]:locs[i+1][0]]
+		}
+		end := len(example)
+		if next != "" {
+			idx := strings.Index(example[pos:], next)
+			if idx < 0 {
+				return params
+			}
+			end = pos + idx
+		}
+
+		n, _ := strconv.Atoi(query[loc[2]:loc[3]])
+		value := strings.TrimSpace(example[pos:end])
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		}
+		if params[n-1].Value == nil && params[n-1].Expr == "" {
+			params[n-1].Value = &value
+		}
+		pos, prevEnd = end, loc[1]
+	}
+	return params
+}
+
+// SaveReplay превращает захват в сценарий библиотеки: по скрипту на запрос
+// (вес — доля вызовов, каждый запрос — отдельная транзакция) и манифест
+// replay_<name>.json с записанной частотой как rate по умолчанию.
+// Файлы пишутся в dir, после чего библиотека перечитывается.
+//
+// Текст запросов из c не используется: он читается заново из pg_stat_statements
+// по query_id (с теми же фильтрами, что при захвате), так что сохранить можно
+// только запрос, который действительно выполнялся. Из c берутся частоты и генераторы.
+func SaveReplay(ctx context.Context, pool *pgxpool.Pool, dir string, c Capture) (*Manifest, error) {
+	if !replayNameRe.MatchString(c.Name) {
+		return nil, fmt.Errorf("%w: name must match %s", ErrInvalidCapture, replayNameRe)
+	}
+	if len(c.Statements) == 0 {
+		return nil, fmt.Errorf("%w: capture has no statements", ErrInvalidCapture)
+	}
+	if c.Target != "" && !targetNameRe.MatchString(c.Target) {
+		return nil, fmt.Errorf("%w: target must match %s", ErrInvalidCapture, targetNameRe)
+	}
+	name := replayPrefix + c.Name
+
+	known, err := readStatements(ctx, pool, c.Database)
+	if err != nil {
+		return nil, err
+	}
+	for i := range c.Statements {
+		s := &c.Statements[i]
+		cur, ok := known[s.QueryID]
+		if !ok {
+			return nil, fmt.Errorf("%w: statement %d: query_id %d is not in pg_stat_statements", ErrInvalidCapture, i+1, s.QueryID)
+		}
+		s.Query = cur.Query
+	}
+
+	var total int64
+	for _, s := range c.Statements {
+		total += s.Calls
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("%w: statements have no calls", ErrInvalidCapture)
+	}
+
+	m := Manifest{
+		Name:        name,
+		Title:       "Replay: " + c.Name,
+		Description: fmt.Sprintf("Воспроизведение нагрузки, захваченной %s (запросов: %d, %.1f вызовов/с)", c.CapturedAt.Format(time.RFC3339), len(c.Statements), c.CallsPerSec),
+		Clients:     10,
+		Jobs:        2,
+		MaxClients:  500,
+		Duration:    "1m",
+		Rate:        math.Min(math.Round(c.CallsPerSec*100)/100, MaxRate),
+		Target:      c.Target,
+	}
+	scripts := make(map[string]string, len(c.Statements))
+	for i, s := range c.Statements {
+		text, err := replayScript(c.Name, s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: statement %d (query_id %d): %v", ErrInvalidCapture, i+1, s.QueryID, err)
+		}
+		file := fmt.Sprintf("%s_%d.sql", name, i+1)
+		script, err := ParseScript(file, text)
+		if err == nil {
+			err = checkReplayScript(script)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: statement %d (query_id %d): %v", ErrInvalidCapture, i+1, s.QueryID, err)
+		}
+		scripts[file] = text
+		// Вес — доля вызовов в тысячных, но не меньше 1
+		weight := max(int(math.Round(float64(s.Calls)/float64(total)*1000)), 1)
+		m.Scripts = append(m.Scripts, ManifestScript{File: file, Weight: weight})
+	}
+
+	// Скрипты прошлого захвата с тем же именем удаляются: запросов могло стать меньше
+	old, _ := filepath.Glob(filepath.Join(dir, name+"_*.sql"))
+	for _, file := range old {
+		if err := os.Remove(file); err != nil {
+			return nil, fmt.Errorf("failed to remove old replay script: %w", err)
+		}
+	}
+	for file, text := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(text), 0o644); err != nil {
+			return nil, fmt.Errorf("failed to write replay script: %w", err)
+		}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal replay manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".json"), append(data, '\n'), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write replay manifest: %w", err)
+	}
+
+	// Ошибки других манифестов сохранению не мешают
+	_, loadErr := LoadScenarios(dir)
+	saved, ok := LookupScenario(name)
+	if !ok {
+		return nil, fmt.Errorf("replay scenario %s was not loaded: %w", name, loadErr)
+	}
+	return saved, nil
+}
+
+// replayScript — скрипт одного запроса: \set для выражений, константы — литералами
+func replayScript(capture string, s CapturedStatement) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- %s%s: query_id %d, %.2f вызовов/с, %.3f мс в среднем\n",
+		replayPrefix, capture, s.QueryID, s.CallsPerSec, s.MeanTimeMs)
+
+	for i, p := range s.Params {
+		if p.Expr != "" && p.Value != nil {
+			return "", fmt.Errorf("param $%d has both expr and value", i+1)
+		}
+		if p.Value != nil && strings.ContainsAny(*p.Value, "\r\n") {
+			// Скрипт разбирается построчно: перенос внутри литерала разорвал бы кавычки
+			return "", fmt.Errorf("param $%d: value must be a single line", i+1)
+		}
+		if p.Expr != "" {
+			if strings.ContainsAny(p.Expr, "\n;") {
+				return "", fmt.Errorf("param $%d: expr must be a single expression", i+1)
+			}
+			fmt.Fprintf(&b, "\\set p%d %s\n", i+1, p.Expr)
+		}
+	}
+
+	var missing error
+	query := placeholderRe.ReplaceAllStringFunc(strings.TrimSpace(s.Query), func(ph string) string {
+		n, _ := strconv.Atoi(ph[1:])
+		if n < 1 || n > len(s.Params) {
+			missing = fmt.Errorf("param %s has no generator", ph)
+			return ph
+		}
+		p := s.Params[n-1]
+		switch {
+		case p.Expr != "":
+			return fmt.Sprintf(":p%d", n)
+		case p.Value != nil:
+			// Литерал без типа: PostgreSQL приведет его к типу колонки
+			return "'" + strings.ReplaceAll(*p.Value, "'", "''") + "'"
+		}
+		missing = fmt.Errorf("param %s has no generator", ph)
+		return ph
+	})
+	if missing != nil {
+		return "", missing
+	}
+	b.WriteString(strings.TrimRight(query, ";"))
+	b.WriteString(";\n")
+	return b.String(), nil
+}
+
+// checkReplayScript проверяет скрипт так, как его выполнит генератор: кроме \set
+// в нем ровно один запрос SELECT/INSERT/UPDATE/DELETE/WITH. Так ни текст запроса,
+// ни значения параметров не добавят в сценарий еще одну команду через ";".
+func checkReplayScript(s *Script) error {
+	statements := 0
+	for _, c := range s.Commands {
+		switch {
+		case c.set != "":
+		case c.sql != "":
+			if !replayQueryRe.MatchString(c.sql) {
+				return fmt.Errorf("only SELECT, INSERT, UPDATE, DELETE and WITH statements can be replayed")
+			}
+			statements++
+		default:
+			return fmt.Errorf("replay scripts may contain only \\set and a single statement")
+		}
+	}
+	if statements != 1 {
+		return fmt.Errorf("query must be a single statement, got %d", statements)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package generator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReplayScriptSingleStatement(t *testing.T) {
+	value := func(v string) *string { return &v }
+	tests := []struct {
+		name    string
+		query   string
+		params  []ParamGenerator
+		wantErr bool
+	}{
+		{"select", "SELECT abalance FROM pgbench_accounts WHERE aid = $1", []ParamGenerator{{Expr: "random(1, 100000 * :scale)"}}, false},
+		{"update with constant", "UPDATE t SET v = $1 WHERE id = $2", []ParamGenerator{{Value: value("it's")}, {Expr: "random(1, 10)"}}, false},
+		{"trailing semicolon", "SELECT 1;", nil, false},
+		{"with", "WITH x AS (SELECT 1) SELECT * FROM x", nil, false},
+		{"second statement", "SELECT 1; DROP TABLE pgbench_accounts", nil, true},
+		{"second statement after comment", "SELECT 1 -- ;\n; DELETE FROM t", nil, true},
+		{"ddl", "DROP TABLE pgbench_accounts", nil, true},
+		{"meta command in query", "SELECT 1\n\\sleep 10 s", nil, true},
+		{"semicolon inside literal", "SELECT * FROM t WHERE v = $1", []ParamGenerator{{Value: value("a; DROP TABLE t")}}, false},
+		{"multiline value", "SELECT * FROM t WHERE v = $1", []ParamGenerator{{Value: value("a'\n; DROP TABLE t; --")}}, true},
+		{"expr with semicolon", "SELECT $1", []ParamGenerator{{Expr: "1; DROP TABLE t"}}, true},
+		{"missing generator", "SELECT $1", []ParamGenerator{{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := func() error {
+				text, err := replayScript("test", CapturedStatement{QueryID: 1, Query: tt.query, Params: tt.params})
+				if err != nil {
+					return err
+				}
+				script, err := ParseScript("replay_test_1.sql", text)
+				if err != nil {
+					return err
+				}
+				return checkReplayScript(script)
+			}()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTargetDSN(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://main")
+	t.Setenv("LOAD_TARGET_TEST", "postgres://test")
+
+	tests := []struct {
+		target string
+		want   string
+		ok     bool
+	}{
+		{"", "postgres://main", true},
+		{"test", "postgres://test", true},
+		{"stage", "", false},
+		{"Test", "", false},
+		{"../x", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := TargetDSN(tt.target)
+			if tt.ok != (err == nil) || got != tt.want {
+				t.Fatalf("TargetDSN(%q) = %q, %v; want %q", tt.target, got, err, tt.want)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidParams) {
+				t.Fatalf("error %v is not ErrInvalidParams", err)
+			}
+		})
+	}
+}
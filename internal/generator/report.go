@@ -0,0 +1,106 @@
+package generator
+
+import (
+	"math"
+	"time"
+
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// RunReport — прогон нагрузки и метрики, которые за то же время насчитал
+// анализатор на стороне сервера
+type RunReport struct {
+	Run         LoadRun                `json:"run"`
+	Server      models.WorkloadMetrics `json:"server"`
+	ServerCurve []models.MetricsPoint  `json:"server_curve"` // серверные TPS и задержки по интервалам
+	Comparison  RunComparison          `json:"comparison"`
+}
+
+// RunComparison — клиентские и серверные TPS и задержки рядом.
+// Серверный TPS включает все транзакции в базе, не только от генератора, а
+// задержка на сервере считается по запросам, поэтому переводится в задержку
+// транзакции через число запросов на транзакцию (QPS / TPS).
+type RunComparison struct {
+	ClientTPS          float64 `json:"client_tps"`
+	ServerTPS          float64 `json:"server_tps"`
+	TPSRatio           float64 `json:"tps_ratio"` // server / client; заметно > 1 — в базе есть посторонняя нагрузка
+	ClientLatencyMs    float64 `json:"client_latency_ms"`
+	ServerQueryMs      float64 `json:"server_query_latency_ms"`
+	QueriesPerTxn      float64 `json:"queries_per_transaction"`
+	ServerLatencyMs    float64 `json:"server_latency_ms"`    // ServerQueryMs * QueriesPerTxn
+	ClientOverheadMs   float64 `json:"client_overhead_ms"`   // сеть, очередь к соединению, \sleep в скриптах
+	ServerShareOfTotal float64 `json:"server_share_percent"` // доля задержки, проведенная в базе
+	// Корреляция Пирсона клиентского и серверного TPS по интервалам (nil — мало точек)
+	TPSCorrelation *float64 `json:"tps_correlation,omitempty"`
+}
+
+// NewRunReport сопоставляет результат прогона с серверными метриками за его время
+func NewRunReport(run LoadRun, server models.WorkloadMetrics, curve []models.MetricsPoint) RunReport {
+	report := RunReport{Run: run, Server: server, ServerCurve: curve}
+	if run.Result == nil {
+		return report
+	}
+
+	c := RunComparison{
+		ClientTPS:       run.Result.TPS,
+		ServerTPS:       server.TPS,
+		ClientLatencyMs: run.Result.Latency.Avg,
+		ServerQueryMs:   server.AvgLatency,
+	}
+	if c.ClientTPS > 0 {
+		c.TPSRatio = c.ServerTPS / c.ClientTPS
+	}
+	if server.TPS > 0 {
+		c.QueriesPerTxn = server.QPS / server.TPS
+		c.ServerLatencyMs = c.ServerQueryMs * c.QueriesPerTxn
+	}
+	if c.ClientLatencyMs > 0 {
+		c.ClientOverheadMs = c.ClientLatencyMs - c.ServerLatencyMs
+		c.ServerShareOfTotal = c.ServerLatencyMs / c.ClientLatencyMs * 100
+	}
+	c.TPSCorrelation = tpsCorrelation(run.Result, curve)
+	report.Comparison = c
+	return report
+}
+
+// tpsCorrelation сопоставляет каждой точке прогресса ближайшую по времени серверную
+// точку (не дальше половины ProgressInterval) и считает корреляцию Пирсона их TPS
+func tpsCorrelation(result *Result, curve []models.MetricsPoint) *float64 {
+	var client, server []float64
+	for _, p := range result.Progress {
+		at := result.StartedAt.Add(time.Duration(p.Elapsed * float64(time.Second)))
+		best, bestDist := -1, ProgressInterval/2
+		for i, sp := range curve {
+			if dist := sp.Timestamp.Sub(at).Abs(); dist <= bestDist {
+				best, bestDist = i, dist
+			}
+		}
+		if best >= 0 {
+			client = append(client, p.TPS)
+			server = append(server, curve[best].TPS)
+		}
+	}
+	if len(client) < 3 {
+		return nil
+	}
+
+	n := float64(len(client))
+	var sumX, sumY float64
+	for i := range client {
+		sumX += client[i]
+		sumY += server[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var cov, varX, varY float64
+	for i := range client {
+		dx, dy := client[i]-meanX, server[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return nil
+	}
+	r := cov / math.Sqrt(varX*varY)
+	return &r
+}
@@ -1,79 +1,152 @@
 package generator
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
-)
-
-// RunBusinessScenario запускает предопределенный бизнес-сценарий нагрузки.
-// Интенсивность (количество клиентов) зашита в каждый сценарий.
-func RunBusinessScenario(scenario string) error {
-	dbUrl := os.Getenv("DATABASE_URL")
-	var cmd *exec.Cmd
-
-	log.Printf("[GENERATOR] Starting Business Scenario: %s", scenario)
+	"path/filepath"
+	"strings"
+	"time"
 
-	switch scenario {
+	"github.com/jackc/pgx/v5"
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
 
-	// 0. INIT (Сброс базы)
-	case "init":
-		cmd = exec.Command("pgbench", "-i", "-s", "50", dbUrl)
+// Драйверы нагрузки
+const (
+	DriverNative  = "native"  // встроенный генератор на pgx (по умолчанию)
+	DriverPgbench = "pgbench" // внешний pgbench, нужен postgresql-client в контейнере
+)
 
-	// 1. OLTP (Банк/Магазин)
-	// Цель: высокая параллельность, короткие транзакции.
-	case "oltp":
-		cmd = exec.Command("pgbench", "-T", "60", "-c", "50", "-j", "4", dbUrl)
+// ScenariosDir — каталог с манифестами и скриптами сценариев (SCENARIOS_DIR, по умолчанию ./scenarios)
+func ScenariosDir() string {
+	if dir := os.Getenv("SCENARIOS_DIR"); dir != "" {
+		return dir
+	}
+	return "./scenarios"
+}
 
-	// 2. OLAP (BI-система)
-	// Цель: несколько тяжелых параллельных запросов.
-	case "olap":
-		cmd = exec.Command("pgbench", "-T", "60", "-c", "4", "-j", "2", "-f", "./scenarios/olap.sql", dbUrl)
+// DriverFromEnv — драйвер нагрузки из LOAD_DRIVER (native или pgbench)
+func DriverFromEnv() string {
+	if os.Getenv("LOAD_DRIVER") == DriverPgbench {
+		return DriverPgbench
+	}
+	return DriverNative
+}
 
-	// 3. IOT (Write-Heavy)
-	// Цель: постоянный поток вставок от множества датчиков.
-	case "iot":
-		cmd = exec.Command("pgbench", "-T", "60", "-c", "20", "-j", "4", "-f", "./scenarios/iot.sql", dbUrl)
+// RunBusinessScenario запускает сценарий из библиотеки (см. LoadScenarios) с
+// интенсивностью params (нулевые поля — значения сценария, см. ResolveParams)
+// и возвращает структурированный результат. Если в базе нет таблиц, которые
+// требует сценарий, сначала выполняется его setup. Отмена ctx останавливает нагрузку.
+// Для составного сценария onPhase (если не nil) вызывается в начале каждой фазы.
+func RunBusinessScenario(ctx context.Context, scenario string, params models.LoadParams, onPhase func(Phase)) (*Result, error) {
+	m, ok := LookupScenario(scenario)
+	if !ok {
+		return nil, fmt.Errorf("unknown business scenario: %s", scenario)
+	}
+	params, err := ResolveParams(ctx, scenario, params)
+	if err != nil {
+		return nil, err
+	}
+	dbUrl, err := TargetDSN(params.Target)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureSetup(ctx, dbUrl, m); err != nil {
+		return nil, err
+	}
 
-	// 4. LOCKS (High-Concurrency Конфликт)
-	// Цель: сильная конкуренция, имитация распродажи.
-	case "locks":
-		cmd = exec.Command("pgbench", "-T", "60", "-c", "100", "-j", "8", "-f", "./scenarios/locks.sql", dbUrl)
+	log.Printf("[GENERATOR] Starting Business Scenario: %s (driver %s, %d clients, %ds, rate %.0f, scale %d)",
+		scenario, DriverFromEnv(), params.Clients, params.DurationSeconds, params.Rate, params.Scale)
 
-	// 5. REPORTING (Read-Heavy Отчеты)
-	// Цель: много легких чтений из кэша, загрузка CPU.
-	case "reporting":
-		cmd = exec.Command("pgbench", "-T", "60", "-c", "40", "-j", "4", "-f", "./scenarios/reporting.sql", dbUrl)
+	var result *Result
+	if m.Composite() {
+		result, err = runPhases(ctx, dbUrl, m, params, onPhase)
+	} else {
+		result, err = runManifest(ctx, dbUrl, m, params, Ramp{})
+	}
+	if result != nil {
+		result.Scenario = scenario
+		result.Params = params
+	}
+	if err != nil {
+		log.Printf("[GENERATOR] Scenario %s failed: %v", scenario, err)
+		return result, fmt.Errorf("scenario failed: %w", err)
+	}
 
-	// 6. MIXED (Гибрид)
-	// Цель: смесь транзакций и аналитики.
-	case "mixed":
-		cmd = exec.Command("pgbench", "-T", "60", "-c", "25", "-j", "4", "-N", dbUrl)
+	log.Printf("[GENERATOR] Scenario %s finished successfully.", scenario)
+	return result, nil
+}
 
-	// 7. ETL (Массовая загрузка)
-	// Цель: имитация ночной выгрузки, стресс для WAL.
-	case "etl":
-		cmd = exec.Command("pgbench", "-T", "60", "-c", "80", "-j", "8", "-f", "./scenarios/iot.sql", dbUrl)
+func runManifest(ctx context.Context, dbUrl string, m *Manifest, params models.LoadParams, ramp Ramp) (*Result, error) {
+	if DriverFromEnv() == DriverPgbench {
+		if ramp != (Ramp{}) {
+			return nil, fmt.Errorf("pgbench driver does not support client ramps")
+		}
+		return runPgbench(ctx, dbUrl, m, params)
+	}
+	return runNative(ctx, dbUrl, m, params, ramp)
+}
 
-	// 8. COLD (Архив)
-	// Цель: одна тяжелая операция по обслуживанию.
-	case "cold":
-		cmd = exec.Command("psql", dbUrl, "-c", "VACUUM FULL pgbench_accounts;")
+// ensureSetup выполняет setup-сценарий (с его параметрами по умолчанию),
+// если в базе нет таблиц из Requires
+func ensureSetup(ctx context.Context, dbUrl string, m *Manifest) error {
+	missing, err := missingTables(ctx, dbUrl, m.Requires)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	if m.Setup == "" {
+		return fmt.Errorf("scenario %s requires missing tables: %s", m.Name, strings.Join(missing, ", "))
+	}
+	setup, ok := LookupScenario(m.Setup)
+	if !ok {
+		return fmt.Errorf("setup scenario %s not found", m.Setup)
+	}
+	params, err := ResolveParams(ctx, setup.Name, models.LoadParams{})
+	if err != nil {
+		return err
+	}
 
-	default:
-		return fmt.Errorf("unknown business scenario: %s", scenario)
+	log.Printf("[GENERATOR] Tables %s are missing, running setup scenario %s first",
+		strings.Join(missing, ", "), setup.Name)
+	if _, err := runManifest(ctx, dbUrl, setup, params, Ramp{}); err != nil {
+		return fmt.Errorf("setup scenario %s failed: %w", setup.Name, err)
 	}
+	return nil
+}
 
-	// Настройка вывода
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+func runNative(ctx context.Context, dbUrl string, m *Manifest, params models.LoadParams, ramp Ramp) (*Result, error) {
+	w := Workload{
+		Clients:      params.Clients,
+		Duration:     time.Duration(params.DurationSeconds) * time.Second,
+		Transactions: m.Transactions,
+		ThinkTime:    time.Duration(params.ThinkTimeMS) * time.Millisecond,
+		Rate:         params.Rate,
+		Scale:        params.Scale,
+		Ramp:         ramp,
+	}
+	for _, ms := range m.Scripts {
+		script, err := LoadScript(filepath.Join(ScenariosDir(), ms.File))
+		if err != nil {
+			return nil, err
+		}
+		w.Scripts = append(w.Scripts, WeightedScript{Script: script, Weight: ms.Weight})
+	}
+	return Run(ctx, dbUrl, w)
+}
 
-	if err := cmd.Run(); err != nil {
-		log.Printf("[GENERATOR] Scenario %s failed: %v", scenario, err)
-		return fmt.Errorf("scenario failed: %w", err)
+// detectScale — масштаб схемы pgbench по числу филиалов (так делает и сам pgbench)
+func detectScale(ctx context.Context, dbUrl string) int {
+	conn, err := pgx.Connect(ctx, dbUrl)
+	if err != nil {
+		return 1
 	}
+	defer conn.Close(ctx)
 
-	log.Printf("[GENERATOR] Scenario %s finished successfully.", scenario)
-	return nil
+	var scale int
+	if err := conn.QueryRow(ctx, "SELECT count(*) FROM pgbench_branches").Scan(&scale); err != nil || scale < 1 {
+		return 1
+	}
+	return scale
 }
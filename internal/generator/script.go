@@ -0,0 +1,440 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Script — транзакция нагрузки в формате скриптов pgbench (подмножество):
+// SQL-команды через ";", \set var <выражение>, \sleep N [us|ms|s].
+// Переменные подставляются в SQL как параметры ($1, $2...), а не текстом.
+type Script struct {
+	Name     string
+	Commands []command
+}
+
+// command — одна команда скрипта: SQL, \set или \sleep
+type command struct {
+	sql   string   // SQL с плейсхолдерами $n
+	args  []string // имена переменных для $1..$n
+	set   string   // \set: имя переменной
+	expr  expr     // \set: выражение
+	sleep expr     // \sleep: длительность
+	unit  time.Duration
+}
+
+// LoadScript читает скрипт из файла
+func LoadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return ParseScript(name, string(data))
+}
+
+// ParseScript разбирает текст скрипта
+func ParseScript(name, text string) (*Script, error) {
+	s := &Script{Name: name}
+	var sql strings.Builder
+
+	flush := func() {
+		stmt := strings.TrimSpace(sql.String())
+		sql.Reset()
+		if stmt != "" {
+			text, args := bindVariables(stmt)
+			s.Commands = append(s.Commands, command{sql: text, args: args})
+		}
+	}
+
+	for lineNo, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, `\`) {
+			flush()
+			cmd, err := parseMeta(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", name, lineNo+1, err)
+			}
+			s.Commands = append(s.Commands, cmd)
+			continue
+		}
+		for _, part := range splitStatements(line) {
+			if part == ";" {
+				flush()
+				continue
+			}
+			sql.WriteString(part)
+		}
+		sql.WriteString("\n")
+	}
+	flush()
+	if len(s.Commands) == 0 {
+		return nil, fmt.Errorf("script %s has no commands", name)
+	}
+	return s, nil
+}
+
+// splitStatements делит строку по ";" вне кавычек и отрезает комментарий "--".
+// Разделители возвращаются отдельными элементами.
+func splitStatements(line string) []string {
+	var parts []string
+	var cur strings.Builder
+	var quote rune
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			return append(parts, cur.String())
+		case r == ';':
+			parts = append(parts, cur.String(), ";")
+			cur.Reset()
+			continue
+		}
+		cur.WriteRune(r)
+	}
+	return append(parts, cur.String())
+}
+
+// bindVariables заменяет :var на $n (вне кавычек, не трогая приведения ::type)
+func bindVariables(stmt string) (string, []string) {
+	var out strings.Builder
+	var args []string
+	index := make(map[string]int)
+	var quote rune
+	runes := []rune(stmt)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ':' && i+1 < len(runes) && runes[i+1] == ':':
+			out.WriteString("::")
+			i++
+			continue
+		case r == ':' && i+1 < len(runes) && isIdentStart(runes[i+1]):
+			j := i + 1
+			for j < len(runes) && isIdentPart(runes[j]) {
+				j++
+			}
+			name := string(runes[i+1 : j])
+			n, ok := index[name]
+			if !ok {
+				args = append(args, name)
+				n = len(args)
+				index[name] = n
+			}
+			fmt.Fprintf(&out, "$%d", n)
+			i = j - 1
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String(), args
+}
+
+func parseMeta(line string) (command, error) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case `\set`:
+		if len(fields) < 3 {
+			return command{}, fmt.Errorf(`\set requires a variable and an expression`)
+		}
+		e, err := parseExpr(strings.Join(fields[2:], " "))
+		if err != nil {
+			return command{}, err
+		}
+		return command{set: fields[1], expr: e}, nil
+	case `\sleep`:
+		if len(fields) < 2 || len(fields) > 3 {
+			return command{}, fmt.Errorf(`\sleep requires a duration`)
+		}
+		e, err := parseExpr(fields[1])
+		if err != nil {
+			return command{}, err
+		}
+		unit := time.Second
+		if len(fields) == 3 {
+			switch fields[2] {
+			case "us":
+				unit = time.Microsecond
+			case "ms":
+				unit = time.Millisecond
+			case "s":
+				unit = time.Second
+			default:
+				return command{}, fmt.Errorf(`\sleep: unknown unit %q`, fields[2])
+			}
+		}
+		return command{sleep: e, unit: unit}, nil
+	default:
+		return command{}, fmt.Errorf("unsupported meta command %s", fields[0])
+	}
+}
+
+// --- Выражения \set: целые числа, :переменные, + - * / %, скобки и функции ---
+
+type expr interface {
+	eval(vars map[string]int64, rnd *rand.Rand) (int64, error)
+}
+
+type numExpr int64
+
+func (n numExpr) eval(map[string]int64, *rand.Rand) (int64, error) { return int64(n), nil }
+
+type varExpr string
+
+func (v varExpr) eval(vars map[string]int64, _ *rand.Rand) (int64, error) {
+	value, ok := vars[string(v)]
+	if !ok {
+		return 0, fmt.Errorf("undefined variable :%s", string(v))
+	}
+	return value, nil
+}
+
+type binExpr struct {
+	op          rune
+	left, right expr
+}
+
+func (b binExpr) eval(vars map[string]int64, rnd *rand.Rand) (int64, error) {
+	l, err := b.left.eval(vars, rnd)
+	if err != nil {
+		return 0, err
+	}
+	r, err := b.right.eval(vars, rnd)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	case '/', '%':
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if b.op == '/' {
+			return l / r, nil
+		}
+		return l % r, nil
+	}
+	return 0, fmt.Errorf("unknown operator %c", b.op)
+}
+
+// randomRange — равномерное случайное число из [lb, ub] для любых lb <= ub.
+// Ширина диапазона может не влезать в int64 (random(0, 9223372036854775807)
+// или random(-9223372036854775808, 0)): тогда число выбирается отбраковкой из uint64.
+func randomRange(rnd *rand.Rand, lb, ub int64) int64 {
+	if n := ub - lb + 1; n > 0 {
+		return lb + rnd.Int63n(n)
+	}
+	width := uint64(ub) - uint64(lb)
+	for {
+		// width >= 2^63-1, так что в среднем хватает двух попыток
+		if v := rnd.Uint64(); v <= width {
+			return lb + int64(v)
+		}
+	}
+}
+
+type callExpr struct {
+	name string
+	args []expr
+}
+
+func (c callExpr) eval(vars map[string]int64, rnd *rand.Rand) (int64, error) {
+	args := make([]int64, len(c.args))
+	for i, a := range c.args {
+		v, err := a.eval(vars, rnd)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	switch c.name {
+	case "random":
+		if len(args) != 2 || args[1] < args[0] {
+			return 0, fmt.Errorf("random(lb, ub) requires lb <= ub")
+		}
+		return randomRange(rnd, args[0], args[1]), nil
+	case "abs":
+		if len(args) != 1 {
+			return 0, fmt.Errorf("abs() takes one argument")
+		}
+		if args[0] < 0 {
+			return -args[0], nil
+		}
+		return args[0], nil
+	case "least", "greatest":
+		if len(args) == 0 {
+			return 0, fmt.Errorf("%s() requires arguments", c.name)
+		}
+		v := args[0]
+		for _, a := range args[1:] {
+			if c.name == "least" && a < v || c.name == "greatest" && a > v {
+				v = a
+			}
+		}
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown function %s()", c.name)
+}
+
+// exprParser — рекурсивный спуск: sum -> term (+|- term)*, term -> unary (*|/|% unary)*
+type exprParser struct {
+	src []rune
+	pos int
+}
+
+func parseExpr(s string) (expr, error) {
+	p := &exprParser{src: []rune(s)}
+	e, err := p.sum()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", s, err)
+	}
+	p.skipSpace()
+	if p.pos != len(p.src) {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q", s, string(p.src[p.pos:]))
+	}
+	return e, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() rune {
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) sum() (expr, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = binExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) term() (expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/' || op == '%'; op = p.peek() {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = binExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) unary() (expr, error) {
+	switch r := p.peek(); {
+	case r == '-':
+		p.pos++
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return binExpr{op: '-', left: numExpr(0), right: e}, nil
+	case r == '(':
+		p.pos++
+		e, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	case r == ':':
+		p.pos++
+		name := p.ident()
+		if name == "" {
+			return nil, fmt.Errorf("missing variable name after :")
+		}
+		return varExpr(name), nil
+	case r >= '0' && r <= '9':
+		start := p.pos
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		n, err := strconv.ParseInt(string(p.src[start:p.pos]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return numExpr(n), nil
+	case isIdentStart(r):
+		name := strings.ToLower(p.ident())
+		if p.peek() != '(' {
+			return nil, fmt.Errorf("unknown identifier %s", name)
+		}
+		p.pos++
+		var args []expr
+		for p.peek() != ')' {
+			a, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			if p.peek() == ',' {
+				p.pos++
+			} else if p.peek() != ')' {
+				return nil, fmt.Errorf("missing ) in %s()", name)
+			}
+		}
+		p.pos++
+		return callExpr{name: name, args: args}, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q", string(r))
+	}
+}
+
+func (p *exprParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) && isIdentPart(p.src[p.pos]) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+func isIdentStart(r rune) bool { return r == '_' || unicode.IsLetter(r) }
+func isIdentPart(r rune) bool  { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
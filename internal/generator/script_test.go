@@ -0,0 +1,231 @@
+package generator
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBindVariables(t *testing.T) {
+	tests := []struct {
+		name     string
+		stmt     string
+		wantSQL  string
+		wantArgs []string
+	}{
+		{"no variables", "SELECT 1", "SELECT 1", nil},
+		{"one variable", "SELECT abalance FROM pgbench_accounts WHERE aid = :aid",
+			"SELECT abalance FROM pgbench_accounts WHERE aid = $1", []string{"aid"}},
+		{"repeated variable", "UPDATE t SET v = v + :delta WHERE id = :id AND :delta > 0",
+			"UPDATE t SET v = v + $1 WHERE id = $2 AND $1 > 0", []string{"delta", "id"}},
+		{"cast is kept", "SELECT :aid::bigint, now()::date", "SELECT $1::bigint, now()::date", []string{"aid"}},
+		{"quoted text is kept", "SELECT ':aid', \":col\" FROM t WHERE id = :id", "SELECT ':aid', \":col\" FROM t WHERE id = $1", []string{"id"}},
+		{"digit after colon", "SELECT '10:30', :1", "SELECT '10:30', :1", nil},
+		{"underscore and digits", "SELECT :client_id, :v2", "SELECT $1, $2", []string{"client_id", "v2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args := bindVariables(tt.stmt)
+			if sql != tt.wantSQL {
+				t.Errorf("sql = %q, want %q", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	text := strings.Join([]string{
+		`\set aid random(1, 100000 * :scale)`,
+		`\set delta random(-5000, 5000)`,
+		`BEGIN;`,
+		`UPDATE pgbench_accounts SET abalance = abalance + :delta WHERE aid = :aid; -- comment; not a statement`,
+		`SELECT abalance`,
+		`  FROM pgbench_accounts WHERE aid = :aid;`,
+		`\sleep 10 ms`,
+		`INSERT INTO log VALUES ('a;b');`,
+		`END;`,
+	}, "\n")
+	s, err := ParseScript("tpcb", text)
+	if err != nil {
+		t.Fatalf("ParseScript: %v", err)
+	}
+
+	type want struct {
+		set   string
+		sql   string
+		args  []string
+		sleep bool
+		unit  time.Duration
+	}
+	wants := []want{
+		{set: "aid"},
+		{set: "delta"},
+		{sql: "BEGIN"},
+		{sql: "UPDATE pgbench_accounts SET abalance = abalance + $1 WHERE aid = $2", args: []string{"delta", "aid"}},
+		{sql: "SELECT abalance\n  FROM pgbench_accounts WHERE aid = $1", args: []string{"aid"}},
+		{sleep: true, unit: time.Millisecond},
+		{sql: "INSERT INTO log VALUES ('a;b')"},
+		{sql: "END"},
+	}
+	if len(s.Commands) != len(wants) {
+		t.Fatalf("got %d commands, want %d: %+v", len(s.Commands), len(wants), s.Commands)
+	}
+	for i, w := range wants {
+		c := s.Commands[i]
+		switch {
+		case w.set != "":
+			if c.set != w.set || c.expr == nil {
+				t.Errorf("command %d: got %+v, want \\set %s", i, c, w.set)
+			}
+		case w.sleep:
+			if c.sleep == nil || c.unit != w.unit {
+				t.Errorf("command %d: got %+v, want \\sleep in %s", i, c, w.unit)
+			}
+		default:
+			if c.sql != w.sql || !reflect.DeepEqual(c.args, w.args) {
+				t.Errorf("command %d: got %q %v, want %q %v", i, c.sql, c.args, w.sql, w.args)
+			}
+		}
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"empty", "-- only a comment\n\n"},
+		{"set without expression", `\set aid`},
+		{"unknown meta command", `\shell ls`},
+		{"sleep without duration", `\sleep`},
+		{"sleep unknown unit", `\sleep 1 min`},
+		{"bad expression", `\set aid random(1, `},
+		{"unknown identifier", `\set aid scale + 1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseScript("bad", tt.text); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	vars := map[string]int64{"scale": 10, "n": -7}
+	tests := []struct {
+		expr    string
+		want    int64
+		wantErr bool
+	}{
+		{"42", 42, false},
+		{"1 + 2 * 3", 7, false},
+		{"(1 + 2) * 3", 9, false},
+		{"10 - 4 - 3", 3, false},
+		{"100 / 7 % 4", 2, false},
+		{"-3 + -(2)", -5, false},
+		{":scale * 100000", 1000000, false},
+		{"abs(:n)", 7, false},
+		{"least(5, :scale, 3)", 3, false},
+		{"GREATEST(5, :scale, 3)", 10, false},
+		{"random(7, 7)", 7, false},
+		{"random(:scale, :scale) + 1", 11, false},
+		{"1 / 0", 0, true},
+		{"5 % (3 - 3)", 0, true},
+		{":missing + 1", 0, true},
+		{"random(5, 1)", 0, true},
+		{"abs(1, 2)", 0, true},
+		{"least()", 0, true},
+		{"sqrt(4)", 0, true},
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := parseExpr(tt.expr)
+			if err != nil {
+				t.Fatalf("parseExpr: %v", err)
+			}
+			got, err := e.eval(vars, rnd)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExprRandomRange(t *testing.T) {
+	e, err := parseExpr("random(1, 3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	seen := make(map[int64]bool)
+	for i := 0; i < 1000; i++ {
+		v, err := e.eval(nil, rnd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v < 1 || v > 3 {
+			t.Fatalf("random(1, 3) = %d", v)
+		}
+		seen[v] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("random(1, 3) produced only %v", seen)
+	}
+}
+
+func TestRandomRangeWide(t *testing.T) {
+	tests := []struct {
+		name   string
+		lb, ub int64
+	}{
+		{"full positive range", 0, math.MaxInt64},
+		{"full negative range", math.MinInt64, 0},
+		{"whole int64", math.MinInt64, math.MaxInt64},
+		{"wide across zero", -1, math.MaxInt64},
+		{"single min value", math.MinInt64, math.MinInt64},
+		{"single max value", math.MaxInt64, math.MaxInt64},
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				if v := randomRange(rnd, tt.lb, tt.ub); v < tt.lb || v > tt.ub {
+					t.Fatalf("randomRange(%d, %d) = %d", tt.lb, tt.ub, v)
+				}
+			}
+		})
+	}
+
+	e, err := parseExpr("random(0, 9223372036854775807)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.eval(nil, rnd); err != nil {
+		t.Fatalf("eval: %v", err)
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, s := range []string{"", "1 +", "(1 + 2", "1 2", ":", "random(1, 2", "1 $ 2"} {
+		if _, err := parseExpr(s); err == nil {
+			t.Errorf("parseExpr(%q): expected error", s)
+		}
+	}
+}
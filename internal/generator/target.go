@@ -0,0 +1,28 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var targetNameRe = regexp.MustCompile(`^[a-z0-9_]{1,40}$`)
+
+// TargetDSN возвращает строку подключения к базе, на которую подается нагрузка.
+// Пустое имя — основная база (DATABASE_URL), иначе DSN берется из переменной
+// LOAD_TARGET_<NAME> (target=test — LOAD_TARGET_TEST). Адреса задает только
+// окружение сервера: через API нагрузку не направить на произвольный хост.
+func TargetDSN(name string) (string, error) {
+	if name == "" {
+		return os.Getenv("DATABASE_URL"), nil
+	}
+	if !targetNameRe.MatchString(name) {
+		return "", fmt.Errorf("%w: target must match %s", ErrInvalidParams, targetNameRe)
+	}
+	dsn := os.Getenv("LOAD_TARGET_" + strings.ToUpper(name))
+	if dsn == "" {
+		return "", fmt.Errorf("%w: unknown target %s (set LOAD_TARGET_%s)", ErrInvalidParams, name, strings.ToUpper(name))
+	}
+	return dsn, nil
+}
@@ -27,4 +27,22 @@ type WorkloadMetrics struct {
 	CommitRatio      float64 `json:"commit_ratio"`        // Процент коммитов от всех транзакций
 	WastedDBTime     float64 `json:"wasted_db_time"`     // Процент потраченного времени (lock_time / db_time_total * 100)
 	DominateDBTime   float64 `json:"dominate_db_time"`    // Доминирующий тип DB time (максимум из cpu/io/lock в процентах)
+
+	// --- Buffers & Tuples (из pg_stat_database / pg_stat_bgwriter) ---
+	CacheHitRatio       float64 `json:"cache_hit_ratio"`        // Процент чтений из кэша (blks_hit / (blks_hit + blks_read))
+	BlksReadPS          float64 `json:"blks_read_per_sec"`      // Блоков прочитано с диска в секунду
+	BlksHitPS           float64 `json:"blks_hit_per_sec"`       // Блоков прочитано из кэша в секунду
+	TupReturnedPS       float64 `json:"tup_returned_per_sec"`   // Строк возвращено в секунду
+	TupFetchedPS        float64 `json:"tup_fetched_per_sec"`    // Строк выбрано в секунду
+	TupInsertedPS       float64 `json:"tup_inserted_per_sec"`   // Строк вставлено в секунду
+	TupUpdatedPS        float64 `json:"tup_updated_per_sec"`    // Строк обновлено в секунду
+	TupDeletedPS        float64 `json:"tup_deleted_per_sec"`    // Строк удалено в секунду
+	BuffersCheckpointPS float64 `json:"buffers_checkpoint_per_sec"` // Буферов записано чекпоинтом в секунду
+	BuffersCleanPS      float64 `json:"buffers_clean_per_sec"`  // Буферов записано bgwriter в секунду
+	BuffersBackendPS    float64 `json:"buffers_backend_per_sec"` // Буферов записано бэкендами в секунду
+
+	// --- Window ---
+	WindowSeconds    float64 `json:"window_seconds"`      // Фактическая длина окна, по которому посчитаны метрики
+	Partial          bool     `json:"partial"`            // Окно неполное: истории не хватило или часть интервалов отброшена из-за сброса
+	Resets           []string `json:"resets,omitempty"`   // Обнаруженные сбросы (server_restart, pg_stat_reset, pg_stat_statements_reset...)
 }
\ No newline at end of file
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// ScenarioInfo — ground truth: какую нагрузку дали и какой конфиг применили
+type ScenarioInfo struct {
+	LoadScenario string      `json:"load_scenario"` // Какую нагрузку дали (oltp, olap...)
+	ActiveConfig string      `json:"active_config"` // Какой пресет настроек применили
+	StartTime    time.Time   `json:"start_time"`
+	Load         *LoadParams `json:"load,omitempty"` // С какой интенсивностью
+	// Какой профиль должен определить классификатор (expected_profile из манифеста сценария)
+	ExpectedProfile string `json:"expected_profile,omitempty"`
+	// Текущая фаза составного сценария (label из манифеста) и время ее начала
+	Phase      string     `json:"phase,omitempty"`
+	PhaseStart *time.Time `json:"phase_start,omitempty"`
+	// Прогон нагрузки, к которому относится разметка (ID из generator.Manager)
+	RunID int64 `json:"run_id,omitempty"`
+}
+
+// LoadParams — интенсивность прогона нагрузки. Нулевое поле в запросе — значение по умолчанию сценария.
+type LoadParams struct {
+	DurationSeconds int     `json:"duration_seconds"`
+	Clients         int     `json:"clients"`
+	Jobs            int     `json:"jobs"`             // потоков генератора (pgbench -j)
+	Rate            float64 `json:"rate"`             // целевой TPS (pgbench -R), 0 — без ограничения
+	Scale           int     `json:"scale"`            // масштаб схемы pgbench (-s)
+	ThinkTimeMS     int     `json:"think_time_ms"`    // пауза клиента между транзакциями, мс (только встроенный генератор)
+	Target          string  `json:"target,omitempty"` // база для нагрузки (LOAD_TARGET_<NAME>); пусто — DATABASE_URL
+}
+
+// MetricsPoint — метрики сервера за один интервал семплирования (кривая для сравнения с нагрузкой)
+type MetricsPoint struct {
+	Timestamp  time.Time `json:"timestamp"` // конец интервала
+	TPS        float64   `json:"tps"`
+	QPS        float64   `json:"qps"`
+	AvgLatency float64   `json:"avg_query_latency_ms"`
+}
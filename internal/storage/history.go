@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/lypolix/pg_load_profile/internal/analyzer"
+	"github.com/lypolix/pg_load_profile/internal/models"
+)
+
+// DiagnosisRecord — одна точка истории: что увидел классификатор и что на самом деле крутилось
+type DiagnosisRecord struct {
+	ID          int64                `json:"id"`
+	RecordedAt  time.Time            `json:"recorded_at"`
+	GroundTruth *models.ScenarioInfo `json:"ground_truth"`
+	Diagnosis   analyzer.Diagnosis   `json:"diagnosis"`
+}
+
+// HistoryFilter — параметры выборки истории
+type HistoryFilter struct {
+	From      time.Time
+	To        time.Time
+	Profile   string   // подстрока профиля без учета регистра (olap -> "OLAP (ANALYTICAL)")
+	Scenarios []string // load_scenario из ground truth; пусто — все
+	RunsOnly  bool     // только диагнозы, снятые во время прогона нагрузки (задан run_id)
+	Limit     int      // 0 — без ограничения (для QueryHistory — 5000)
+}
+
+// SaveDiagnosis сохраняет диагноз в profile_metrics.diagnosis_history.
+// Диагноз целиком хранится в JSONB, отдельными колонками — только то, по чему фильтруем.
+func SaveDiagnosis(ctx context.Context, pool *pgxpool.Pool, rec DiagnosisRecord) error {
+	payload, err := json.Marshal(rec.Diagnosis)
+	if err != nil {
+		return fmt.Errorf("failed to marshal diagnosis: %w", err)
+	}
+
+	var loadScenario, activeConfig, expectedProfile, phase *string
+	var scenarioStart, phaseStart *time.Time
+	var runID *int64
+	var loadParams []byte
+	if rec.GroundTruth != nil {
+		loadScenario = &rec.GroundTruth.LoadScenario
+		activeConfig = &rec.GroundTruth.ActiveConfig
+		scenarioStart = &rec.GroundTruth.StartTime
+		phaseStart = rec.GroundTruth.PhaseStart
+		if rec.GroundTruth.ExpectedProfile != "" {
+			expectedProfile = &rec.GroundTruth.ExpectedProfile
+		}
+		if rec.GroundTruth.Phase != "" {
+			phase = &rec.GroundTruth.Phase
+		}
+		if rec.GroundTruth.RunID != 0 {
+			runID = &rec.GroundTruth.RunID
+		}
+		if rec.GroundTruth.Load != nil {
+			if loadParams, err = json.Marshal(rec.GroundTruth.Load); err != nil {
+				return fmt.Errorf("failed to marshal load params: %w", err)
+			}
+		}
+	}
+
+	_, err = pool.Exec(ctx, `
+		INSERT INTO profile_metrics.diagnosis_history
+			(recorded_at, profile, confidence, load_scenario, active_config, scenario_start, load_params, expected_profile, phase, phase_start, run_id, diagnosis)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, rec.RecordedAt, rec.Diagnosis.Profile, rec.Diagnosis.Confidence,
+		loadScenario, activeConfig, scenarioStart, loadParams, expectedProfile, phase, phaseStart, runID, payload)
+	if err != nil {
+		return fmt.Errorf("failed to insert diagnosis: %w", err)
+	}
+	return nil
+}
+
+// QueryHistory возвращает таймлайн диагнозов за период в хронологическом порядке
+func QueryHistory(ctx context.Context, pool *pgxpool.Pool, f HistoryFilter) ([]DiagnosisRecord, error) {
+	if f.Limit <= 0 {
+		f.Limit = 5000
+	}
+
+	records := []DiagnosisRecord{}
+	err := ScanHistory(ctx, pool, f, func(rec DiagnosisRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ScanHistory читает историю диагнозов построчно и передает каждую запись в fn,
+// не собирая выборку в памяти (для выгрузок за длинный период). Ошибка fn прерывает чтение.
+func ScanHistory(ctx context.Context, pool *pgxpool.Pool, f HistoryFilter, fn func(DiagnosisRecord) error) error {
+	var limit *int
+	if f.Limit > 0 {
+		limit = &f.Limit
+	}
+	var scenarios []string
+	if len(f.Scenarios) > 0 {
+		scenarios = f.Scenarios
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT id, recorded_at, load_scenario, active_config, scenario_start, load_params, expected_profile, phase, phase_start, run_id, diagnosis
+		FROM profile_metrics.diagnosis_history
+		WHERE recorded_at BETWEEN $1 AND $2
+		  AND ($3 = '' OR strpos(lower(profile), lower($3)) > 0)
+		  AND ($5::text[] IS NULL OR load_scenario = ANY($5))
+		  AND (NOT $6 OR run_id IS NOT NULL)
+		ORDER BY recorded_at
+		LIMIT $4
+	`, f.From, f.To, f.Profile, limit, scenarios, f.RunsOnly)
+	if err != nil {
+		return fmt.Errorf("failed to query diagnosis history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec DiagnosisRecord
+		var loadScenario, activeConfig, expectedProfile, phase *string
+		var scenarioStart, phaseStart *time.Time
+		var runID *int64
+		var loadParams, payload []byte
+		if err := rows.Scan(&rec.ID, &rec.RecordedAt, &loadScenario, &activeConfig, &scenarioStart, &loadParams, &expectedProfile, &phase, &phaseStart, &runID, &payload); err != nil {
+			return fmt.Errorf("failed to scan diagnosis history: %w", err)
+		}
+		if err := json.Unmarshal(payload, &rec.Diagnosis); err != nil {
+			return fmt.Errorf("failed to decode diagnosis %d: %w", rec.ID, err)
+		}
+		if loadScenario != nil || activeConfig != nil {
+			rec.GroundTruth = &models.ScenarioInfo{}
+			if loadScenario != nil {
+				rec.GroundTruth.LoadScenario = *loadScenario
+			}
+			if activeConfig != nil {
+				rec.GroundTruth.ActiveConfig = *activeConfig
+			}
+			if scenarioStart != nil {
+				rec.GroundTruth.StartTime = *scenarioStart
+			}
+			if expectedProfile != nil {
+				rec.GroundTruth.ExpectedProfile = *expectedProfile
+			}
+			if phase != nil {
+				rec.GroundTruth.Phase = *phase
+			}
+			rec.GroundTruth.PhaseStart = phaseStart
+			if runID != nil {
+				rec.GroundTruth.RunID = *runID
+			}
+			if loadParams != nil {
+				rec.GroundTruth.Load = &models.LoadParams{}
+				if err := json.Unmarshal(loadParams, rec.GroundTruth.Load); err != nil {
+					return fmt.Errorf("failed to decode load params of diagnosis %d: %w", rec.ID, err)
+				}
+			}
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read diagnosis history: %w", err)
+	}
+	return nil
+}
@@ -139,3 +139,172 @@ BEGIN
     WHERE d.datname = current_database();
 END;
 $$ LANGUAGE plpgsql;
+
+-- 3. Добавляем счетчик вызовов pg_stat_statements, чтобы по снэпшотам
+-- можно было пересчитать QPS и среднюю задержку задним числом
+ALTER TABLE profile_metrics.snapshots 
+ADD COLUMN IF NOT EXISTS total_calls BIGINT;
+
+-- Индекс по времени, чтобы быстро выбирать снэпшоты за период
+CREATE INDEX IF NOT EXISTS snapshots_snapshot_time_idx ON profile_metrics.snapshots (snapshot_time);
+
+CREATE OR REPLACE FUNCTION profile_metrics.take_snapshot() RETURNS void AS $$
+DECLARE
+    v_total_exec_time float8;
+    v_total_calls     bigint;
+BEGIN
+    -- Считаем общее время и число вызовов всех запросов на данный момент
+    SELECT sum(total_exec_time), sum(calls) INTO v_total_exec_time, v_total_calls FROM pg_stat_statements;
+
+    INSERT INTO profile_metrics.snapshots (
+        snapshot_time,
+        xact_commit, xact_rollback, blks_read, blks_hit, 
+        tup_returned, tup_fetched, tup_inserted, tup_updated, tup_deleted,
+        buffers_checkpoint, buffers_clean, buffers_backend,
+        total_exec_time, total_calls
+    )
+    SELECT 
+        now(),
+        d.xact_commit, d.xact_rollback, d.blks_read, d.blks_hit,
+        d.tup_returned, d.tup_fetched, d.tup_inserted, d.tup_updated, d.tup_deleted,
+        b.buffers_checkpoint, b.buffers_clean, b.buffers_backend,
+        COALESCE(v_total_exec_time, 0), COALESCE(v_total_calls, 0)
+    FROM pg_stat_database d, pg_stat_bgwriter b
+    WHERE d.datname = current_database();
+END;
+$$ LANGUAGE plpgsql;
+
+-- 4. Отметки сбросов статистики рядом с каждым снэпшотом.
+-- Без них нельзя отличить pg_stat_reset()/pg_stat_statements_reset()/рестарт
+-- от реального падения нагрузки: дельты уходят в минус.
+ALTER TABLE profile_metrics.snapshots
+ADD COLUMN IF NOT EXISTS postmaster_start   TIMESTAMPTZ,
+ADD COLUMN IF NOT EXISTS stats_reset        TIMESTAMPTZ,
+ADD COLUMN IF NOT EXISTS bgwriter_reset     TIMESTAMPTZ,
+ADD COLUMN IF NOT EXISTS statements_reset   TIMESTAMPTZ,
+ADD COLUMN IF NOT EXISTS statements_dealloc BIGINT;
+
+CREATE OR REPLACE FUNCTION profile_metrics.take_snapshot() RETURNS void AS $$
+DECLARE
+    v_total_exec_time    float8;
+    v_total_calls        bigint;
+    v_statements_reset   timestamptz;
+    v_statements_dealloc bigint;
+BEGIN
+    SELECT sum(total_exec_time), sum(calls) INTO v_total_exec_time, v_total_calls FROM pg_stat_statements;
+    SELECT stats_reset, dealloc INTO v_statements_reset, v_statements_dealloc FROM pg_stat_statements_info;
+
+    INSERT INTO profile_metrics.snapshots (
+        snapshot_time,
+        xact_commit, xact_rollback, blks_read, blks_hit, 
+        tup_returned, tup_fetched, tup_inserted, tup_updated, tup_deleted,
+        buffers_checkpoint, buffers_clean, buffers_backend,
+        total_exec_time, total_calls,
+        postmaster_start, stats_reset, bgwriter_reset, statements_reset, statements_dealloc
+    )
+    SELECT 
+        now(),
+        d.xact_commit, d.xact_rollback, d.blks_read, d.blks_hit,
+        d.tup_returned, d.tup_fetched, d.tup_inserted, d.tup_updated, d.tup_deleted,
+        b.buffers_checkpoint, b.buffers_clean, b.buffers_backend,
+        COALESCE(v_total_exec_time, 0), COALESCE(v_total_calls, 0),
+        pg_postmaster_start_time(), d.stats_reset, b.stats_reset, v_statements_reset, COALESCE(v_statements_dealloc, 0)
+    FROM pg_stat_database d, pg_stat_bgwriter b
+    WHERE d.datname = current_database();
+END;
+$$ LANGUAGE plpgsql;
+
+-- 5. История диагнозов: что видел классификатор + ground truth (сценарий и активный конфиг).
+-- Диагноз хранится целиком в JSONB, чтобы не менять схему при добавлении полей.
+CREATE TABLE IF NOT EXISTS profile_metrics.diagnosis_history (
+    id              BIGSERIAL PRIMARY KEY,
+    recorded_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    profile         TEXT NOT NULL,
+    confidence      TEXT,
+    load_scenario   TEXT,           -- ground truth: какую нагрузку дали
+    active_config   TEXT,           -- ground truth: какой пресет был применен
+    scenario_start  TIMESTAMPTZ,
+    diagnosis       JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS diagnosis_history_recorded_at_idx ON profile_metrics.diagnosis_history (recorded_at);
+
+-- 6. История изменений конфигурации: каждая применённая версия (пресет, ручные
+-- настройки, рекомендации, откат) с прежними значениями из pg_settings.
+CREATE TABLE IF NOT EXISTS profile_metrics.config_changes (
+    id          BIGSERIAL PRIMARY KEY,
+    applied_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    actor       TEXT NOT NULL DEFAULT '',   -- кто применил
+    reason      TEXT NOT NULL DEFAULT '',   -- зачем
+    source      TEXT NOT NULL,              -- preset / custom / recommendations / rollback / autopilot / evaluation
+    preset      TEXT,
+    settings    JSONB NOT NULL,             -- что записали
+    previous    JSONB NOT NULL              -- что было до этого (для отката)
+);
+
+-- 7. Пользовательские пресеты конфигурации. Пресет с именем встроенного перекрывает его;
+-- ml_profiles — профили ML-сервиса, которые переводятся в этот пресет.
+CREATE TABLE IF NOT EXISTS profile_metrics.presets (
+    name        TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    settings    JSONB NOT NULL,
+    ml_profiles TEXT[] NOT NULL DEFAULT '{}',
+    version     INT NOT NULL DEFAULT 1,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_by  TEXT NOT NULL DEFAULT ''
+);
+
+-- Все сохраненные версии пресетов (переживают удаление пресета)
+CREATE TABLE IF NOT EXISTS profile_metrics.preset_versions (
+    name        TEXT NOT NULL,
+    version     INT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    settings    JSONB NOT NULL,
+    ml_profiles TEXT[] NOT NULL DEFAULT '{}',
+    updated_at  TIMESTAMPTZ NOT NULL,
+    updated_by  TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (name, version)
+);
+
+-- 8. Область изменений (база/роль) и ASH по базам и ролям.
+-- Пустые scope_database и scope_role — изменение для всего кластера (ALTER SYSTEM).
+ALTER TABLE profile_metrics.config_changes ADD COLUMN IF NOT EXISTS scope_database TEXT NOT NULL DEFAULT '';
+ALTER TABLE profile_metrics.config_changes ADD COLUMN IF NOT EXISTS scope_role TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE profile_metrics.ash_samples ADD COLUMN IF NOT EXISTS datname TEXT;
+ALTER TABLE profile_metrics.ash_samples ADD COLUMN IF NOT EXISTS usename TEXT;
+
+CREATE OR REPLACE FUNCTION profile_metrics.collect_ash() RETURNS void AS $$
+BEGIN
+    INSERT INTO profile_metrics.ash_samples (pid, wait_event_type, wait_event, state, query_id, query, datname, usename)
+    SELECT 
+        pid, 
+        wait_event_type, 
+        wait_event, 
+        state, 
+        query_id, 
+        left(query, 200), -- Берем первые 200 символов запроса
+        datname,
+        usename
+    FROM pg_stat_activity
+    WHERE state = 'active' 
+      AND pid != pg_backend_pid(); -- Исключаем сам процесс сбора
+END;
+$$ LANGUAGE plpgsql;
+
+-- 9. Интенсивность нагрузки в ground truth (клиенты, длительность, rate, scale)
+ALTER TABLE profile_metrics.diagnosis_history ADD COLUMN IF NOT EXISTS load_params JSONB;
+
+-- 10. Ожидаемый профиль сценария (expected_profile из манифеста) для оценки классификатора
+ALTER TABLE profile_metrics.diagnosis_history ADD COLUMN IF NOT EXISTS expected_profile TEXT;
+
+-- 11. Фаза составного сценария в ground truth
+ALTER TABLE profile_metrics.diagnosis_history ADD COLUMN IF NOT EXISTS phase TEXT;
+
+-- 12. Прогон нагрузки в ground truth: выгрузка датасета берет только диагнозы,
+-- снятые во время прогона, а не простой после него
+ALTER TABLE profile_metrics.diagnosis_history ADD COLUMN IF NOT EXISTS run_id BIGINT;
+
+-- 13. Начало фазы составного сценария: выгрузка датасета отбрасывает первое окно
+-- диагноза после начала прогона и каждой фазы (его метрики еще от прошлой нагрузки)
+ALTER TABLE profile_metrics.diagnosis_history ADD COLUMN IF NOT EXISTS phase_start TIMESTAMPTZ;
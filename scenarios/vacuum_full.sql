@@ -0,0 +1,2 @@
+-- Одна тяжелая операция обслуживания архива
+VACUUM FULL pgbench_accounts;